@@ -2,51 +2,192 @@ package app
 
 import (
 	"context"
-	"strings"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
 
 	"github.com/rs/zerolog"
 )
 
+// defaultShutdownTimeout is how long Start waits for in-flight handlers when Config.ShutdownTimeout is unset.
+const defaultShutdownTimeout = 10 * time.Second
+
+var (
+	// ErrSubscriptionClosed is returned by Start when the inbound subscription goes away without being asked to.
+	ErrSubscriptionClosed = errors.New("inbound subscription closed unexpectedly")
+
+	// ErrShutdownTimeout is returned by Start when in-flight handlers did not finish within the shutdown timeout.
+	ErrShutdownTimeout = errors.New("timed out waiting for in-flight handlers")
+)
+
 // App is the struct that represents the configuration and state of the app.
 type App struct {
 	Config Config
 
-	redis   *Redis
-	context context.Context
-	logger  zerolog.Logger
+	redis     *Redis          // Only connected when the store or the transport needs it
+	store     Store           // Where the game state lives. See app/store.go
+	transport Transport       // How messages get to and from the gateway. See app/transport.go
+	context   context.Context // Context for handlers. Only cancelled once draining has given up on them.
+	cancel    context.CancelFunc
+	logger    zerolog.Logger
+
+	scheduler *Scheduler          // Runs delayed actions like job payouts. See app/scheduler.go
+	settings  *guildSettingsCache // Recently used guild settings. See app/guildSettings.go
+	content   *contentLibrary     // The content packs jobs are made of. See app/contentPacks.go
+	seen      *seenAuthors        // Authors already written to the store. See app/leaderboard.go
+
+	stopping chan struct{}  // Closed when the app starts shutting down so long-running loops can bail out
+	inflight sync.WaitGroup // Tracks every goroutine started with a.spawn
 }
 
 // The start method wraps all the tasks necessary to start and manage the app.
 // It should be considered the "main" method of the app.
-// It also manages its own state, so it can be called multiple times.
-func (a *App) Start() error {
+// It blocks until ctx is cancelled or the process receives SIGINT/SIGTERM, then stops consuming
+// the inbound topic, waits for in-flight handlers to finish and closes the Redis connection, if there is one.
+// A clean shutdown returns nil.
+func (a *App) Start(ctx context.Context) error {
 	// Create a new logger
 	a.logger = a.NewLogger()
 
-	// Connect to Redis
+	// Stop on a signal as well as on the caller's context
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// Handlers get their own context so they can keep talking to the store while we drain them
+	a.context, a.cancel = context.WithCancel(context.Background())
+	defer a.cancel()
+	a.stopping = make(chan struct{})
+
+	// Set up the store and the transport
+	if err := a.connect(); err != nil {
+		return err
+	}
+
+	// Subscribe to the inbound topic and wait for the subscription to be confirmed
 	a.logger.Info().
-		Msg("connecting to redis")
-	redis, err := newRedis(a.Config.RedisHost, a.Config.RedisPort, "", 0)
+		Str("topic", a.Config.InboundTopic).
+		Msg("starting inbound listener")
+	topic, err := a.transport.Subscribe(ctx, a.Config.InboundTopic)
 	if err != nil {
-		return err
+		a.closeRedis()
+		return fmt.Errorf("failed to subscribe to %s: %w", a.Config.InboundTopic, err)
 	}
-	a.redis = redis
+
+	// Start running scheduled tasks, including anything that came due while we were down
+	// and jobs from before there were tasks
+	a.spawn(func() {
+		a.recoverJobs()
+		a.scheduler.run()
+	})
+
+	// Consume messages until we are told to stop
+	runErr := a.listen(ctx, topic.Channel())
+
 	a.logger.Info().
-		Msg("connected to redis!")
+		Msg("shutting down")
 
-	// Start the inbound listener in an anonymous goroutine
-	go func() {
+	// Stop consuming the inbound topic first so no new work shows up while we drain
+	if err := topic.Close(); err != nil {
+		a.logger.Error().
+			Err(err).
+			Msg("failed to close inbound subscription")
+	}
+
+	// Let long-running work know we're on our way out and wait for everything in flight
+	close(a.stopping)
+	if err := a.drain(a.shutdownTimeout()); err != nil {
+		a.logger.Error().
+			Err(err).
+			Msg("gave up waiting for in-flight handlers")
+		a.cancel()
+		if runErr == nil {
+			runErr = err
+		}
+	}
+
+	if err := a.closeRedis(); err != nil {
+		a.logger.Error().
+			Err(err).
+			Msg("failed to close redis")
+		if runErr == nil {
+			runErr = fmt.Errorf("failed to close redis: %w", err)
+		}
+	}
+
+	a.logger.Info().
+		Msg("shutdown complete")
+	return runErr
+}
+
+// connect sets up the store and the transport, and connects to Redis only if one of them needs it.
+// Without a store the game state is kept in Redis, and without a transport it's picked by Config.Transport.
+// A Redis store or transport from an earlier connection is replaced so it doesn't hang on to a closed client.
+func (a *App) connect() error {
+	_, redisStoreKept := a.store.(*redisStore)
+	needsStore := a.store == nil || redisStoreKept
+	_, redisTransportKept := a.transport.(*redisTransport)
+	needsTransport := redisTransportKept || (a.transport == nil && a.transportName() == TRANSPORT_REDIS)
+
+	if needsStore || needsTransport {
+		a.logger.Info().
+			Msg("connecting to redis")
+		client, err := newRedis(a.Config.RedisHost, a.Config.RedisPort, a.Config.RedisPassword, a.Config.RedisDB, a.Config.RedisTLS)
+		if err != nil {
+			return fmt.Errorf("failed to connect to redis: %w", err)
+		}
+		a.redis = client
 		a.logger.Info().
-			Msg("starting inbound listener")
+			Msg("connected to redis!")
+	}
+
+	if needsStore {
+		a.store = newRedisStore(a.redis)
+	}
+	switch {
+	case needsTransport:
+		a.transport = &redisTransport{client: a.redis}
+	case a.transport == nil:
+		a.transport = newConsoleTransport(os.Stdin, os.Stdout)
+		a.Config.Admins = append(a.Config.Admins, CONSOLE_USER) // Whoever's at the console runs the show
+	}
+	return nil
+}
 
-		// Subscribe to the inbound topic on the redis pubsub
-		topic := a.redis.Subscribe(a.context, a.Config.InboundTopic)
+// transportName returns the transport Config.Transport picks. Unset, it's Redis for the Redis store and the console otherwise.
+func (a *App) transportName() string {
+	if a.Config.Transport != "" {
+		return a.Config.Transport
+	}
+	if a.Config.Store == "memory" {
+		return TRANSPORT_CONSOLE
+	}
+	return TRANSPORT_REDIS
+}
 
-		// Create a go channel to receive messages from the topic
-		channel := topic.Channel()
+// closeRedis closes the connection to Redis, if there is one.
+func (a *App) closeRedis() error {
+	if a.redis == nil {
+		return nil
+	}
+	return a.redis.Close()
+}
+
+// listen consumes messages from the inbound channel until ctx is done.
+// It returns nil if the app was asked to stop and an error if the subscription went away on its own.
+func (a *App) listen(ctx context.Context, channel <-chan []byte) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case msg, ok := <-channel:
+			if !ok {
+				return ErrSubscriptionClosed
+			}
 
-		// Iterate over the messages from the channel
-		for msg := range channel {
 			a.logger.Debug().
 				Msg("received message")
 
@@ -59,37 +200,74 @@ func (a *App) Start() error {
 				continue // Skip this message and continue if we can't unmarshal it
 			}
 
-			// Handle the message. We are only interested in message that start with a command prefix for now.
-			if strings.HasPrefix(m.Content, "!") {
-				a.logger.Debug().
-					Msg("message is a command")
-
-				// the entrypoint for handling commands. located in app/commands.go
-				err = handleCommand(a, m)
-				if err != nil {
+			// the entrypoint for handling messages. located in app/commands.go
+			// Which messages are commands depends on the guild's settings, so that's worked out there.
+			a.spawn(func() {
+				if err := handleMessage(a, m); err != nil {
 					a.logger.Error().
 						Err(err).
 						Msg("error handling command")
 				}
-			} else {
-				a.logger.Debug().
-					Msg("message is not a command")
-			}
+			})
 		}
+	}
+}
+
+// spawn runs fn in a goroutine that shutdown will wait for.
+func (a *App) spawn(fn func()) {
+	a.inflight.Add(1)
+	go func() {
+		defer a.inflight.Done()
+		fn()
 	}()
+}
 
-	a.logger.Info().
-		Msg("Holding the app open")
-	for {
-		//
+// drain waits up to timeout for every goroutine started with spawn to return.
+func (a *App) drain(timeout time.Duration) error {
+	done := make(chan struct{})
+	go func() {
+		a.inflight.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-time.After(timeout):
+		return ErrShutdownTimeout
 	}
-	return nil
+}
+
+// shutdownTimeout returns the configured shutdown timeout or the default if it's unset.
+func (a *App) shutdownTimeout() time.Duration {
+	if a.Config.ShutdownTimeout <= 0 {
+		return defaultShutdownTimeout
+	}
+	return a.Config.ShutdownTimeout
 }
 
 // NewApp creates a new app instance with the given configuration.
-func NewApp(config Config) (*App, error) {
-	return &App{
-		Config:  config,
-		context: context.Background(),
-	}, nil
+// The app keeps its state in store. If store is nil, the state is kept in the Redis instance the app connects to.
+// The app only connects to Redis if the store or the transport (see Config.Transport) is Redis.
+// It fails if the content packs don't load.
+func NewApp(config Config, store Store) (*App, error) {
+	content, err := newContentLibrary(config.ContentDir, config.ContentPack)
+	if err != nil {
+		return nil, err
+	}
+
+	a := &App{
+		Config:   config,
+		store:    store,
+		context:  context.Background(),
+		settings: newGuildSettingsCache(),
+		content:  content,
+		seen:     &seenAuthors{names: map[string]string{}},
+	}
+
+	// Register the handlers for every kind of scheduled task
+	a.scheduler = newScheduler(a)
+	a.scheduler.Handle(TASK_JOB_COMPLETE, handleJobCompleteTask)
+
+	return a, nil
 }
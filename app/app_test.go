@@ -0,0 +1,118 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/bytebot-chat/gateway-discord/model"
+	"github.com/rs/zerolog"
+	uuid "github.com/satori/go.uuid"
+)
+
+// Where test messages come from
+const (
+	testGateway = "discord"
+	testGuild   = "guild"
+	testChannel = "channel"
+)
+
+// testTransport keeps everything the app publishes, so tests can check what it said.
+type testTransport struct {
+	mu   sync.Mutex
+	sent []model.MessageSend
+}
+
+func (t *testTransport) Subscribe(ctx context.Context, topic string) (Subscription, error) {
+	return nil, errors.New("tests don't subscribe")
+}
+
+func (t *testTransport) Publish(ctx context.Context, topic string, payload []byte) error {
+	var m model.MessageSend
+	if err := json.Unmarshal(payload, &m); err != nil {
+		return err
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.sent = append(t.sent, m)
+	return nil
+}
+
+// replies returns the contents of everything published since the last call.
+func (t *testTransport) replies() []string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	contents := make([]string, len(t.sent))
+	for i, m := range t.sent {
+		contents[i] = m.Content
+	}
+	t.sent = nil
+	return contents
+}
+
+// newTestApp returns an app on a memory store that's ready to handle messages without a transport to the outside.
+func newTestApp(t *testing.T) (*App, *testTransport) {
+	t.Helper()
+	a, err := NewApp(DefaultConfig(), NewMemoryStore())
+	if err != nil {
+		t.Fatal(err)
+	}
+	transport := &testTransport{}
+	a.transport = transport
+	a.logger = zerolog.Nop()
+	a.stopping = make(chan struct{})
+	return a, transport
+}
+
+// testMessage returns a message from the user in the test server's channel.
+func testMessage(userID, content string) *Message {
+	return &Message{&model.Message{
+		Message: &discordgo.Message{
+			ID:        uuid.NewV4().String(),
+			ChannelID: testChannel,
+			GuildID:   testGuild,
+			Content:   content,
+			Author:    &discordgo.User{ID: userID, Username: "user" + userID},
+			Member:    &discordgo.Member{},
+		},
+		Metadata: model.Metadata{Source: testGateway, ID: uuid.NewV4()},
+	}}
+}
+
+// send has the app handle a message from the user and returns what it said back.
+func send(t *testing.T, a *App, transport *testTransport, userID, content string) []string {
+	t.Helper()
+	if err := handleMessage(a, testMessage(userID, content)); err != nil {
+		t.Fatalf("%s: %v", content, err)
+	}
+	return transport.replies()
+}
+
+// setBalance gives the user a balance the way the game would, with a ledger entry for it.
+func setBalance(t *testing.T, a *App, userID string, balance int) {
+	t.Helper()
+	if _, err := a.updateProfile(userID, func(p *Profile) error {
+		if balance != p.Balance {
+			p.adjustBalance(balance-p.Balance, LEDGER_WORK, "")
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// balanceOf returns the user's balance.
+func balanceOf(t *testing.T, a *App, userID string) int {
+	t.Helper()
+	p, err := a.findProfile(userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p == nil {
+		t.Fatalf("user %s has no profile", userID)
+	}
+	return p.Balance
+}
@@ -0,0 +1,295 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+/*
+Arguments
+
+Messages are split into words by tokenize, which collapses runs of whitespace and keeps quoted text together,
+so `!buy "rat meat pie" 2` is three words. The words left over once the router has found the command are
+checked against the command's ArgSpecs and converted to the right types before the handler ever sees them.
+If that fails the user gets told what was wrong with which argument, along with the command's usage.
+*/
+
+// ArgType is the kind of value an argument holds.
+type ArgType int
+
+const (
+	ArgString   ArgType = iota // Any word
+	ArgInt                     // A whole number, e.g. 3 or -1
+	ArgAmount                  // A positive amount of money, with optional k/m suffix, e.g. 250, 1.5k or 2m
+	ArgUser                    // A Discord user, either a mention like <@1234> or a raw ID
+	ArgDuration                // A duration like 90s, 15m, 2h or 1d
+	ArgEnum                    // One of ArgSpec.Choices
+	ArgChannel                 // A Discord channel, either a link like <#1234> or a raw ID
+)
+
+// ArgSpec describes an argument a command takes.
+type ArgSpec struct {
+	Name        string   // Name shown in usage, e.g. "job"
+	Description string   // What the argument is for
+	Type        ArgType  // What kind of value the argument holds. Defaults to ArgString.
+	Choices     []string // Allowed values for ArgEnum
+	Optional    bool     // Whether the argument can be left out. Only trailing arguments can be optional.
+	Rest        bool     // Swallow the rest of the message. Only the last argument can do this and it's always a string.
+}
+
+// Args holds the arguments of a command after they've been checked against its ArgSpecs.
+type Args struct {
+	Raw    []string               // The words after the command, as typed
+	Prefix string                 // The prefix the command was typed with, for telling people what to type next
+	values map[string]interface{} // Parsed values by argument name
+}
+
+// ArgError is returned when an argument is missing or can't be parsed.
+// Its message is meant to be shown to the user.
+type ArgError struct {
+	Arg    string // Name of the argument
+	Value  string // What the user typed, if anything
+	Reason string // What's wrong with it
+}
+
+func (e *ArgError) Error() string {
+	if e.Value == "" {
+		return fmt.Sprintf("%s %s", e.Arg, e.Reason)
+	}
+	return fmt.Sprintf("%s %s, got `%s`", e.Arg, e.Reason, e.Value)
+}
+
+// ErrUnterminatedQuote is returned by tokenize when a quote is never closed.
+var ErrUnterminatedQuote = errors.New("you opened a quote but never closed it")
+
+// mentionPattern matches a Discord user mention, with or without the nickname marker.
+var mentionPattern = regexp.MustCompile(`^<@!?(\d+)>$`)
+
+// channelPattern matches a Discord channel link.
+var channelPattern = regexp.MustCompile(`^<#(\d+)>$`)
+
+// snowflakePattern matches a raw Discord ID.
+var snowflakePattern = regexp.MustCompile(`^\d{15,21}$`)
+
+// tokenize splits a message into words. Runs of whitespace count as one separator and
+// text in double quotes (straight or curly) stays together as one word, with \" for a literal quote.
+func tokenize(s string) ([]string, error) {
+	words := []string{}
+	var word strings.Builder
+	inWord, inQuote := false, false
+
+	runes := []rune(s)
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+		switch {
+		case inQuote && r == '\\' && i+1 < len(runes) && isQuote(runes[i+1]):
+			i++
+			word.WriteRune(runes[i])
+		case isQuote(r):
+			inQuote = !inQuote
+			inWord = true // "" is an empty word, not nothing
+		case unicode.IsSpace(r) && !inQuote:
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+		default:
+			word.WriteRune(r)
+			inWord = true
+		}
+	}
+
+	if inQuote {
+		return nil, ErrUnterminatedQuote
+	}
+	if inWord {
+		words = append(words, word.String())
+	}
+	return words, nil
+}
+
+// isQuote reports whether r opens or closes a quoted word.
+func isQuote(r rune) bool {
+	return r == '"' || r == '“' || r == '”'
+}
+
+// parseArgs checks words against specs and converts them to the right types.
+func parseArgs(specs []ArgSpec, words []string) (*Args, error) {
+	args := &Args{
+		Raw:    words,
+		values: map[string]interface{}{},
+	}
+
+	for i, spec := range specs {
+		if i >= len(words) {
+			if spec.Optional {
+				continue
+			}
+			return nil, &ArgError{Arg: spec.Name, Reason: "is missing"}
+		}
+
+		if spec.Rest {
+			args.values[spec.Name] = strings.Join(words[i:], " ")
+			return args, nil
+		}
+
+		value, err := parseArg(spec, words[i])
+		if err != nil {
+			return nil, err
+		}
+		args.values[spec.Name] = value
+	}
+
+	// Commands that don't declare arguments don't care about leftovers. Commands that do get held to them.
+	if len(specs) > 0 && len(words) > len(specs) {
+		return nil, &ArgError{Arg: "`" + strings.Join(words[len(specs):], " ") + "`", Reason: "is more than this command takes"}
+	}
+	return args, nil
+}
+
+// parseArg converts a single word according to its spec.
+func parseArg(spec ArgSpec, word string) (interface{}, error) {
+	fail := func(reason string) error {
+		return &ArgError{Arg: spec.Name, Value: word, Reason: reason}
+	}
+
+	switch spec.Type {
+	case ArgInt:
+		n, err := strconv.Atoi(word)
+		if err != nil {
+			return nil, fail("must be a whole number")
+		}
+		return n, nil
+
+	case ArgAmount:
+		n, err := parseAmount(word)
+		if err != nil {
+			return nil, fail("must be an amount like 250, 1.5k or 2m")
+		}
+		if n <= 0 {
+			return nil, fail("must be more than zero")
+		}
+		return n, nil
+
+	case ArgUser:
+		if match := mentionPattern.FindStringSubmatch(word); match != nil {
+			return match[1], nil
+		}
+		if snowflakePattern.MatchString(word) {
+			return word, nil
+		}
+		return nil, fail("must be a user, like @somebody")
+
+	case ArgChannel:
+		id, ok := parseChannel(word)
+		if !ok {
+			return nil, fail("must be a channel, like #general")
+		}
+		return id, nil
+
+	case ArgDuration:
+		d, err := parseDuration(word)
+		if err != nil || d <= 0 {
+			return nil, fail("must be a duration like 90s, 15m, 2h or 1d")
+		}
+		return d, nil
+
+	case ArgEnum:
+		for _, choice := range spec.Choices {
+			if strings.EqualFold(word, choice) {
+				return choice, nil
+			}
+		}
+		return nil, fail("must be one of " + strings.Join(spec.Choices, ", "))
+
+	default:
+		return word, nil
+	}
+}
+
+// parseChannel returns the ID of a channel given as a link or a raw ID.
+func parseChannel(word string) (string, bool) {
+	if match := channelPattern.FindStringSubmatch(word); match != nil {
+		return match[1], true
+	}
+	if snowflakePattern.MatchString(word) {
+		return word, true
+	}
+	return "", false
+}
+
+// parseAmount parses an amount of money with an optional k (thousand) or m (million) suffix.
+// Commas are ignored so 1,000 works too. Fractions are allowed as long as the result is a whole number.
+func parseAmount(s string) (int, error) {
+	s = strings.ToLower(strings.ReplaceAll(s, ",", ""))
+
+	multiplier := 1.0
+	switch {
+	case strings.HasSuffix(s, "k"):
+		multiplier, s = 1e3, strings.TrimSuffix(s, "k")
+	case strings.HasSuffix(s, "m"):
+		multiplier, s = 1e6, strings.TrimSuffix(s, "m")
+	}
+
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, err
+	}
+	f *= multiplier
+	if f != float64(int64(f)) || f > 1e15 || f < -1e15 {
+		return 0, fmt.Errorf("%s is not a whole amount", s)
+	}
+	return int(f), nil
+}
+
+// parseDuration is time.ParseDuration with support for whole days, e.g. "2d".
+func parseDuration(s string) (time.Duration, error) {
+	if strings.HasSuffix(s, "d") {
+		days, err := strconv.Atoi(strings.TrimSuffix(s, "d"))
+		if err != nil {
+			return 0, err
+		}
+		return time.Duration(days) * 24 * time.Hour, nil
+	}
+	return time.ParseDuration(s)
+}
+
+// Has reports whether an optional argument was given.
+func (a *Args) Has(name string) bool {
+	_, ok := a.values[name]
+	return ok
+}
+
+// String returns a string argument, or "" if it wasn't given.
+func (a *Args) String(name string) string {
+	s, _ := a.values[name].(string)
+	return s
+}
+
+// Int returns an int or amount argument, or 0 if it wasn't given.
+func (a *Args) Int(name string) int {
+	n, _ := a.values[name].(int)
+	return n
+}
+
+// User returns the ID of a user argument, or "" if it wasn't given.
+func (a *Args) User(name string) string {
+	return a.String(name)
+}
+
+// Channel returns the ID of a channel argument, or "" if it wasn't given.
+func (a *Args) Channel(name string) string {
+	return a.String(name)
+}
+
+// Duration returns a duration argument, or 0 if it wasn't given.
+func (a *Args) Duration(name string) time.Duration {
+	d, _ := a.values[name].(time.Duration)
+	return d
+}
@@ -0,0 +1,167 @@
+package app
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestTokenize(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  []string
+		err   error
+	}{
+		{"empty", "", []string{}, nil},
+		{"whitespace", "  \t ", []string{}, nil},
+		{"words", "jobs take 3", []string{"jobs", "take", "3"}, nil},
+		{"runs of whitespace", "  jobs \t take  3 ", []string{"jobs", "take", "3"}, nil},
+		{"quoted", `buy "rat meat pie" 2`, []string{"buy", "rat meat pie", "2"}, nil},
+		{"curly quotes", "buy “rat meat pie” 2", []string{"buy", "rat meat pie", "2"}, nil},
+		{"empty quotes", `pay @x 5 ""`, []string{"pay", "@x", "5", ""}, nil},
+		{"quote inside a word", `say a"b c"d`, []string{"say", "ab cd"}, nil},
+		{"escaped quote", `say "a \"b\" c"`, []string{"say", `a "b" c`}, nil},
+		{"backslash outside quotes", `say a\"b"`, []string{"say", `a\b`}, nil},
+		{"unterminated", `buy "rat meat pie`, nil, ErrUnterminatedQuote},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tokenize(tt.input)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("tokenize(%q) error = %v, want %v", tt.input, err, tt.err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("tokenize(%q) = %q, want %q", tt.input, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseArgs(t *testing.T) {
+	pay := []ArgSpec{
+		{Name: "user", Type: ArgUser},
+		{Name: "amount", Type: ArgAmount},
+		{Name: "memo", Optional: true, Rest: true},
+	}
+	tests := []struct {
+		name   string
+		specs  []ArgSpec
+		words  []string
+		want   map[string]interface{}
+		errArg string // The argument the error is about, if there should be one
+	}{
+		{"no specs take anything", nil, []string{"whatever", "else"}, map[string]interface{}{}, ""},
+		{"mention", pay, []string{"<@1234>", "5"}, map[string]interface{}{"user": "1234", "amount": 5}, ""},
+		{"nickname mention", pay, []string{"<@!1234>", "5"}, map[string]interface{}{"user": "1234", "amount": 5}, ""},
+		{"raw ID", pay, []string{"123456789012345678", "1.5k"}, map[string]interface{}{"user": "123456789012345678", "amount": 1500}, ""},
+		{"rest", pay, []string{"<@1>", "5", "for", "the", "pie"}, map[string]interface{}{"user": "1", "amount": 5, "memo": "for the pie"}, ""},
+		{"not a user", pay, []string{"bob", "5"}, nil, "user"},
+		{"short ID", pay, []string{"1234", "5"}, nil, "user"},
+		{"zero amount", pay, []string{"<@1>", "0"}, nil, "amount"},
+		{"negative amount", pay, []string{"<@1>", "-5"}, nil, "amount"},
+		{"missing", pay, []string{"<@1>"}, nil, "amount"},
+		{"int", []ArgSpec{{Name: "job", Type: ArgInt}}, []string{"-1"}, map[string]interface{}{"job": -1}, ""},
+		{"not an int", []ArgSpec{{Name: "job", Type: ArgInt}}, []string{"three"}, nil, "job"},
+		{"too many", []ArgSpec{{Name: "job", Type: ArgInt}}, []string{"3", "4"}, nil, "`4`"},
+		{"optional left out", []ArgSpec{{Name: "page", Type: ArgInt, Optional: true}}, []string{}, map[string]interface{}{}, ""},
+		{"enum", []ArgSpec{{Name: "where", Type: ArgEnum, Choices: []string{"channel", "dm"}}}, []string{"DM"}, map[string]interface{}{"where": "dm"}, ""},
+		{"not in enum", []ArgSpec{{Name: "where", Type: ArgEnum, Choices: []string{"channel", "dm"}}}, []string{"email"}, nil, "where"},
+		{"duration", []ArgSpec{{Name: "for", Type: ArgDuration}}, []string{"2d"}, map[string]interface{}{"for": 48 * time.Hour}, ""},
+		{"zero duration", []ArgSpec{{Name: "for", Type: ArgDuration}}, []string{"0s"}, nil, "for"},
+		{"channel", []ArgSpec{{Name: "channel", Type: ArgChannel}}, []string{"<#1234>"}, map[string]interface{}{"channel": "1234"}, ""},
+		{"not a channel", []ArgSpec{{Name: "channel", Type: ArgChannel}}, []string{"#general"}, nil, "channel"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			args, err := parseArgs(tt.specs, tt.words)
+			if tt.errArg != "" {
+				var argErr *ArgError
+				if !errors.As(err, &argErr) {
+					t.Fatalf("parseArgs(%q) error = %v, want an ArgError", tt.words, err)
+				}
+				if argErr.Arg != tt.errArg {
+					t.Errorf("parseArgs(%q) error is about %s, want %s", tt.words, argErr.Arg, tt.errArg)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseArgs(%q) error = %v", tt.words, err)
+			}
+			if !reflect.DeepEqual(args.values, tt.want) {
+				t.Errorf("parseArgs(%q) = %v, want %v", tt.words, args.values, tt.want)
+			}
+			if !reflect.DeepEqual(args.Raw, tt.words) {
+				t.Errorf("parseArgs(%q) kept %q as typed", tt.words, args.Raw)
+			}
+		})
+	}
+}
+
+func TestParseAmount(t *testing.T) {
+	tests := []struct {
+		input string
+		want  int
+		ok    bool
+	}{
+		{"250", 250, true},
+		{"0", 0, true},
+		{"-5", -5, true},
+		{"1,000", 1000, true},
+		{"1k", 1000, true},
+		{"1.5k", 1500, true},
+		{"1.5K", 1500, true},
+		{"2m", 2000000, true},
+		{"0.25k", 250, true},
+		{"1.5", 0, false},
+		{"1.0001k", 0, false},
+		{"k", 0, false},
+		{"", 0, false},
+		{"ten", 0, false},
+		{"2b", 0, false},
+		{"1e20", 0, false},
+		{"2000000000m", 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			got, err := parseAmount(tt.input)
+			if (err == nil) != tt.ok {
+				t.Fatalf("parseAmount(%q) error = %v, want ok %v", tt.input, err, tt.ok)
+			}
+			if got != tt.want {
+				t.Errorf("parseAmount(%q) = %d, want %d", tt.input, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseDuration(t *testing.T) {
+	tests := []struct {
+		input string
+		want  time.Duration
+		ok    bool
+	}{
+		{"90s", 90 * time.Second, true},
+		{"15m", 15 * time.Minute, true},
+		{"2h", 2 * time.Hour, true},
+		{"1h30m", 90 * time.Minute, true},
+		{"1d", 24 * time.Hour, true},
+		{"7d", 7 * 24 * time.Hour, true},
+		{"1.5d", 0, false},
+		{"d", 0, false},
+		{"", 0, false},
+		{"soon", 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			got, err := parseDuration(tt.input)
+			if (err == nil) != tt.ok {
+				t.Fatalf("parseDuration(%q) error = %v, want ok %v", tt.input, err, tt.ok)
+			}
+			if got != tt.want {
+				t.Errorf("parseDuration(%q) = %s, want %s", tt.input, got, tt.want)
+			}
+		})
+	}
+}
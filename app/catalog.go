@@ -0,0 +1,136 @@
+package app
+
+import (
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+/*
+The Item Catalog
+
+Everything a user can own is described in the item catalog, which lives in app/data/items.json rather than in code
+so that adding an item to the game doesn't mean touching Go. The catalog is compiled into the binary and checked
+when the app starts: a broken catalog is a programming error, so it panics like a duplicate command does.
+
+Inventories only store item IDs, so renaming an item or changing its price is safe. Removing one is not.
+*/
+
+//go:embed data/items.json
+var itemCatalogJSON []byte
+
+// Rarities, from most to least common
+var itemRarities = []string{"common", "uncommon", "rare", "epic", "legendary"}
+
+// Item describes something a user can own.
+type Item struct {
+	ID          string `json:"id"`          // Stable ID stored in inventories. Lowercase with dashes, e.g. "stim-pack".
+	Name        string `json:"name"`        // What the item is called in chat
+	Price       int    `json:"price"`       // What the shop charges for one
+	Rarity      string `json:"rarity"`      // One of itemRarities
+	Description string `json:"description"` // Flavor text
+	StackLimit  int    `json:"stack_limit"` // Most of the item a user can hold at once
+	Instanced   bool   `json:"instanced"`   // Every one is kept separately with its own metadata instead of in a stack
+}
+
+// Catalog is the list of every item in the game.
+type Catalog struct {
+	Items []*Item          `json:"items"`
+	byID  map[string]*Item // Items by ID
+}
+
+// catalog is the item catalog the game uses.
+var catalog = mustLoadCatalog(itemCatalogJSON)
+
+// mustLoadCatalog loads the built in catalog and panics if it's broken.
+func mustLoadCatalog(data []byte) *Catalog {
+	c, err := loadCatalog(data)
+	if err != nil {
+		panic(fmt.Sprintf("item catalog: %v", err))
+	}
+	return c
+}
+
+// loadCatalog parses and validates a catalog.
+func loadCatalog(data []byte) (*Catalog, error) {
+	var c Catalog
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, err
+	}
+
+	c.byID = map[string]*Item{}
+	for i, item := range c.Items {
+		if err := item.validate(); err != nil {
+			return nil, fmt.Errorf("item %d (%q): %w", i, item.ID, err)
+		}
+		if _, ok := c.byID[item.ID]; ok {
+			return nil, fmt.Errorf("item %q is listed twice", item.ID)
+		}
+		c.byID[item.ID] = item
+	}
+	if len(c.deliverable()) == 0 {
+		return nil, errors.New("delivery jobs need at least one item that isn't instanced")
+	}
+	return &c, nil
+}
+
+// deliverable returns the items delivery jobs can ask for: the ones that come in stacks,
+// since one of a kind items aren't for handing over.
+func (c *Catalog) deliverable() []*Item {
+	items := []*Item{}
+	for _, item := range c.Items {
+		if !item.Instanced {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// validate checks that an item makes sense.
+func (i *Item) validate() error {
+	switch {
+	case i.ID == "" || i.ID != strings.ToLower(i.ID) || strings.ContainsAny(i.ID, " \t"):
+		return errors.New("id must be lowercase without spaces")
+	case i.Name == "":
+		return errors.New("name must not be empty")
+	case i.Price <= 0:
+		return errors.New("price must be positive")
+	case i.StackLimit <= 0:
+		return errors.New("stack_limit must be positive")
+	case rarityRank(i.Rarity) < 0:
+		return fmt.Errorf("rarity must be one of %s", strings.Join(itemRarities, ", "))
+	}
+	return nil
+}
+
+// rarityRank returns how rare a rarity is, 0 being the most common. Unknown rarities are -1.
+func rarityRank(rarity string) int {
+	for i, r := range itemRarities {
+		if r == rarity {
+			return i
+		}
+	}
+	return -1
+}
+
+// item returns the item with the given ID, or nil if there isn't one.
+func (c *Catalog) item(id string) *Item {
+	return c.byID[id]
+}
+
+// find looks an item up the way a user would type it: by ID or by name, ignoring case,
+// with spaces standing in for dashes. It returns nil if nothing matches.
+func (c *Catalog) find(query string) *Item {
+	query = strings.ToLower(strings.TrimSpace(query))
+	if item := c.byID[strings.ReplaceAll(query, " ", "-")]; item != nil {
+		return item
+	}
+	for _, item := range c.Items {
+		if strings.ToLower(item.Name) == query {
+			return item
+		}
+	}
+	return nil
+}
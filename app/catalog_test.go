@@ -0,0 +1,25 @@
+package app
+
+import "testing"
+
+func TestLoadCatalogNeedsDeliverableItem(t *testing.T) {
+	stacked := `{"id": "pie", "name": "Pie", "price": 5, "rarity": "common", "stack_limit": 10}`
+	instanced := `{"id": "sword", "name": "Sword", "price": 50, "rarity": "rare", "stack_limit": 1, "instanced": true}`
+	tests := []struct {
+		name  string
+		items string
+		ok    bool
+	}{
+		{"stacked and instanced", stacked + ", " + instanced, true},
+		{"only instanced", instanced, false},
+		{"empty", ``, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := loadCatalog([]byte(`{"items": [` + tt.items + `]}`))
+			if (err == nil) != tt.ok {
+				t.Errorf("error = %v, want ok %v", err, tt.ok)
+			}
+		})
+	}
+}
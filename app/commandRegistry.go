@@ -0,0 +1,220 @@
+package app
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+)
+
+/*
+The Command Registry
+
+Every chat command is described by a Command: its name, aliases, arguments, help text, handler and sub-commands.
+Subsystems register their command tree from an init function in their own file, so adding a new subsystem
+never means touching the router.
+
+The router parses a message once and walks the tree as far as the words in the message match
+a command or one of its aliases. Whatever is left over is checked against the command's ArgSpecs
+and handed to the handler as arguments. See app/arguments.go
+*/
+
+// CommandHandler handles a command. args holds the command's arguments, already checked against its ArgSpecs.
+type CommandHandler func(a *App, m *Message, args *Args) error
+
+// Command describes a chat command or sub-command.
+type Command struct {
+	Name        string         // Name users type to run the command
+	Aliases     []string       // Other names for the command
+	Group       string         // Subsystem the command belongs to. Sub-commands inherit it from their parent.
+	AdminOnly   bool           // Only admins can run the command or its sub-commands. See App.isAdmin
+	LockedAt    Standing       // Users in this standing or worse can't run the command or its sub-commands. See app/demerits.go
+	Args        []ArgSpec      // Arguments the command takes, in order
+	Summary     string         // One line description for listings
+	Help        string         // Longer description for detailed help. Commands in it start with {prefix}, see helpPrefix
+	Examples    []string       // Example invocations, without the prefix
+	Handler     CommandHandler // Runs the command. Commands without a handler only group sub-commands.
+	Subcommands []*Command     // Commands nested under this one
+
+	parent *Command
+}
+
+// CommandRegistry holds every registered command and routes messages to them.
+type CommandRegistry struct {
+	mu       sync.RWMutex
+	commands []*Command          // Top level commands in registration order
+	byName   map[string]*Command // Top level commands by name and alias
+}
+
+// commands is the registry every subsystem registers its commands with.
+var commands = newCommandRegistry()
+
+// newCommandRegistry creates an empty registry.
+func newCommandRegistry() *CommandRegistry {
+	return &CommandRegistry{
+		byName: map[string]*Command{},
+	}
+}
+
+// Register adds top level commands to the registry.
+// It panics if a name or alias is already taken, since that's a programming error that would make a command unreachable.
+func (r *CommandRegistry) Register(cmds ...*Command) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, cmd := range cmds {
+		for _, name := range cmd.names() {
+			if _, ok := r.byName[name]; ok {
+				panic(fmt.Sprintf("command %q registered twice", name))
+			}
+			r.byName[name] = cmd
+		}
+		cmd.link(nil)
+		r.commands = append(r.commands, cmd)
+	}
+}
+
+// Commands returns the top level commands in registration order.
+func (r *CommandRegistry) Commands() []*Command {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return append([]*Command{}, r.commands...)
+}
+
+// Resolve walks the command tree as far as the words match and returns the deepest command along with the remaining words.
+// It returns nil if the first word isn't a command.
+func (r *CommandRegistry) Resolve(words []string) (*Command, []string) {
+	if len(words) == 0 {
+		return nil, words
+	}
+
+	r.mu.RLock()
+	cmd, ok := r.byName[strings.ToLower(words[0])]
+	r.mu.RUnlock()
+	if !ok {
+		return nil, words
+	}
+
+	args := words[1:]
+	for len(args) > 0 {
+		sub := cmd.subcommand(args[0])
+		if sub == nil {
+			break
+		}
+		cmd, args = sub, args[1:]
+	}
+	return cmd, args
+}
+
+// Dispatch runs the command a message asks for. content is the message without the command prefix,
+// which is passed along so replies can tell people what to type.
+func (r *CommandRegistry) Dispatch(a *App, m *Message, prefix, content string) error {
+	words, err := tokenize(content)
+	if err != nil {
+		return a.handleOutgoingMessage(m.RespondToChannelOrThread(a.Config.AppID, "That didn't work: "+err.Error()+".", true, false))
+	}
+
+	cmd, rest := r.Resolve(words)
+	if cmd == nil {
+		return handleUnknownCommand(a, m, prefix, words)
+	}
+
+	// A command that groups sub-commands and takes no arguments of its own can't do anything with leftovers
+	if len(rest) > 0 && len(cmd.Subcommands) > 0 && len(cmd.Args) == 0 {
+		return handleUnknownSubcommand(a, m, prefix, cmd, rest)
+	}
+
+	if cmd.Handler == nil {
+		return handleUnknownSubcommand(a, m, prefix, cmd, rest)
+	}
+
+	if cmd.adminOnly() && !a.isAdmin(m) {
+		a.logger.Info().
+			Str("user", m.Author.Username).
+			Str("command", cmd.Path()).
+			Msg("User tried to run admin command")
+		return a.handleOutgoingMessage(m.RespondToChannelOrThread(a.Config.AppID, "Only server admins can do that.", true, false))
+	}
+
+	if ok, err := a.checkStanding(m, prefix, cmd); !ok || err != nil {
+		return err
+	}
+
+	args, err := parseArgs(cmd.Args, rest)
+	if err != nil {
+		return handleBadArguments(a, m, prefix, cmd, err)
+	}
+	args.Prefix = prefix
+
+	a.logger.Debug().
+		Str("user", m.Author.Username).
+		Str("command", cmd.Path()).
+		Strs("args", rest).
+		Msg("dispatching command")
+
+	return cmd.Handler(a, m, args)
+}
+
+// Path returns the full name of the command, e.g. "jobs take".
+func (c *Command) Path() string {
+	if c.parent == nil {
+		return c.Name
+	}
+	return c.parent.Path() + " " + c.Name
+}
+
+// Usage returns the command with its arguments, e.g. "jobs take <job>".
+// Optional arguments are shown in square brackets.
+func (c *Command) Usage() string {
+	usage := c.Path()
+	for _, arg := range c.Args {
+		if arg.Optional {
+			usage += " [" + arg.Name + "]"
+		} else {
+			usage += " <" + arg.Name + ">"
+		}
+	}
+	return usage
+}
+
+// adminOnly reports whether the command or any of its parents is limited to admins.
+func (c *Command) adminOnly() bool {
+	for cmd := c; cmd != nil; cmd = cmd.parent {
+		if cmd.AdminOnly {
+			return true
+		}
+	}
+	return false
+}
+
+// names returns the name and aliases of the command, lowercased.
+func (c *Command) names() []string {
+	names := []string{strings.ToLower(c.Name)}
+	for _, alias := range c.Aliases {
+		names = append(names, strings.ToLower(alias))
+	}
+	return names
+}
+
+// subcommand returns the sub-command with the given name or alias, or nil if there isn't one.
+func (c *Command) subcommand(name string) *Command {
+	name = strings.ToLower(name)
+	for _, sub := range c.Subcommands {
+		for _, n := range sub.names() {
+			if n == name {
+				return sub
+			}
+		}
+	}
+	return nil
+}
+
+// link points every sub-command at its parent and passes the group down the tree.
+func (c *Command) link(parent *Command) {
+	c.parent = parent
+	if c.Group == "" && parent != nil {
+		c.Group = parent.Group
+	}
+	for _, sub := range c.Subcommands {
+		sub.link(c)
+	}
+}
@@ -1,60 +1,133 @@
 package app
 
-import "strings"
+import (
+	"fmt"
+	"strings"
+)
 
 const infoResponse = `
 ** Don't Break the Chat ** is an experimental chat-based game using the Bytebot ecosystem. It's a work in progress.
 
 Follow the project on Github at https://github.com/bytebot-chat/dont-break-the-chat
 `
-const helpResponse = `
-** Don't Break the Chat ** is an experimental chat-based game using the Bytebot ecosystem. It's a work in progress.
 
-## How to play
-jk there's no way to play yet
+func init() {
+	commands.Register(
+		&Command{
+			Name:    "info",
+			Group:   "General",
+			Summary: "Get information about the game",
+			Handler: handleInfo,
+		},
+		&Command{
+			Name:    "help",
+			Group:   "General",
+			Summary: "Get help with the game",
+			Help:    "Lists every command, or shows the details of a single command.",
+			Args: []ArgSpec{
+				{Name: "command", Description: "Command to get help with", Optional: true, Rest: true},
+			},
+			Examples: []string{"help", "help jobs", "help jobs take"},
+			Handler:  handleHelp,
+		},
+	)
+}
 
-## Commands
-- !info - Get information about the game
-- !help - Get help with the game
-- !work - Work for money
-- !balance - Check your balance
-- !jobs - List available jobs and their requirements
+// handleMessage is the entrypoint for every message. Messages that start with the guild's command prefix
+// are handed to the command registry, which works out which command it is and calls its handler. See app/commandRegistry.go
+func handleMessage(a *App, m *Message) error {
+	// Remember who we've seen where, for the leaderboards
+	a.rememberAuthor(m)
 
-File an issue on Github at https://github.com/bytebot-chat/dont-break-the-chat/issues
-`
+	settings, err := a.guildSettings(m.GuildID)
+	if err != nil {
+		return err
+	}
+
+	if !strings.HasPrefix(m.Content, settings.Prefix) {
+		a.logger.Debug().
+			Msg("message is not a command")
+		return nil
+	}
+	content := strings.TrimPrefix(m.Content, settings.Prefix)
 
-func handleCommand(a *App, m *Message) error {
-	splitCmd := strings.Split(m.Content, " ")
-	cmd := splitCmd[0]
-
-	switch cmd {
-	case "!info":
-		return handleInfo(a, m)
-	case "!help":
-		return handleHelp(a, m)
-	case "!work":
-		return handleWork(a, m)
-	case "!balance":
-		return handleBalance(a, m)
-	case "!jobs":
-		return handleJobs(a, m)
-	default:
-		return handleUnknownCommand(a, m)
+	// Admins can always reach !config, otherwise a bad channel list could lock them out of fixing it
+	if !settings.respondsIn(m.ChannelID) && !(isConfigCommand(content) && a.isAdmin(m)) {
+		a.logger.Debug().
+			Str("channel", m.ChannelID).
+			Str("guild", m.GuildID).
+			Msg("ignoring command in disabled channel")
+		return nil
 	}
+
+	a.logger.Debug().
+		Msg("message is a command")
+
+	return commands.Dispatch(a, m, settings.Prefix, content)
+}
+
+// isConfigCommand reports whether a command (without its prefix) is !config or one of its sub-commands.
+func isConfigCommand(content string) bool {
+	words := strings.Fields(content)
+	return len(words) > 0 && strings.EqualFold(words[0], "config")
 }
 
 // handleInfo handles the !info command.
-func handleInfo(a *App, m *Message) error {
-	resp := m.RespondToChannelOrThread("dbtg", infoResponse, true, false)
+func handleInfo(a *App, m *Message, args *Args) error {
+	resp := m.RespondToChannelOrThread(a.Config.AppID, infoResponse, true, false)
 	return a.handleOutgoingMessage(resp)
 }
 
-// handleHelp handles the !help command.
-func handleHelp(a *App, m *Message) error {
-	return nil
+// handleUnknownCommand handles an unknown command.
+// It points the user at the closest known command, if there is one.
+func handleUnknownCommand(a *App, m *Message, prefix string, words []string) error {
+	a.logger.Info().
+		Str("user", m.Author.Username).
+		Strs("command", words).
+		Str("channel", m.ChannelID).
+		Str("guild", m.GuildID).
+		Msg("User requested unknown command")
+
+	// A bare prefix isn't worth answering
+	if len(words) == 0 {
+		return nil
+	}
+
+	msg := fmt.Sprintf("I don't know what `%s%s` is.", prefix, words[0])
+	if suggestion := commands.suggest(words); suggestion != "" {
+		msg += fmt.Sprintf(" Did you mean `%s%s`?", prefix, suggestion)
+	}
+	msg += fmt.Sprintf(" Try %shelp.", prefix)
+	return a.handleOutgoingMessage(m.RespondToChannelOrThread(a.Config.AppID, msg, true, false))
 }
 
-// handleUnknownCommand handles an unknown command.
-func handleUnknownCommand(a *App, m *Message) error {
-	return nil
+// handleUnknownSubcommand handles a known command followed by a sub-command it doesn't have.
+func handleUnknownSubcommand(a *App, m *Message, prefix string, cmd *Command, args []string) error {
+	a.logger.Info().
+		Str("user", m.Author.Username).
+		Str("command", cmd.Path()).
+		Strs("args", args).
+		Str("channel", m.ChannelID).
+		Str("guild", m.GuildID).
+		Msg("User requested unknown command")
+
+	msg := "I don't know what you mean by that."
+	if suggestion := commands.suggest(append(strings.Fields(cmd.Path()), args...)); suggestion != "" {
+		msg += fmt.Sprintf(" Did you mean `%s%s`?", prefix, suggestion)
+	}
+	msg += fmt.Sprintf(" Try %s%s help.", prefix, cmd.Path())
+	return a.handleOutgoingMessage(m.RespondToChannelOrThread(a.Config.AppID, msg, true, false))
+}
+
+// handleBadArguments tells the user what was wrong with the arguments they gave a command.
+// Arguments that couldn't be parsed are the user's mistake, not ours, so this only returns errors from replying.
+func handleBadArguments(a *App, m *Message, prefix string, cmd *Command, err error) error {
+	a.logger.Info().
+		Err(err).
+		Str("user", m.Author.Username).
+		Str("command", cmd.Path()).
+		Msg("User gave invalid arguments")
+
+	msg := fmt.Sprintf("That didn't work: %s.\nUsage: `%s%s`. Try %s%s help.", err, prefix, cmd.Usage(), prefix, cmd.Path())
+	return a.handleOutgoingMessage(m.RespondToChannelOrThread(a.Config.AppID, msg, true, false))
 }
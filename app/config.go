@@ -1,12 +1,424 @@
 package app
 
-import "github.com/rs/zerolog"
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog"
+	"gopkg.in/yaml.v3"
+)
+
+// envPrefix is prepended to every setting name to get the environment variable that controls it.
+// e.g. the redis-host setting is read from DBTC_REDIS_HOST.
+const envPrefix = "DBTC_"
 
 // Config is the configuration for the app.
 type Config struct {
 	RedisHost     string
 	RedisPort     int
+	RedisPassword string
+	RedisDB       int
+	RedisTLS      bool
 	InboundTopic  string
 	OutboundTopic string
 	LogLevel      zerolog.Level
+
+	// Store picks where the game state is kept: "redis" or "memory".
+	Store string
+
+	// Transport picks how messages get to and from the gateway: "redis" or "console". Empty follows the store,
+	// so the Redis store talks over Redis and the memory store talks on the console. See app/transport.go
+	Transport string
+
+	// AppID is the name the app stamps on outbound messages so the gateway knows who sent them.
+	AppID string
+
+	// GatewayID is the gateway messages that aren't replies are addressed to when there's no telling where they came from,
+	// like notifications for jobs taken before jobs remembered their gateway. Everything else goes back to whichever gateway
+	// the message that set it off came from.
+	GatewayID string
+
+	// ShutdownTimeout is how long the app waits for in-flight handlers before giving up on them.
+	ShutdownTimeout time.Duration
+
+	// Admins are the IDs of users who can run admin commands, in every server. Nobody else can, since there's no telling
+	// who runs a server from the messages the gateway forwards. See App.isAdmin.
+	Admins []string
+
+	// ContentDir is where content packs are loaded from, on top of the built in one. Empty means only the built in one.
+	ContentDir string
+
+	// ContentPack is the content pack servers use until an admin picks another, and the one DMs always use.
+	ContentPack string
+
+	// Economy holds the knobs for how much money the game hands out and how fast.
+	Economy EconomyConfig
+}
+
+// EconomyConfig holds the tunables for the game economy.
+type EconomyConfig struct {
+	WorkMinPayout   int           // Least a user can earn from !work
+	WorkMaxPayout   int           // Most a user can earn from !work
+	WorkCooldown    time.Duration // How long a user has to wait between shifts. Zero means no cooldown.
+	PayConfirmAbove int           // Payments over this amount have to be confirmed. Zero means never.
+	SellBackPercent int           // How much of an item's price the shop pays when buying it back
+
+	CooldownAbuseLimit int           // How many times a user can run into the same cooldown before it earns a demerit. Zero means never.
+	DemeritExpiry      time.Duration // How long a demerit counts against a user
+	DemeritCutAt       int           // Active demerits at which payouts are cut
+	DemeritCutPercent  int           // How much of a payout is cut
+	DemeritLockAt      int           // Active demerits at which users are locked out of spending and sending money
+	DemeritJailAt      int           // Active demerits at which users go to jail
+	JailTime           time.Duration // How long a stay in jail lasts
+
+	JobBoardSize   int           // How many jobs are generated per board
+	JobMinPayout   int           // Least a generated job pays
+	JobMaxPayout   int           // Most a generated job pays
+	JobMinDuration time.Duration // Shortest a generated job takes
+	JobMaxDuration time.Duration // Longest a generated job takes
+	JobOfferTTL    time.Duration // How long a job stays on the board before the offer expires
+	JobMaxRisk     int           // Highest percent chance a generated job fails
+
+	JobFreeRerolls  int    // How many times a day users can reroll their job board for free
+	JobRerollCost   int    // What every reroll past the free ones costs
+	JobMaxRerolls   int    // How many times a day users can reroll their job board at all
+	DefaultTimezone string // Time zone users' days are counted in until they set their own with !timezone
+
+	JobFailPenaltyPercent int // How much of a job's payout failing it costs
+	JobRiskyAt            int // Jobs at least this risky earn a demerit when they fail
+
+	JobQuitFeePercent int           // How much of a job's payout quitting it costs
+	JobQuitDemerit    bool          // Whether quitting a job earns a demerit
+	JobQuitCooldown   time.Duration // How long a user who quit a job has to wait before taking another. Zero means no wait.
+
+	XPPerWork          int // XP for a shift of !work
+	XPPerJobPercent    int // XP for a job that succeeds, as a percentage of what it pays
+	XPPerFailedJob     int // XP for a job that fails
+	LevelBaseXP        int // XP it takes to get from level 1 to level 2
+	LevelGrowthPercent int // How much more XP each level takes than the one before
+	MaxLevel           int // The highest level there is
+
+	JobLevelPayoutPercent   int // How much more jobs pay for every level above 1
+	JobLevelDurationPercent int // How much longer jobs take for every level above 1
+	JobTierLevels           int // How many levels it takes to unlock each better tier of jobs
+}
+
+// DefaultConfig returns the configuration used when nothing else is set.
+// These match what the app was hardcoded to before configuration loading existed.
+func DefaultConfig() Config {
+	return Config{
+		RedisHost:       "localhost",
+		RedisPort:       6379,
+		InboundTopic:    "discord:inbound",
+		OutboundTopic:   "discord:outbound",
+		LogLevel:        zerolog.DebugLevel,
+		Store:           "redis",
+		AppID:           "dbtg",
+		GatewayID:       "discord",
+		ContentPack:     BUILTIN_CONTENT_PACK,
+		ShutdownTimeout: defaultShutdownTimeout,
+		Economy: EconomyConfig{
+			WorkMinPayout:   1,
+			WorkMaxPayout:   100,
+			WorkCooldown:    time.Hour,
+			PayConfirmAbove: 1000,
+			SellBackPercent: 50,
+
+			CooldownAbuseLimit: 5,
+			DemeritExpiry:      7 * 24 * time.Hour,
+			DemeritCutAt:       2,
+			DemeritCutPercent:  50,
+			DemeritLockAt:      3,
+			DemeritJailAt:      5,
+			JailTime:           2 * time.Hour,
+
+			JobBoardSize:   10,
+			JobMinPayout:   50,
+			JobMaxPayout:   1000,
+			JobMinDuration: 5 * time.Minute,
+			JobMaxDuration: 65 * time.Minute,
+			JobOfferTTL:    24 * time.Hour,
+			JobMaxRisk:     30,
+
+			JobFreeRerolls:  1,
+			JobRerollCost:   100,
+			JobMaxRerolls:   3,
+			DefaultTimezone: "UTC",
+
+			JobFailPenaltyPercent: 25,
+			JobRiskyAt:            20,
+
+			JobQuitFeePercent: 10,
+			JobQuitDemerit:    true,
+			JobQuitCooldown:   30 * time.Minute,
+
+			XPPerWork:          10,
+			XPPerJobPercent:    10,
+			XPPerFailedJob:     5,
+			LevelBaseXP:        100,
+			LevelGrowthPercent: 15,
+			MaxLevel:           50,
+
+			JobLevelPayoutPercent:   10,
+			JobLevelDurationPercent: 5,
+			JobTierLevels:           5,
+		},
+	}
+}
+
+// LoadConfig builds a Config from the defaults, an optional YAML file, environment variables and command line flags.
+// Later sources win: a flag beats an environment variable, which beats the file, which beats the default.
+// The file is picked with -config or DBTC_CONFIG and uses the flag names as keys, e.g. "redis-host: redis.internal".
+// The result is validated before it is returned.
+func LoadConfig(args []string) (Config, error) {
+	config := DefaultConfig()
+
+	fs := flag.NewFlagSet("dont-break-the-chat", flag.ContinueOnError)
+	configFile := fs.String("config", os.Getenv(envPrefix+"CONFIG"), "Path to a YAML config file")
+	config.register(fs)
+
+	// Parse flags first so we know which settings were explicitly given and must not be overridden
+	if err := fs.Parse(args); err != nil {
+		return Config{}, err
+	}
+	explicit := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) {
+		explicit[f.Name] = true
+	})
+
+	// Apply the config file
+	if *configFile != "" {
+		values, err := readConfigFile(*configFile)
+		if err != nil {
+			return Config{}, err
+		}
+		for name, value := range values {
+			if fs.Lookup(name) == nil || name == "config" {
+				return Config{}, fmt.Errorf("%s: unknown setting %q", *configFile, name)
+			}
+			if explicit[name] {
+				continue
+			}
+			if err := fs.Set(name, value); err != nil {
+				return Config{}, fmt.Errorf("%s: invalid value for %s: %w", *configFile, name, err)
+			}
+		}
+	}
+
+	// Apply the environment
+	var envErr error
+	fs.VisitAll(func(f *flag.Flag) {
+		if envErr != nil || explicit[f.Name] || f.Name == "config" {
+			return
+		}
+		value, ok := os.LookupEnv(envName(f.Name))
+		if !ok {
+			return
+		}
+		if err := fs.Set(f.Name, value); err != nil {
+			envErr = fmt.Errorf("invalid value for %s: %w", envName(f.Name), err)
+		}
+	})
+	if envErr != nil {
+		return Config{}, envErr
+	}
+
+	if err := config.Validate(); err != nil {
+		return Config{}, err
+	}
+	return config, nil
+}
+
+// register binds every setting in the config to a flag on fs.
+// The current values of the config are used as the flag defaults.
+func (c *Config) register(fs *flag.FlagSet) {
+	fs.StringVar(&c.RedisHost, "redis-host", c.RedisHost, "Redis host")
+	fs.IntVar(&c.RedisPort, "redis-port", c.RedisPort, "Redis port")
+	fs.StringVar(&c.RedisPassword, "redis-password", c.RedisPassword, "Redis password")
+	fs.IntVar(&c.RedisDB, "redis-db", c.RedisDB, "Redis database number")
+	fs.BoolVar(&c.RedisTLS, "redis-tls", c.RedisTLS, "Connect to Redis over TLS")
+	fs.StringVar(&c.InboundTopic, "inbound", c.InboundTopic, "Pubsub topic to read messages from the gateway")
+	fs.StringVar(&c.OutboundTopic, "outbound", c.OutboundTopic, "Pubsub topic to publish messages to the gateway")
+	fs.Var((*logLevelValue)(&c.LogLevel), "log-level", "Log level (trace, debug, info, warn, error)")
+	fs.StringVar(&c.Store, "store", c.Store, "Where to keep game state (redis, memory)")
+	fs.StringVar(&c.Transport, "transport", c.Transport, "How to talk to the gateway (redis, console). Empty follows the store.")
+	fs.StringVar(&c.AppID, "id", c.AppID, "ID to use when publishing messages")
+	fs.StringVar(&c.GatewayID, "gateway", c.GatewayID, "ID of the gateway to address notifications to when there's no telling which gateway they belong to")
+	fs.DurationVar(&c.ShutdownTimeout, "shutdown-timeout", c.ShutdownTimeout, "How long to wait for in-flight handlers on shutdown")
+	fs.Var((*listValue)(&c.Admins), "admins", "Comma separated IDs of users who can run admin commands in every server")
+	fs.StringVar(&c.ContentDir, "content-dir", c.ContentDir, "Directory to load content packs from, on top of the built in one")
+	fs.StringVar(&c.ContentPack, "content-pack", c.ContentPack, "Content pack servers use until an admin picks another")
+	fs.IntVar(&c.Economy.WorkMinPayout, "work-min-payout", c.Economy.WorkMinPayout, "Least a user can earn from !work")
+	fs.IntVar(&c.Economy.WorkMaxPayout, "work-max-payout", c.Economy.WorkMaxPayout, "Most a user can earn from !work")
+	fs.DurationVar(&c.Economy.WorkCooldown, "work-cooldown", c.Economy.WorkCooldown, "How long a user has to wait between shifts of !work (0 to disable)")
+	fs.IntVar(&c.Economy.PayConfirmAbove, "pay-confirm-above", c.Economy.PayConfirmAbove, "Payments over this amount have to be confirmed (0 to never ask)")
+	fs.IntVar(&c.Economy.SellBackPercent, "sell-back-percent", c.Economy.SellBackPercent, "Percentage of an item's price the shop pays when buying it back")
+	fs.IntVar(&c.Economy.CooldownAbuseLimit, "cooldown-abuse-limit", c.Economy.CooldownAbuseLimit, "Times a user can run into the same cooldown before it earns a demerit (0 to never)")
+	fs.DurationVar(&c.Economy.DemeritExpiry, "demerit-expiry", c.Economy.DemeritExpiry, "How long a demerit counts against a user")
+	fs.IntVar(&c.Economy.DemeritCutAt, "demerit-cut-at", c.Economy.DemeritCutAt, "Active demerits at which payouts are cut")
+	fs.IntVar(&c.Economy.DemeritCutPercent, "demerit-cut-percent", c.Economy.DemeritCutPercent, "Percentage of a payout cut from users with too many demerits")
+	fs.IntVar(&c.Economy.DemeritLockAt, "demerit-lock-at", c.Economy.DemeritLockAt, "Active demerits at which users can't spend or send money")
+	fs.IntVar(&c.Economy.DemeritJailAt, "demerit-jail-at", c.Economy.DemeritJailAt, "Active demerits at which users go to jail")
+	fs.DurationVar(&c.Economy.JailTime, "jail-time", c.Economy.JailTime, "How long a stay in jail lasts")
+	fs.IntVar(&c.Economy.JobBoardSize, "job-board-size", c.Economy.JobBoardSize, "Number of jobs generated per board")
+	fs.IntVar(&c.Economy.JobMinPayout, "job-min-payout", c.Economy.JobMinPayout, "Least a generated job pays")
+	fs.IntVar(&c.Economy.JobMaxPayout, "job-max-payout", c.Economy.JobMaxPayout, "Most a generated job pays")
+	fs.DurationVar(&c.Economy.JobMinDuration, "job-min-duration", c.Economy.JobMinDuration, "Shortest a generated job takes")
+	fs.DurationVar(&c.Economy.JobMaxDuration, "job-max-duration", c.Economy.JobMaxDuration, "Longest a generated job takes")
+	fs.DurationVar(&c.Economy.JobOfferTTL, "job-offer-ttl", c.Economy.JobOfferTTL, "How long a job stays on the board before the offer expires")
+	fs.IntVar(&c.Economy.JobMaxRisk, "job-max-risk", c.Economy.JobMaxRisk, "Highest percent chance a generated job fails")
+	fs.IntVar(&c.Economy.JobFreeRerolls, "job-free-rerolls", c.Economy.JobFreeRerolls, "How many times a day users can reroll their job board for free")
+	fs.IntVar(&c.Economy.JobRerollCost, "job-reroll-cost", c.Economy.JobRerollCost, "What every reroll of a job board past the free ones costs")
+	fs.IntVar(&c.Economy.JobMaxRerolls, "job-max-rerolls", c.Economy.JobMaxRerolls, "How many times a day users can reroll their job board at all")
+	fs.StringVar(&c.Economy.DefaultTimezone, "default-timezone", c.Economy.DefaultTimezone, "Time zone users' days are counted in until they set their own")
+	fs.IntVar(&c.Economy.JobFailPenaltyPercent, "job-fail-penalty-percent", c.Economy.JobFailPenaltyPercent, "Percentage of a job's payout charged when it fails")
+	fs.IntVar(&c.Economy.JobRiskyAt, "job-risky-at", c.Economy.JobRiskyAt, "Percent risk at which failing a job earns a demerit")
+	fs.IntVar(&c.Economy.JobQuitFeePercent, "job-quit-fee-percent", c.Economy.JobQuitFeePercent, "Percentage of a job's payout charged for quitting it")
+	fs.BoolVar(&c.Economy.JobQuitDemerit, "job-quit-demerit", c.Economy.JobQuitDemerit, "Give a demerit for quitting a job")
+	fs.DurationVar(&c.Economy.JobQuitCooldown, "job-quit-cooldown", c.Economy.JobQuitCooldown, "How long a user who quit a job waits before taking another (0 to disable)")
+	fs.IntVar(&c.Economy.XPPerWork, "xp-per-work", c.Economy.XPPerWork, "XP for a shift of !work")
+	fs.IntVar(&c.Economy.XPPerJobPercent, "xp-per-job-percent", c.Economy.XPPerJobPercent, "XP for a job that succeeds, as a percentage of what it pays")
+	fs.IntVar(&c.Economy.XPPerFailedJob, "xp-per-failed-job", c.Economy.XPPerFailedJob, "XP for a job that fails")
+	fs.IntVar(&c.Economy.LevelBaseXP, "level-base-xp", c.Economy.LevelBaseXP, "XP it takes to get from level 1 to level 2")
+	fs.IntVar(&c.Economy.LevelGrowthPercent, "level-growth-percent", c.Economy.LevelGrowthPercent, "How much more XP each level takes than the one before, in percent")
+	fs.IntVar(&c.Economy.MaxLevel, "max-level", c.Economy.MaxLevel, "The highest level there is")
+	fs.IntVar(&c.Economy.JobLevelPayoutPercent, "job-level-payout-percent", c.Economy.JobLevelPayoutPercent, "How much more jobs pay for every level above 1, in percent")
+	fs.IntVar(&c.Economy.JobLevelDurationPercent, "job-level-duration-percent", c.Economy.JobLevelDurationPercent, "How much longer jobs take for every level above 1, in percent")
+	fs.IntVar(&c.Economy.JobTierLevels, "job-tier-levels", c.Economy.JobTierLevels, "How many levels it takes to unlock each better tier of jobs")
+}
+
+// Validate checks that the config makes sense before the app tries to use it.
+func (c *Config) Validate() error {
+	var problems []string
+	check := func(ok bool, format string, args ...interface{}) {
+		if !ok {
+			problems = append(problems, fmt.Sprintf(format, args...))
+		}
+	}
+
+	check(c.RedisHost != "", "redis-host must not be empty")
+	check(c.RedisPort > 0 && c.RedisPort < 65536, "redis-port must be between 1 and 65535, got %d", c.RedisPort)
+	check(c.RedisDB >= 0, "redis-db must not be negative, got %d", c.RedisDB)
+	check(c.InboundTopic != "", "inbound must not be empty")
+	check(c.OutboundTopic != "", "outbound must not be empty")
+	check(c.InboundTopic != c.OutboundTopic, "inbound and outbound must be different topics")
+	check(c.Store == "redis" || c.Store == "memory", "store must be redis or memory, got %q", c.Store)
+	check(c.Transport == "" || c.Transport == TRANSPORT_REDIS || c.Transport == TRANSPORT_CONSOLE, "transport must be redis or console, got %q", c.Transport)
+	check(c.AppID != "", "id must not be empty")
+	check(c.GatewayID != "", "gateway must not be empty")
+	check(c.ShutdownTimeout >= 0, "shutdown-timeout must not be negative")
+	check(c.ContentPack != "", "content-pack must not be empty")
+
+	e := c.Economy
+	check(e.WorkMinPayout > 0, "work-min-payout must be positive, got %d", e.WorkMinPayout)
+	check(e.WorkMaxPayout >= e.WorkMinPayout, "work-max-payout (%d) must not be less than work-min-payout (%d)", e.WorkMaxPayout, e.WorkMinPayout)
+	check(e.WorkCooldown >= 0, "work-cooldown must not be negative")
+	check(e.PayConfirmAbove >= 0, "pay-confirm-above must not be negative, got %d", e.PayConfirmAbove)
+	check(e.SellBackPercent >= 0 && e.SellBackPercent <= 100, "sell-back-percent must be between 0 and 100, got %d", e.SellBackPercent)
+	check(e.CooldownAbuseLimit >= 0, "cooldown-abuse-limit must not be negative, got %d", e.CooldownAbuseLimit)
+	check(e.DemeritExpiry > 0, "demerit-expiry must be positive, got %s", e.DemeritExpiry)
+	check(e.DemeritCutAt > 0, "demerit-cut-at must be positive, got %d", e.DemeritCutAt)
+	check(e.DemeritCutPercent >= 0 && e.DemeritCutPercent <= 100, "demerit-cut-percent must be between 0 and 100, got %d", e.DemeritCutPercent)
+	check(e.DemeritLockAt >= e.DemeritCutAt, "demerit-lock-at (%d) must not be less than demerit-cut-at (%d)", e.DemeritLockAt, e.DemeritCutAt)
+	check(e.DemeritJailAt >= e.DemeritLockAt, "demerit-jail-at (%d) must not be less than demerit-lock-at (%d)", e.DemeritJailAt, e.DemeritLockAt)
+	check(e.JailTime >= 0, "jail-time must not be negative")
+	check(e.JobBoardSize > 0, "job-board-size must be positive, got %d", e.JobBoardSize)
+	check(e.JobMinPayout > 0, "job-min-payout must be positive, got %d", e.JobMinPayout)
+	check(e.JobMaxPayout >= e.JobMinPayout, "job-max-payout (%d) must not be less than job-min-payout (%d)", e.JobMaxPayout, e.JobMinPayout)
+	check(e.JobMinDuration >= time.Second, "job-min-duration must be at least 1s, got %s", e.JobMinDuration)
+	check(e.JobMaxDuration >= e.JobMinDuration, "job-max-duration (%s) must not be less than job-min-duration (%s)", e.JobMaxDuration, e.JobMinDuration)
+	check(e.JobOfferTTL > 0, "job-offer-ttl must be positive, got %s", e.JobOfferTTL)
+	check(e.JobMaxRisk >= 0 && e.JobMaxRisk <= 100, "job-max-risk must be between 0 and 100, got %d", e.JobMaxRisk)
+	check(e.JobFreeRerolls >= 0, "job-free-rerolls must not be negative, got %d", e.JobFreeRerolls)
+	check(e.JobRerollCost >= 0, "job-reroll-cost must not be negative, got %d", e.JobRerollCost)
+	check(e.JobMaxRerolls >= e.JobFreeRerolls, "job-max-rerolls (%d) must not be less than job-free-rerolls (%d)", e.JobMaxRerolls, e.JobFreeRerolls)
+	_, err := loadTimezone(e.DefaultTimezone)
+	check(err == nil, "default-timezone %q is not a known time zone", e.DefaultTimezone)
+	check(e.JobFailPenaltyPercent >= 0 && e.JobFailPenaltyPercent <= 100, "job-fail-penalty-percent must be between 0 and 100, got %d", e.JobFailPenaltyPercent)
+	check(e.JobRiskyAt >= 0, "job-risky-at must not be negative, got %d", e.JobRiskyAt)
+	check(e.JobQuitFeePercent >= 0 && e.JobQuitFeePercent <= 100, "job-quit-fee-percent must be between 0 and 100, got %d", e.JobQuitFeePercent)
+	check(e.JobQuitCooldown >= 0, "job-quit-cooldown must not be negative")
+	check(e.XPPerWork >= 0, "xp-per-work must not be negative, got %d", e.XPPerWork)
+	check(e.XPPerJobPercent >= 0, "xp-per-job-percent must not be negative, got %d", e.XPPerJobPercent)
+	check(e.XPPerFailedJob >= 0, "xp-per-failed-job must not be negative, got %d", e.XPPerFailedJob)
+	check(e.LevelBaseXP > 0, "level-base-xp must be positive, got %d", e.LevelBaseXP)
+	check(e.LevelGrowthPercent >= 0 && e.LevelGrowthPercent <= 100, "level-growth-percent must be between 0 and 100, got %d", e.LevelGrowthPercent)
+	check(e.MaxLevel >= 1 && e.MaxLevel <= maxMaxLevel, "max-level must be between 1 and %d, got %d", maxMaxLevel, e.MaxLevel)
+	check(e.JobLevelPayoutPercent >= 0, "job-level-payout-percent must not be negative, got %d", e.JobLevelPayoutPercent)
+	check(e.JobLevelDurationPercent >= 0, "job-level-duration-percent must not be negative, got %d", e.JobLevelDurationPercent)
+	check(e.JobTierLevels > 0, "job-tier-levels must be positive, got %d", e.JobTierLevels)
+
+	if len(problems) > 0 {
+		return errors.New("invalid config: " + strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// readConfigFile reads a flat YAML file of setting names to values.
+// Values are returned as strings so they go through the same parsing as flags and environment variables.
+func readConfigFile(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	raw := map[string]interface{}{}
+	if err := yaml.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("failed to parse config file %s: %w", path, err)
+	}
+
+	values := make(map[string]string, len(raw))
+	for name, value := range raw {
+		switch value.(type) {
+		case map[string]interface{}, []interface{}:
+			return nil, fmt.Errorf("%s: setting %q must be a single value", path, name)
+		case nil:
+			values[name] = ""
+		default:
+			values[name] = fmt.Sprint(value)
+		}
+	}
+	return values, nil
+}
+
+// envName returns the environment variable name for a setting.
+func envName(setting string) string {
+	return envPrefix + strings.ToUpper(strings.ReplaceAll(setting, "-", "_"))
+}
+
+// logLevelValue lets a zerolog.Level be used as a flag.
+type logLevelValue zerolog.Level
+
+func (l *logLevelValue) String() string {
+	return zerolog.Level(*l).String()
+}
+
+func (l *logLevelValue) Set(s string) error {
+	level, err := zerolog.ParseLevel(strings.ToLower(s))
+	if err != nil {
+		return err
+	}
+	*l = logLevelValue(level)
+	return nil
+}
+
+// listValue lets a comma separated list be used as a flag. Setting it replaces the whole list.
+type listValue []string
+
+func (l *listValue) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *listValue) Set(s string) error {
+	*l = nil
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			*l = append(*l, item)
+		}
+	}
+	return nil
 }
@@ -0,0 +1,43 @@
+package app
+
+import (
+	"encoding/json"
+	"time"
+)
+
+/*
+Confirmations
+
+Some commands are easy to get wrong and hard to undo, like sending somebody a pile of money. Those commands
+park what they're about to do as a confirmation and ask the user to confirm it. A confirmation is keyed by
+user and action, only lives for a short while and can only be taken once, so confirming twice does nothing.
+*/
+
+const CONFIRMATIONS_PREFIX = "confirm:" // Pending confirmations live under "confirm:<user_id>:<action>" as JSON
+
+// confirmationTTL is how long a user has to confirm something before it's forgotten.
+const confirmationTTL = time.Minute
+
+// confirmationKey returns the Redis key for a pending confirmation.
+func confirmationKey(userID, action string) string {
+	return CONFIRMATIONS_PREFIX + userID + ":" + action
+}
+
+// requestConfirmation parks v until the user confirms or it expires, replacing anything already waiting for the same action.
+func (a *App) requestConfirmation(userID, action string, v interface{}) error {
+	payload, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return a.store.SetConfirmation(a.context, userID, action, payload, confirmationTTL)
+}
+
+// takeConfirmation removes the pending confirmation for the action and unmarshals it into v.
+// It returns false if there was nothing waiting.
+func (a *App) takeConfirmation(userID, action string, v interface{}) (bool, error) {
+	payload, err := a.store.TakeConfirmation(a.context, userID, action)
+	if err != nil || payload == nil {
+		return false, err
+	}
+	return true, json.Unmarshal(payload, v)
+}
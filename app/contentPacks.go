@@ -0,0 +1,426 @@
+package app
+
+import (
+	"bytes"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"gopkg.in/yaml.v3"
+)
+
+/*
+Content Packs
+
+What jobs are called, what they're about and what they pay comes from a content pack rather than from code, so writers
+can add to the game without touching Go. A pack is a JSON or YAML file. The built in one is app/data/packs/default.yaml,
+which doubles as the template for writing more. Packs on disk are loaded from Config.ContentDir when the app starts.
+
+Every pack says which version of the pack format it's written in, and has a version of its own that its authors bump.
+Packs are checked when they're loaded, the way the item catalog is: unknown fields, empty lists and placeholders that
+don't exist are all errors. A broken pack stops the app from starting, so nobody finds out about it halfway through
+generating a board.
+
+Admins pick the pack for their server with !content use. Servers that never picked one, and DMs, get
+Config.ContentPack. A user's board is generated with the pack of wherever they first look at it that day.
+
+!content reload reads the packs from disk again. It's all or nothing: if any pack is broken, the ones loaded before stay
+in use and the admin is told what's wrong. A server whose pack disappeared goes back to Config.ContentPack until it's back.
+*/
+
+// CONTENT_PACK_FORMAT is the version of the pack format this app reads. Bump it when packs have to change to keep loading.
+const CONTENT_PACK_FORMAT = 1
+
+// BUILTIN_CONTENT_PACK is the ID of the pack compiled into the app.
+const BUILTIN_CONTENT_PACK = "default"
+
+//go:embed data/packs/default.yaml
+var builtinContentPackYAML []byte
+
+// contentPlaceholder matches placeholders in flavor text, like {job}
+var contentPlaceholder = regexp.MustCompile(`\{[^{}]*\}`)
+
+// contentPackIDPattern is what pack IDs look like
+var contentPackIDPattern = regexp.MustCompile(`^[a-z0-9]+(-[a-z0-9]+)*$`)
+
+// The !content command tree
+func init() {
+	commands.Register(&Command{
+		Name:      "content",
+		Aliases:   []string{"packs"},
+		Group:     "Admin",
+		AdminOnly: true,
+		Summary:   "See, pick and reload content packs",
+		Help: "Content packs are what jobs are made of: what they're called, what they're about and what they pay.\n" +
+			"They're files on the bot's disk, so writers can add to the game without touching any code.",
+		Examples: []string{"content", "content use default", "content reload"},
+		Handler:  handleContentList,
+		Subcommands: []*Command{
+			{
+				Name:    "list",
+				Summary: "List the content packs and which one this server uses",
+				Handler: handleContentList,
+			},
+			{
+				Name:    "use",
+				Summary: "Pick the content pack for this server",
+				Help:    "New boards in this server are made of the pack you pick. Boards people already have stay as they are until the next day.",
+				Args: []ArgSpec{
+					{Name: "pack", Description: "ID of the pack"},
+				},
+				Handler: handleContentUse,
+			},
+			{
+				Name:    "reload",
+				Summary: "Load the content packs from disk again",
+				Help:    "Picks up packs that were added or changed since the bot started. If any of them is broken, nothing changes and I'll tell you what's wrong.",
+				Handler: handleContentReload,
+			},
+			helpSubcommand(),
+		},
+	})
+}
+
+// ContentPack is what jobs are made of.
+type ContentPack struct {
+	Format      int          `json:"format" yaml:"format"`           // Version of the pack format it's written in
+	ID          string       `json:"id" yaml:"id"`                   // What admins pick it by. Lowercase with dashes.
+	Name        string       `json:"name" yaml:"name"`               // What it's called in chat
+	Version     string       `json:"version" yaml:"version"`         // The pack's own version, bumped by its authors
+	Description string       `json:"description" yaml:"description"` // What it's about
+	Payout      *PayoutRange `json:"payout" yaml:"payout"`           // What jobs pay before levels and tiers. Nil means the config's range.
+
+	Verbs        []string `json:"verbs" yaml:"verbs"`               // Plain jobs are called "<verb> <noun>"
+	Nouns        []string `json:"nouns" yaml:"nouns"`               // See Verbs
+	Descriptions []string `json:"descriptions" yaml:"descriptions"` // What plain jobs are about. {job} is the job's name.
+
+	DeliveryDestinations []string `json:"delivery_destinations" yaml:"delivery_destinations"` // Where deliveries go
+	DeliveryDescriptions []string `json:"delivery_descriptions" yaml:"delivery_descriptions"` // {item} and {destination}
+
+	CombatEnemies      []string `json:"combat_enemies" yaml:"combat_enemies"`           // Who fights are against
+	CombatDescriptions []string `json:"combat_descriptions" yaml:"combat_descriptions"` // {enemy}
+
+	source string // The file the pack came from, or "built in"
+}
+
+// PayoutRange is the range of what jobs pay before levels and tiers.
+type PayoutRange struct {
+	Min int `json:"min" yaml:"min"`
+	Max int `json:"max" yaml:"max"`
+}
+
+// parseContentPack reads a pack from a file's contents, as YAML or JSON depending on its name, and checks it.
+// Fields the pack format doesn't have are errors, since they're usually typos.
+func parseContentPack(name string, data []byte) (*ContentPack, error) {
+	pack := &ContentPack{source: name}
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".json":
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(pack); err != nil {
+			return nil, err
+		}
+	case ".yaml", ".yml":
+		decoder := yaml.NewDecoder(bytes.NewReader(data))
+		decoder.KnownFields(true)
+		if err := decoder.Decode(pack); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("%s is not a .json, .yaml or .yml file", name)
+	}
+	if err := pack.validate(); err != nil {
+		return nil, err
+	}
+	return pack, nil
+}
+
+// validate checks that a pack makes sense.
+func (c *ContentPack) validate() error {
+	switch {
+	case c.Format == 0:
+		return errors.New("format is missing")
+	case c.Format > CONTENT_PACK_FORMAT:
+		return fmt.Errorf("format %d is newer than this bot reads (up to %d)", c.Format, CONTENT_PACK_FORMAT)
+	case c.Format < 0:
+		return fmt.Errorf("format %d doesn't exist", c.Format)
+	case !contentPackIDPattern.MatchString(c.ID):
+		return fmt.Errorf("id %q must be lowercase letters and numbers, with dashes between words", c.ID)
+	case c.Name == "":
+		return errors.New("name is missing")
+	case c.Version == "":
+		return errors.New("version is missing")
+	case c.Payout != nil && c.Payout.Min <= 0:
+		return fmt.Errorf("payout min must be positive, got %d", c.Payout.Min)
+	case c.Payout != nil && c.Payout.Max < c.Payout.Min:
+		return fmt.Errorf("payout max (%d) must not be less than payout min (%d)", c.Payout.Max, c.Payout.Min)
+	}
+
+	lists := []struct {
+		name         string
+		entries      []string
+		placeholders []string
+	}{
+		{"verbs", c.Verbs, nil},
+		{"nouns", c.Nouns, nil},
+		{"descriptions", c.Descriptions, []string{"{job}"}},
+		{"delivery_destinations", c.DeliveryDestinations, nil},
+		{"delivery_descriptions", c.DeliveryDescriptions, []string{"{item}", "{destination}"}},
+		{"combat_enemies", c.CombatEnemies, nil},
+		{"combat_descriptions", c.CombatDescriptions, []string{"{enemy}"}},
+	}
+	for _, list := range lists {
+		if len(list.entries) == 0 {
+			return fmt.Errorf("%s must have at least one entry", list.name)
+		}
+		for i, entry := range list.entries {
+			if strings.TrimSpace(entry) == "" {
+				return fmt.Errorf("%s entry %d is empty", list.name, i+1)
+			}
+			for _, placeholder := range contentPlaceholder.FindAllString(entry, -1) {
+				if !containsString(list.placeholders, placeholder) {
+					return fmt.Errorf("%s entry %d has %s, which isn't a placeholder there", list.name, i+1, placeholder)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// containsString reports whether s is in list.
+func containsString(list []string, s string) bool {
+	for _, entry := range list {
+		if entry == s {
+			return true
+		}
+	}
+	return false
+}
+
+// pick returns a random entry of a list. Packs are checked to not have empty lists.
+func pick(list []string) string {
+	return list[rand.Intn(len(list))] // nolint:gosec // This is not a security issue
+}
+
+// economy returns e with the pack's payout range, if it has one.
+func (c *ContentPack) economy(e EconomyConfig) EconomyConfig {
+	if c.Payout != nil {
+		e.JobMinPayout = c.Payout.Min
+		e.JobMaxPayout = c.Payout.Max
+	}
+	return e
+}
+
+// jobNameAndDescription makes up a plain job.
+func (c *ContentPack) jobNameAndDescription() (string, string) {
+	name := fmt.Sprintf("%s %s", pick(c.Verbs), pick(c.Nouns))
+	desc := strings.ReplaceAll(pick(c.Descriptions), "{job}", name)
+	return name, desc
+}
+
+// contentPackErrors is what's wrong with the packs that didn't load, one problem per entry.
+type contentPackErrors []string
+
+func (e contentPackErrors) Error() string {
+	return "broken content packs: " + strings.Join(e, "; ")
+}
+
+// contentLibrary is every content pack the app has loaded.
+type contentLibrary struct {
+	dir      string // Where packs are loaded from. Empty means only the built in one.
+	fallback string // The pack for servers that didn't pick one. It has to be there.
+
+	mu    sync.RWMutex
+	packs map[string]*ContentPack // Packs by ID
+}
+
+// newContentLibrary loads the built in pack and whatever packs are in dir. One of them has to be fallback.
+func newContentLibrary(dir, fallback string) (*contentLibrary, error) {
+	l := &contentLibrary{dir: dir, fallback: fallback}
+	if _, err := l.reload(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// reload loads every pack again. If any of them is broken, or the fallback is gone, it returns contentPackErrors with
+// what's wrong and keeps the packs it had. Otherwise it returns how many packs there are now.
+func (l *contentLibrary) reload() (int, error) {
+	builtin, err := parseContentPack("default.yaml", builtinContentPackYAML)
+	if err != nil {
+		// The built in pack is checked like any other, but it's part of the code, so it being broken is a programming error
+		panic(fmt.Sprintf("built in content pack: %v", err))
+	}
+	builtin.source = "built in"
+	packs := map[string]*ContentPack{builtin.ID: builtin}
+
+	problems := contentPackErrors{}
+	if l.dir != "" {
+		entries, err := os.ReadDir(l.dir)
+		if err != nil {
+			return 0, contentPackErrors{err.Error()}
+		}
+		for _, entry := range entries {
+			switch strings.ToLower(filepath.Ext(entry.Name())) {
+			case ".json", ".yaml", ".yml":
+			default:
+				continue // READMEs and the like
+			}
+			if entry.IsDir() {
+				continue
+			}
+
+			data, err := os.ReadFile(filepath.Join(l.dir, entry.Name()))
+			if err != nil {
+				problems = append(problems, err.Error())
+				continue
+			}
+			pack, err := parseContentPack(entry.Name(), data)
+			if err != nil {
+				problems = append(problems, fmt.Sprintf("%s: %v", entry.Name(), err))
+				continue
+			}
+			if other, ok := packs[pack.ID]; ok {
+				problems = append(problems, fmt.Sprintf("%s: id %q is taken by %s", entry.Name(), pack.ID, other.source))
+				continue
+			}
+			packs[pack.ID] = pack
+		}
+	}
+	if _, ok := packs[l.fallback]; !ok && len(problems) == 0 {
+		problems = append(problems, fmt.Sprintf("there's no pack %q, which servers that didn't pick one use", l.fallback))
+	}
+	if len(problems) > 0 {
+		return 0, problems
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.packs = packs
+	return len(packs), nil
+}
+
+// pack returns the pack with the given ID, or nil if there isn't one.
+func (l *contentLibrary) pack(id string) *ContentPack {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.packs[id]
+}
+
+// list returns every pack, by ID.
+func (l *contentLibrary) list() []*ContentPack {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	packs := make([]*ContentPack, 0, len(l.packs))
+	for _, pack := range l.packs {
+		packs = append(packs, pack)
+	}
+	sort.Slice(packs, func(i, j int) bool { return packs[i].ID < packs[j].ID })
+	return packs
+}
+
+// contentPack returns the content pack jobs in the given server are made of. An empty guild ID (a DM) gets
+// Config.ContentPack, and so does a server whose pack isn't loaded anymore.
+func (a *App) contentPack(guildID string) (*ContentPack, error) {
+	settings, err := a.guildSettings(guildID)
+	if err != nil {
+		return nil, err
+	}
+	if settings.ContentPack != "" {
+		if pack := a.content.pack(settings.ContentPack); pack != nil {
+			return pack, nil
+		}
+		a.logger.Warn().
+			Str("guild", guildID).
+			Str("content", settings.ContentPack).
+			Msg("Content pack is missing, using the default")
+	}
+	return a.content.pack(a.Config.ContentPack), nil
+}
+
+// handleContentList handles the !content list command.
+func handleContentList(a *App, m *Message, args *Args) error {
+	using, err := a.contentPack(m.GuildID)
+	if err != nil {
+		return err
+	}
+
+	lines := []string{"** Content packs **"}
+	for _, pack := range a.content.list() {
+		line := fmt.Sprintf("- `%s` %s v%s (%s)", pack.ID, pack.Name, pack.Version, pack.source)
+		if pack.Description != "" {
+			line += " - " + pack.Description
+		}
+		if pack.ID == using.ID {
+			line += " **[in use here]**"
+		}
+		lines = append(lines, line)
+	}
+	lines = append(lines, fmt.Sprintf("Pick one for this server with `%scontent use <pack>`.", args.Prefix))
+	return a.handleOutgoingMessage(m.RespondToChannelOrThread(a.Config.AppID, strings.Join(lines, "\n"), true, false))
+}
+
+// handleContentUse handles the !content use command.
+func handleContentUse(a *App, m *Message, args *Args) error {
+	if m.GuildID == "" {
+		return a.handleOutgoingMessage(m.RespondToChannelOrThread(a.Config.AppID, "Content packs are picked per server. Run this in a server.", true, false))
+	}
+
+	pack := a.content.pack(args.String("pack"))
+	if pack == nil {
+		msg := fmt.Sprintf("There's no content pack `%s`. Type `%scontent list` to see the ones there are.", args.String("pack"), args.Prefix)
+		return a.handleOutgoingMessage(m.RespondToChannelOrThread(a.Config.AppID, msg, true, false))
+	}
+	if _, err := a.updateGuildSettings(m.GuildID, func(s *GuildSettings) error {
+		s.ContentPack = pack.ID
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	a.logger.Info().
+		Str("user", m.Author.Username).
+		Str("guild", m.GuildID).
+		Str("content", pack.ID).
+		Msg("content pack picked")
+
+	msg := fmt.Sprintf("New boards in this server are made of %s v%s now.", pack.Name, pack.Version)
+	return a.handleOutgoingMessage(m.RespondToChannelOrThread(a.Config.AppID, msg, true, false))
+}
+
+// handleContentReload handles the !content reload command.
+func handleContentReload(a *App, m *Message, args *Args) error {
+	count, err := a.content.reload()
+	var problems contentPackErrors
+	if errors.As(err, &problems) {
+		a.logger.Warn().
+			Err(err).
+			Str("user", m.Author.Username).
+			Msg("content packs didn't reload")
+		lines := []string{"The content packs didn't reload, so the ones from before are still in use. Here's what's wrong:"}
+		for _, problem := range problems {
+			lines = append(lines, "- "+problem)
+		}
+		return a.handleOutgoingMessage(m.RespondToChannelOrThread(a.Config.AppID, strings.Join(lines, "\n"), true, false))
+	}
+	if err != nil {
+		return err
+	}
+
+	a.logger.Info().
+		Str("user", m.Author.Username).
+		Int("packs", count).
+		Msg("content packs reloaded")
+
+	msg := fmt.Sprintf("Reloaded the content packs, %d in all. Type `%scontent list` to see them.", count, args.Prefix)
+	return a.handleOutgoingMessage(m.RespondToChannelOrThread(a.Config.AppID, msg, true, false))
+}
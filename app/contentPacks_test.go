@@ -0,0 +1,126 @@
+package app
+
+import (
+	"strings"
+	"testing"
+)
+
+// testContentPack returns a pack that passes validate.
+func testContentPack() *ContentPack {
+	return &ContentPack{
+		Format:               CONTENT_PACK_FORMAT,
+		ID:                   "test-pack",
+		Name:                 "Test Pack",
+		Version:              "1.0.0",
+		Verbs:                []string{"Wash"},
+		Nouns:                []string{"dishes"},
+		Descriptions:         []string{"Somebody has to {job}."},
+		DeliveryDestinations: []string{"the docks"},
+		DeliveryDescriptions: []string{"Take {item} to {destination}."},
+		CombatEnemies:        []string{"a rat"},
+		CombatDescriptions:   []string{"{enemy} is in the cellar."},
+	}
+}
+
+func TestContentPackValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		mutate func(c *ContentPack)
+		err    string // Part of the error, empty if the pack is fine
+	}{
+		{"valid", func(c *ContentPack) {}, ""},
+		{"valid payout", func(c *ContentPack) { c.Payout = &PayoutRange{Min: 10, Max: 10} }, ""},
+		{"no format", func(c *ContentPack) { c.Format = 0 }, "format is missing"},
+		{"newer format", func(c *ContentPack) { c.Format = CONTENT_PACK_FORMAT + 1 }, "newer than this bot reads"},
+		{"negative format", func(c *ContentPack) { c.Format = -1 }, "doesn't exist"},
+		{"uppercase ID", func(c *ContentPack) { c.ID = "Test" }, "id"},
+		{"ID with spaces", func(c *ContentPack) { c.ID = "test pack" }, "id"},
+		{"ID with a trailing dash", func(c *ContentPack) { c.ID = "test-" }, "id"},
+		{"no name", func(c *ContentPack) { c.Name = "" }, "name is missing"},
+		{"no version", func(c *ContentPack) { c.Version = "" }, "version is missing"},
+		{"free jobs", func(c *ContentPack) { c.Payout = &PayoutRange{Min: 0, Max: 10} }, "payout min"},
+		{"upside down payout", func(c *ContentPack) { c.Payout = &PayoutRange{Min: 10, Max: 5} }, "payout max"},
+		{"no verbs", func(c *ContentPack) { c.Verbs = nil }, "verbs must have at least one entry"},
+		{"blank enemy", func(c *ContentPack) { c.CombatEnemies = []string{"a rat", " "} }, "combat_enemies entry 2 is empty"},
+		{"placeholder from another list", func(c *ContentPack) { c.Descriptions = []string{"Fight {enemy}."} }, "{enemy}"},
+		{"placeholder in a name", func(c *ContentPack) { c.Nouns = []string{"{job}"} }, "{job}"},
+		{"misspelled placeholder", func(c *ContentPack) { c.DeliveryDescriptions = []string{"Take {itme} away."} }, "{itme}"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := testContentPack()
+			tt.mutate(c)
+			err := c.validate()
+			if tt.err == "" {
+				if err != nil {
+					t.Errorf("validate() = %v, want no error", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("validate() = %v, want an error about %q", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestParseContentPack(t *testing.T) {
+	const yamlPack = `
+format: 1
+id: test-pack
+name: Test Pack
+version: 1.0.0
+payout:
+  min: 5
+  max: 50
+verbs: [Wash]
+nouns: [dishes]
+descriptions: ["Somebody has to {job}."]
+delivery_destinations: [the docks]
+delivery_descriptions: ["Take {item} to {destination}."]
+combat_enemies: [a rat]
+combat_descriptions: ["{enemy} is in the cellar."]
+`
+	const jsonPack = `{
+		"format": 1, "id": "test-pack", "name": "Test Pack", "version": "1.0.0",
+		"verbs": ["Wash"], "nouns": ["dishes"], "descriptions": ["Somebody has to {job}."],
+		"delivery_destinations": ["the docks"], "delivery_descriptions": ["Take {item} to {destination}."],
+		"combat_enemies": ["a rat"], "combat_descriptions": ["{enemy} is in the cellar."]
+	}`
+	tests := []struct {
+		name string
+		file string
+		data string
+		ok   bool
+	}{
+		{"yaml", "test.yaml", yamlPack, true},
+		{"yml", "TEST.YML", yamlPack, true},
+		{"json", "test.json", jsonPack, true},
+		{"yaml with a typo", "test.yaml", yamlPack + "verbz: [Scrub]\n", false},
+		{"json with a typo", "test.json", strings.Replace(jsonPack, `"nouns"`, `"nounz"`, 1), false},
+		{"broken yaml", "test.yaml", "format: [", false},
+		{"valid file, invalid pack", "test.yaml", strings.Replace(yamlPack, "verbs: [Wash]", "verbs: []", 1), false},
+		{"not a pack file", "test.txt", yamlPack, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pack, err := parseContentPack(tt.file, []byte(tt.data))
+			if (err == nil) != tt.ok {
+				t.Fatalf("parseContentPack(%s) error = %v, want ok %v", tt.file, err, tt.ok)
+			}
+			if tt.ok && (pack.ID != "test-pack" || pack.source != tt.file) {
+				t.Errorf("parseContentPack(%s) = pack %q from %q", tt.file, pack.ID, pack.source)
+			}
+		})
+	}
+}
+
+func TestBuiltinContentPack(t *testing.T) {
+	pack, err := parseContentPack("default.yaml", builtinContentPackYAML)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pack.ID != BUILTIN_CONTENT_PACK {
+		t.Errorf("built in pack is %q, want %q", pack.ID, BUILTIN_CONTENT_PACK)
+	}
+}
@@ -0,0 +1,171 @@
+package app
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+/*
+Cooldowns
+
+A cooldown stops a user from doing the same thing again too soon. Every cooldown is keyed by user and action,
+e.g. "work", and lives in the store rather than in memory so restarting the bot doesn't hand everybody a free go.
+
+Starting a cooldown is also the check for whether one is running, in a single atomic step. That way two
+!work messages arriving at the same time can't both get through.
+
+Running into a cooldown is fine once or twice. Running into the same one over and over is cooldown abuse,
+and Config.Economy.CooldownAbuseLimit strikes in one cooldown earn a demerit. See app/demerits.go
+*/
+
+const (
+	COOLDOWNS_PREFIX        = "cooldowns:" // Sorted set of action to expiry in unix milliseconds, one per user
+	COOLDOWN_STRIKES_PREFIX = "strikes:"   // Times a user ran into a running cooldown, under "strikes:<user_id>:<action>"
+)
+
+// Actions with a cooldown
+const (
+	COOLDOWN_WORK = "work"
+	COOLDOWN_JOB  = "jobs take" // Started by quitting a job
+)
+
+// Cooldown is an action a user has to wait on.
+type Cooldown struct {
+	Action    string
+	ExpiresAt time.Time
+}
+
+// The !cooldowns command
+func init() {
+	commands.Register(&Command{
+		Name:    "cooldowns",
+		Aliases: []string{"cd"},
+		Group:   "General",
+		Summary: "See what you're waiting on",
+		Help:    "Lists everything you've done recently that you can't do again just yet, and how long until you can.",
+		Handler: handleCooldownsCommand,
+		Subcommands: []*Command{
+			helpSubcommand(),
+		},
+	})
+}
+
+// cooldownsKey returns the Redis key for the cooldowns of the given user.
+func cooldownsKey(userID string) string {
+	return COOLDOWNS_PREFIX + userID
+}
+
+// startCooldown starts a cooldown on action for the user, unless one is already running.
+// It returns how long is left on the running cooldown, or 0 if a new one was started.
+// A cooldown of zero or less means the action doesn't have one, so it never has to wait.
+func (a *App) startCooldown(userID, action string, cooldown time.Duration) (time.Duration, error) {
+	if cooldown <= 0 {
+		return 0, nil
+	}
+	return a.store.StartCooldown(a.context, userID, action, time.Now(), cooldown)
+}
+
+// cooldownRemaining returns how long is left on the cooldown on action, or 0 if there isn't one running.
+// Unlike startCooldown it only looks, so it's for cooldowns that something else starts.
+func (a *App) cooldownRemaining(userID, action string) (time.Duration, error) {
+	now := time.Now()
+	cooldowns, err := a.store.Cooldowns(a.context, userID, now)
+	if err != nil {
+		return 0, err
+	}
+	for _, c := range cooldowns {
+		if c.Action == action {
+			return c.ExpiresAt.Sub(now), nil
+		}
+	}
+	return 0, nil
+}
+
+// cooldownStrikesKey returns the Redis key counting how often the user ran into the cooldown on action.
+func cooldownStrikesKey(userID, action string) string {
+	return COOLDOWN_STRIKES_PREFIX + userID + ":" + action
+}
+
+// strikeCooldown records the user running into the cooldown on action, which has remaining left to run.
+// The strike that reaches the abuse limit earns a demerit. It returns whether that happened, and whether the demerit put them in jail.
+func (a *App) strikeCooldown(userID, action string, remaining time.Duration) (bool, bool, error) {
+	limit := a.Config.Economy.CooldownAbuseLimit
+	if limit <= 0 {
+		return false, false, nil
+	}
+	strikes, err := a.store.StrikeCooldown(a.context, userID, action, time.Now().Add(remaining))
+	if err != nil || strikes != int64(limit) {
+		return false, false, err
+	}
+
+	jailed, err := a.issueDemerit(userID, DEMERIT_COOLDOWN_ABUSE, fmt.Sprintf("tried to %s %d times during one cooldown", action, strikes), "")
+	return err == nil, jailed, err
+}
+
+// clearCooldown ends a cooldown early. It's used to give the user their go back when the action failed on our end.
+func (a *App) clearCooldown(userID, action string) {
+	if err := a.store.ClearCooldown(a.context, userID, action); err != nil {
+		a.logger.Error().
+			Err(err).
+			Str("user", userID).
+			Str("action", action).
+			Msg("failed to clear cooldown")
+	}
+}
+
+// handleCooldownsCommand handles the !cooldowns command. It lists every cooldown the user is waiting on.
+func handleCooldownsCommand(a *App, m *Message, args *Args) error {
+	now := time.Now()
+	cooldowns, err := a.store.Cooldowns(a.context, m.Author.ID, now)
+	if err != nil {
+		return err
+	}
+
+	if len(cooldowns) == 0 {
+		return a.handleOutgoingMessage(m.RespondToChannelOrThread(a.Config.AppID, "You're not waiting on anything. Go do something!", true, false))
+	}
+
+	sort.Slice(cooldowns, func(i, j int) bool {
+		return cooldowns[i].ExpiresAt.Before(cooldowns[j].ExpiresAt)
+	})
+
+	lines := []string{"** Cooldowns **"}
+	for _, c := range cooldowns {
+		lines = append(lines, fmt.Sprintf("- %s%s - %s", args.Prefix, c.Action, formatDuration(c.ExpiresAt.Sub(now))))
+	}
+	return a.handleOutgoingMessage(m.RespondToChannelOrThread(a.Config.AppID, strings.Join(lines, "\n"), true, false))
+}
+
+// formatDuration formats a duration for people, e.g. "1h 5m" or "42s". Anything under a second rounds up to 1s.
+func formatDuration(d time.Duration) string {
+	if d < time.Second {
+		return "1s"
+	}
+	d = d.Round(time.Second)
+
+	days := d / (24 * time.Hour)
+	d -= days * 24 * time.Hour
+	hours := d / time.Hour
+	d -= hours * time.Hour
+	minutes := d / time.Minute
+	d -= minutes * time.Minute
+	seconds := d / time.Second
+
+	parts := []string{}
+	if days > 0 {
+		parts = append(parts, fmt.Sprintf("%dd", days))
+	}
+	if hours > 0 {
+		parts = append(parts, fmt.Sprintf("%dh", hours))
+	}
+	if minutes > 0 {
+		parts = append(parts, fmt.Sprintf("%dm", minutes))
+	}
+	// Seconds don't matter much once we're talking hours
+	if seconds > 0 && days == 0 && hours == 0 {
+		parts = append(parts, fmt.Sprintf("%ds", seconds))
+	}
+	return strings.Join(parts, " ")
+}
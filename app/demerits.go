@@ -0,0 +1,313 @@
+package app
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+/*
+Demerits
+
+Demerits are how the game keeps people honest. The bot hands them out by itself for things like hammering a command
+that's on cooldown, and admins can hand them out by hand with !demerits give. Every demerit says why it was given
+and expires after a while (Config.Economy.DemeritExpiry), so a bad week doesn't follow anybody around forever.
+
+The more active demerits a user has, the worse their standing, and every step down has consequences:
+
+  - On probation, everything they earn from work and jobs is cut (DemeritCutAt and DemeritCutPercent).
+  - Locked, they can't use commands that move money around, like !pay and !buy (DemeritLockAt).
+  - Reaching DemeritJailAt lands them in jail for JailTime, where they can't work or take jobs either.
+    Jail time is served in full, even if the demerits that put them there expire first.
+
+Commands say which standing locks them out with Command.LockedAt. The router checks it before running them.
+Demerits live in the profile, so issuing one is just another updateProfile.
+*/
+
+// Why a demerit was given
+const (
+	DEMERIT_LEGACY         = "legacy"           // On record from before demerits had reasons
+	DEMERIT_ADMIN          = "admin"            // Given by an admin with !demerits give
+	DEMERIT_COOLDOWN_ABUSE = "cooldown abuse"   // Kept running into the same cooldown
+	DEMERIT_QUIT_JOB       = "quit job"         // Walked out on a job
+	DEMERIT_FAILED_JOB     = "failed risky job" // Botched a job that was risky to begin with
+)
+
+// legacyDemeritExpiry is how long demerits from before demerits had expiries stay on the record after being migrated.
+const legacyDemeritExpiry = 7 * 24 * time.Hour
+
+// maxDemeritNoteLength is the longest note an admin can put on a demerit.
+const maxDemeritNoteLength = 200
+
+// Demerit is a black mark on a user's record.
+type Demerit struct {
+	Reason    string    `json:"reason"`     // One of the DEMERIT_ reasons
+	Note      string    `json:"note"`       // What happened, in words
+	IssuedBy  string    `json:"issued_by"`  // The admin who gave it. Empty when the bot did.
+	IssuedAt  time.Time `json:"issued_at"`  // When it was given
+	ExpiresAt time.Time `json:"expires_at"` // When it stops counting
+}
+
+// Standing is how much trouble a user is in. Each standing is worse than the one before it.
+type Standing int
+
+const (
+	STANDING_GOOD      Standing = iota // Nothing to worry about
+	STANDING_PROBATION                 // Payouts are cut
+	STANDING_LOCKED                    // Also locked out of commands with LockedAt STANDING_LOCKED
+	STANDING_JAILED                    // Locked out of every command with a LockedAt
+)
+
+// String names the standing for people.
+func (s Standing) String() string {
+	switch s {
+	case STANDING_PROBATION:
+		return "on probation"
+	case STANDING_LOCKED:
+		return "locked out"
+	case STANDING_JAILED:
+		return "in jail"
+	}
+	return "in good standing"
+}
+
+// The !demerits command tree
+func init() {
+	commands.Register(&Command{
+		Name:    "demerits",
+		Aliases: []string{"record"},
+		Group:   "General",
+		Summary: "See your demerits and when they expire",
+		Help: "Demerits are given for breaking the rules, like spamming commands that are on cooldown or walking out on jobs.\n" +
+			"Every demerit expires after a while. Collect too many and your payouts get cut, then you get locked out of spending money, then you go to jail.",
+		Handler: handleDemeritsCommand,
+		Subcommands: []*Command{
+			{
+				Name:      "give",
+				AdminOnly: true,
+				Summary:   "Give somebody a demerit",
+				Args: []ArgSpec{
+					{Name: "user", Description: "Who gets the demerit", Type: ArgUser},
+					{Name: "note", Description: "What they did", Rest: true},
+				},
+				Examples: []string{"demerits give @somebody spamming the channel"},
+				Handler:  handleDemeritsGive,
+			},
+			{
+				Name:      "pardon",
+				AdminOnly: true,
+				Summary:   "Clear somebody's demerits and let them out of jail",
+				Args: []ArgSpec{
+					{Name: "user", Description: "Who to pardon", Type: ArgUser},
+				},
+				Examples: []string{"demerits pardon @somebody"},
+				Handler:  handleDemeritsPardon,
+			},
+			helpSubcommand(),
+		},
+	})
+}
+
+// activeDemerits returns the demerits that haven't expired at now.
+func (p *Profile) activeDemerits(now time.Time) []Demerit {
+	active := []Demerit{}
+	for _, d := range p.Demerits {
+		if d.ExpiresAt.After(now) {
+			active = append(active, d)
+		}
+	}
+	return active
+}
+
+// standing works out how much trouble the user is in at now.
+func (p *Profile) standing(e EconomyConfig, now time.Time) Standing {
+	if p.JailedUntil.After(now) {
+		return STANDING_JAILED
+	}
+	switch active := len(p.activeDemerits(now)); {
+	case active >= e.DemeritLockAt:
+		return STANDING_LOCKED
+	case active >= e.DemeritCutAt:
+		return STANDING_PROBATION
+	}
+	return STANDING_GOOD
+}
+
+// addDemerit puts a demerit on the record, dropping the ones that have expired, and sends the user to jail if that
+// was one too many. It returns whether they were jailed. Like every profile change this belongs inside updateProfile.
+func (p *Profile) addDemerit(e EconomyConfig, reason, note, issuedBy string) bool {
+	now := time.Now()
+	p.Demerits = append(p.activeDemerits(now), Demerit{
+		Reason:    reason,
+		Note:      note,
+		IssuedBy:  issuedBy,
+		IssuedAt:  now,
+		ExpiresAt: now.Add(e.DemeritExpiry),
+	})
+
+	if len(p.Demerits) < e.DemeritJailAt || p.JailedUntil.After(now) {
+		return false
+	}
+	p.JailedUntil = now.Add(e.JailTime)
+	return true
+}
+
+// cutPayout returns what's left of a payout after the cut for being on probation or worse.
+func (p *Profile) cutPayout(e EconomyConfig, payout int) int {
+	if p.standing(e, time.Now()) < STANDING_PROBATION {
+		return payout
+	}
+	return payout * (100 - e.DemeritCutPercent) / 100
+}
+
+// issueDemerit gives a user a demerit outside of any other profile change and logs it.
+// It returns whether the demerit landed them in jail.
+func (a *App) issueDemerit(userID, reason, note, issuedBy string) (bool, error) {
+	jailed := false
+	_, err := a.updateProfile(userID, func(p *Profile) error {
+		jailed = p.addDemerit(a.Config.Economy, reason, note, issuedBy)
+		return nil
+	})
+	if err != nil {
+		return false, err
+	}
+
+	a.logger.Info().
+		Str("user", userID).
+		Str("reason", reason).
+		Str("issued_by", issuedBy).
+		Bool("jailed", jailed).
+		Msg("demerit issued")
+	return jailed, nil
+}
+
+// lockedAt returns the standing that locks users out of the command. Sub-commands without one of their own inherit their parent's.
+func (c *Command) lockedAt() Standing {
+	for cmd := c; cmd != nil; cmd = cmd.parent {
+		if cmd.LockedAt != STANDING_GOOD {
+			return cmd.LockedAt
+		}
+	}
+	return STANDING_GOOD
+}
+
+// checkStanding tells the user off and returns false if their demerits lock them out of the command.
+// prefix is the one the command was typed with.
+func (a *App) checkStanding(m *Message, prefix string, cmd *Command) (bool, error) {
+	lockedAt := cmd.lockedAt()
+	if lockedAt == STANDING_GOOD {
+		return true, nil
+	}
+
+	profile, err := a.getProfile(m.Author.ID)
+	if err != nil {
+		return false, err
+	}
+	now := time.Now()
+	standing := profile.standing(a.Config.Economy, now)
+	if standing < lockedAt {
+		return true, nil
+	}
+
+	a.logger.Info().
+		Str("user", m.Author.Username).
+		Str("command", cmd.Path()).
+		Str("standing", standing.String()).
+		Msg("User is locked out of command")
+
+	msg := fmt.Sprintf("You've got too many demerits to do that. Type `%sdemerits` to see when they expire.", prefix)
+	if standing == STANDING_JAILED {
+		msg = fmt.Sprintf("You're in jail for another %s. Think about what you did.", formatDuration(profile.JailedUntil.Sub(now)))
+	}
+	return false, a.handleOutgoingMessage(m.RespondToChannelOrThread(a.Config.AppID, msg, true, false))
+}
+
+// handleDemeritsCommand handles the !demerits command. It shows the user their record.
+func handleDemeritsCommand(a *App, m *Message, args *Args) error {
+	profile, err := a.getProfile(m.Author.ID)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	e := a.Config.Economy
+	active := profile.activeDemerits(now)
+	standing := profile.standing(e, now)
+
+	if len(active) == 0 && standing == STANDING_GOOD {
+		return a.handleOutgoingMessage(m.RespondToChannelOrThread(a.Config.AppID, "Your record is clean. Keep it that way.", true, false))
+	}
+
+	lines := []string{fmt.Sprintf("** Your record ** (%d demerits, %s)", len(active), standing)}
+	for _, d := range active {
+		by := ""
+		if d.IssuedBy != "" {
+			by = fmt.Sprintf(", from <@%s>", d.IssuedBy)
+		}
+		lines = append(lines, fmt.Sprintf("- %s: %s%s. Expires in %s.", d.Reason, d.Note, by, formatDuration(d.ExpiresAt.Sub(now))))
+	}
+
+	lines = append(lines, "")
+	switch standing {
+	case STANDING_JAILED:
+		lines = append(lines, fmt.Sprintf("You're in jail for another %s. No work, no jobs, no spending.", formatDuration(profile.JailedUntil.Sub(now))))
+	case STANDING_LOCKED:
+		lines = append(lines, fmt.Sprintf("You can't spend or send money until you're under %d demerits, and your payouts are cut by %d%%.", e.DemeritLockAt, e.DemeritCutPercent))
+	case STANDING_PROBATION:
+		lines = append(lines, fmt.Sprintf("Your payouts are cut by %d%% until you're under %d demerits.", e.DemeritCutPercent, e.DemeritCutAt))
+	}
+	if standing < STANDING_JAILED {
+		lines = append(lines, fmt.Sprintf("At %d demerits you go to jail for %s.", e.DemeritJailAt, formatDuration(e.JailTime)))
+	}
+	return a.handleOutgoingMessage(m.RespondToChannelOrThread(a.Config.AppID, strings.Join(lines, "\n"), true, false))
+}
+
+// handleDemeritsGive handles the !demerits give command.
+func handleDemeritsGive(a *App, m *Message, args *Args) error {
+	userID := args.User("user")
+	note := escapeMentions(args.String("note"))
+	if len([]rune(note)) > maxDemeritNoteLength {
+		msg := fmt.Sprintf("That note is too long. Keep it under %d characters.", maxDemeritNoteLength)
+		return a.handleOutgoingMessage(m.RespondToChannelOrThread(a.Config.AppID, msg, true, false))
+	}
+	for _, mentioned := range m.Mentions {
+		if mentioned.ID == userID && mentioned.Bot {
+			return a.handleOutgoingMessage(m.RespondToChannelOrThread(a.Config.AppID, "Bots are above the law.", true, false))
+		}
+	}
+
+	jailed, err := a.issueDemerit(userID, DEMERIT_ADMIN, note, m.Author.ID)
+	if err != nil {
+		return err
+	}
+
+	notice := fmt.Sprintf("<@%s>, you got a demerit for %s. Type `%sdemerits` to see your record.", userID, note, args.Prefix)
+	if jailed {
+		notice = fmt.Sprintf("<@%s>, you got a demerit for %s. That's one too many: you're going to jail for %s.", userID, note, formatDuration(a.Config.Economy.JailTime))
+	}
+	return a.handleOutgoingMessage(m.RespondToChannelOrThread(a.Config.AppID, notice, false, false))
+}
+
+// handleDemeritsPardon handles the !demerits pardon command. It wipes the user's active demerits and ends their jail time.
+// Expired demerits are dropped along with them, they didn't count anymore anyway.
+func handleDemeritsPardon(a *App, m *Message, args *Args) error {
+	userID := args.User("user")
+	cleared := 0
+	_, err := a.updateProfile(userID, func(p *Profile) error {
+		cleared = len(p.activeDemerits(time.Now()))
+		p.Demerits = nil
+		p.JailedUntil = time.Time{}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	a.logger.Info().
+		Str("user", userID).
+		Str("pardoned_by", m.Author.ID).
+		Int("cleared", cleared).
+		Msg("demerits pardoned")
+
+	msg := fmt.Sprintf("<@%s> has been pardoned. %d demerits cleared and they're out of jail if they were in.", userID, cleared)
+	return a.handleOutgoingMessage(m.RespondToChannelOrThread(a.Config.AppID, msg, true, false))
+}
@@ -1,10 +1,16 @@
 package app
 
 import (
+	"errors"
+	"strings"
+
 	"github.com/bytebot-chat/gateway-discord/model"
-	"github.com/go-redis/redis/v8"
+	uuid "github.com/satori/go.uuid"
 )
 
+// ErrNoDMChannel is returned by SendDM when the user has never DMed the bot, so there's no telling where their DMs are.
+var ErrNoDMChannel = errors.New("no known DM channel for user")
+
 // Message is the struct that represents a message received from Discord.
 // It's effectively a just wrapper around the Bytebot/Discord message struct so I can add methods to it.
 type Message struct {
@@ -12,12 +18,12 @@ type Message struct {
 }
 
 // handleIncomingMessage handles an incoming message from Bytebot/Discord.
-func unmarshalIncomingMessage(m *redis.Message) (*Message, error) {
+func unmarshalIncomingMessage(payload []byte) (*Message, error) {
 	// Create a new MessageSend struct
 	var message model.Message
 
 	// Unmarshal the message bytes into the struct
-	err := message.UnmarshalJSON([]byte(m.Payload))
+	err := message.UnmarshalJSON(payload)
 	if err != nil {
 		return nil, err
 	}
@@ -36,21 +42,76 @@ func (a *App) handleOutgoingMessage(m *model.MessageSend) error {
 	}
 
 	// Publish the message to the outbound topic
-	res := a.redis.Publish(a.context, a.Config.OutboundTopic, bytes)
-	if err != nil {
+	if err := a.transport.Publish(a.context, a.Config.OutboundTopic, bytes); err != nil {
 		return err
 	}
 
 	// Log the result
-	a.logger.Debug().
+	event := a.logger.Debug().
 		// We want to log enough context that we can correlate this message with other services in the logs
 		Str("topic", a.Config.OutboundTopic).
 		Str("dest_gateway", m.Metadata.Dest).
 		Str("id", m.Metadata.ID.String()).
-		Str("reply_to_user", m.PreviousMessage.Author.Username+"#"+m.PreviousMessage.Author.Discriminator).
-		Str("reply_to_message_id", m.PreviousMessage.ID).
-		Msg("published message")
+		Str("channel", m.ChannelID)
+	// Messages from SendToChannel and SendDM aren't replies to anything
+	if m.PreviousMessage != nil && m.PreviousMessage.Author != nil {
+		event = event.
+			Str("reply_to_user", m.PreviousMessage.Author.Username+"#"+m.PreviousMessage.Author.Discriminator).
+			Str("reply_to_message_id", m.PreviousMessage.ID)
+	}
+	event.Msg("published message")
+
+	return nil
+}
+
+// SendToChannel sends a message to a channel or thread without anything to reply to, for things like scheduled tasks
+// and announcements. gatewayID is the gateway the channel is on, which is whatever the message that set it off came
+// through. Empty means Config.GatewayID, for things remembered before they remembered their gateway.
+func (a *App) SendToChannel(gatewayID, channelID, content string) error {
+	if gatewayID == "" {
+		gatewayID = a.Config.GatewayID
+	}
+	a.logger.Debug().
+		Str("gateway", gatewayID).
+		Str("channel", channelID).
+		Msg("sending message")
+
+	return a.handleOutgoingMessage(&model.MessageSend{
+		ChannelID: channelID,
+		Content:   content,
+		Metadata: model.Metadata{
+			Source: a.Config.AppID,
+			Dest:   gatewayID,
+			ID:     uuid.NewV4(),
+		},
+	})
+}
+
+// SendDM sends a message to a user's DMs on a gateway. Discord only tells the bot where somebody's DMs are when they DM it,
+// so it returns ErrNoDMChannel for users who never have. Empty gatewayID means Config.GatewayID, like for SendToChannel.
+func (a *App) SendDM(gatewayID, userID, content string) error {
+	if gatewayID == "" {
+		gatewayID = a.Config.GatewayID
+	}
+	channelID, err := a.store.DMChannel(a.context, gatewayID, userID)
+	if err != nil {
+		return err
+	}
+	if channelID == "" {
+		return ErrNoDMChannel
+	}
+	return a.SendToChannel(gatewayID, channelID, content)
+}
+
+// displayName returns the name the author of the message goes by: their server nickname if they have one, their username otherwise.
+func displayName(m *Message) string {
+	if m.Member != nil && m.Member.Nick != "" {
+		return m.Member.Nick
+	}
+	return m.Author.Username
+}
 
-	// Return the result
-	return res.Err()
+// escapeMentions breaks up anything in user supplied text that Discord would turn into a ping, like @everyone.
+func escapeMentions(s string) string {
+	return strings.ReplaceAll(s, "@", "@\u200b")
 }
@@ -0,0 +1,409 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+Guild Settings
+
+Every server (guild, in Discord speak) can tweak how the game behaves there: which prefix commands use,
+which channels the game answers in and where notifications should go. Admins manage them with !config, see App.isAdmin.
+
+Settings are read on every message, so they're cached in memory. The cache entry for a guild is dropped
+whenever its settings are changed through this process, and entries expire after a minute anyway
+so that changes made by another instance of the bot show up eventually.
+
+Direct messages don't have a guild, so they always get the defaults.
+*/
+
+const GUILD_SETTINGS_PREFIX = "guild:" // Guild settings live under "guild:<guild_id>" as JSON
+
+// maxGuildSettingsUpdateAttempts is how many times updateGuildSettings tries before giving up on busy settings.
+const maxGuildSettingsUpdateAttempts = 10
+
+// ErrGuildSettingsContention is returned by updateGuildSettings when the settings kept changing underneath it.
+var ErrGuildSettingsContention = errors.New("server settings are too busy to update, try again")
+
+// defaultCommandPrefix is what commands start with unless a guild picks something else.
+const defaultCommandPrefix = "!"
+
+// guildSettingsCacheTTL is how long cached settings are trusted before they're read from the store again.
+const guildSettingsCacheTTL = time.Minute
+
+// Channel modes decide which channels the game answers in.
+const (
+	ChannelModeAll   = "all"   // Answer everywhere
+	ChannelModeAllow = "allow" // Only answer in the listed channels
+	ChannelModeDeny  = "deny"  // Answer everywhere except the listed channels
+)
+
+// GuildSettings holds the settings of a single guild.
+type GuildSettings struct {
+	GuildID       string   `json:"guild_id"`
+	Prefix        string   `json:"prefix"`
+	ChannelMode   string   `json:"channel_mode"`
+	Channels      []string `json:"channels"`
+	NotifyChannel string   `json:"notify_channel"` // Where notifications go. Empty means wherever the command was run.
+	ContentPack   string   `json:"content_pack"`   // The content pack jobs here are made of. Empty means Config.ContentPack. See app/contentPacks.go
+}
+
+// guildSetting describes a setting that can be read and changed with !config.
+type guildSetting struct {
+	Name        string
+	Description string
+	get         func(s *GuildSettings) string
+	set         func(s *GuildSettings, words []string) error // words is the new value, already tokenized
+}
+
+// guildSettingsList is every setting !config knows about, in the order they're listed.
+var guildSettingsList = []*guildSetting{
+	{
+		Name:        "prefix",
+		Description: "What commands start with",
+		get: func(s *GuildSettings) string {
+			return "`" + s.Prefix + "`"
+		},
+		set: func(s *GuildSettings, words []string) error {
+			if len(words) != 1 || len([]rune(words[0])) > 5 {
+				return fmt.Errorf("the prefix must be a single word of up to 5 characters")
+			}
+			s.Prefix = words[0]
+			return nil
+		},
+	},
+	{
+		Name:        "channel-mode",
+		Description: "Where the game answers: all, allow (only the listed channels) or deny (everywhere but the listed channels)",
+		get: func(s *GuildSettings) string {
+			return s.ChannelMode
+		},
+		set: func(s *GuildSettings, words []string) error {
+			if len(words) == 1 {
+				switch mode := strings.ToLower(words[0]); mode {
+				case ChannelModeAll, ChannelModeAllow, ChannelModeDeny:
+					s.ChannelMode = mode
+					return nil
+				}
+			}
+			return fmt.Errorf("the channel mode must be one of %s, %s or %s", ChannelModeAll, ChannelModeAllow, ChannelModeDeny)
+		},
+	},
+	{
+		Name:        "channels",
+		Description: "The channels channel-mode allows or denies, or none",
+		get: func(s *GuildSettings) string {
+			return channelList(s.Channels)
+		},
+		set: func(s *GuildSettings, words []string) error {
+			channels, err := parseChannels(words)
+			if err != nil {
+				return err
+			}
+			s.Channels = channels
+			return nil
+		},
+	},
+	{
+		Name:        "notify-channel",
+		Description: "Where notifications go, or none to send them wherever the command was run",
+		get: func(s *GuildSettings) string {
+			if s.NotifyChannel == "" {
+				return "none"
+			}
+			return "<#" + s.NotifyChannel + ">"
+		},
+		set: func(s *GuildSettings, words []string) error {
+			channels, err := parseChannels(words)
+			if err != nil {
+				return err
+			}
+			if len(channels) > 1 {
+				return fmt.Errorf("there can only be one notification channel")
+			}
+			s.NotifyChannel = ""
+			if len(channels) == 1 {
+				s.NotifyChannel = channels[0]
+			}
+			return nil
+		},
+	},
+}
+
+// The !config command tree
+func init() {
+	names := make([]string, 0, len(guildSettingsList))
+	for _, setting := range guildSettingsList {
+		names = append(names, setting.Name)
+	}
+
+	commands.Register(&Command{
+		Name:      "config",
+		Group:     "Admin",
+		AdminOnly: true,
+		Summary:   "See and change the settings for this server",
+		Help: "Admins can change how the game behaves in this server.\n" +
+			"Settings: " + strings.Join(names, ", "),
+		Examples: []string{"config list", "config set prefix ?", "config set channel-mode allow", "config set channels #games #bots"},
+		Handler:  handleConfigList,
+		Subcommands: []*Command{
+			{
+				Name:    "list",
+				Summary: "List the settings for this server",
+				Handler: handleConfigList,
+			},
+			{
+				Name:    "get",
+				Summary: "Show a single setting",
+				Args: []ArgSpec{
+					{Name: "setting", Description: "Name of the setting", Type: ArgEnum, Choices: names},
+				},
+				Handler: handleConfigGet,
+			},
+			{
+				Name:    "set",
+				Summary: "Change a setting",
+				Args: []ArgSpec{
+					{Name: "setting", Description: "Name of the setting", Type: ArgEnum, Choices: names},
+					{Name: "value", Description: "New value of the setting", Rest: true},
+				},
+				Handler: handleConfigSet,
+			},
+			helpSubcommand(),
+		},
+	})
+}
+
+// defaultGuildSettings returns the settings a guild has before anybody changes them.
+func defaultGuildSettings(guildID string) *GuildSettings {
+	return &GuildSettings{
+		GuildID:     guildID,
+		Prefix:      defaultCommandPrefix,
+		ChannelMode: ChannelModeAll,
+		Channels:    []string{},
+	}
+}
+
+// guildSettingsKey returns the Redis key for the settings of the given guild.
+func guildSettingsKey(guildID string) string {
+	return GUILD_SETTINGS_PREFIX + guildID
+}
+
+// respondsIn reports whether the game should answer commands in the given channel.
+func (s *GuildSettings) respondsIn(channelID string) bool {
+	listed := false
+	for _, c := range s.Channels {
+		if c == channelID {
+			listed = true
+			break
+		}
+	}
+
+	switch s.ChannelMode {
+	case ChannelModeAllow:
+		return listed
+	case ChannelModeDeny:
+		return !listed
+	default:
+		return true
+	}
+}
+
+// guildSettingsCache keeps recently used guild settings in memory.
+type guildSettingsCache struct {
+	mu      sync.Mutex
+	entries map[string]*cachedGuildSettings
+}
+
+// cachedGuildSettings is a cache entry along with when it stops being trusted.
+type cachedGuildSettings struct {
+	settings  GuildSettings
+	expiresAt time.Time
+}
+
+// newGuildSettingsCache creates an empty cache.
+func newGuildSettingsCache() *guildSettingsCache {
+	return &guildSettingsCache{
+		entries: map[string]*cachedGuildSettings{},
+	}
+}
+
+// get returns a copy of the cached settings for a guild, if there are any that haven't expired.
+func (c *guildSettingsCache) get(guildID string) (*GuildSettings, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[guildID]
+	if !ok || time.Now().After(entry.expiresAt) {
+		return nil, false
+	}
+	settings := entry.settings
+	settings.Channels = append([]string{}, entry.settings.Channels...)
+	return &settings, true
+}
+
+// put caches a copy of the settings for a guild.
+func (c *guildSettingsCache) put(settings *GuildSettings) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry := &cachedGuildSettings{settings: *settings, expiresAt: time.Now().Add(guildSettingsCacheTTL)}
+	entry.settings.Channels = append([]string{}, settings.Channels...)
+	c.entries[settings.GuildID] = entry
+}
+
+// invalidate drops the cached settings for a guild.
+func (c *guildSettingsCache) invalidate(guildID string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.entries, guildID)
+}
+
+// guildSettings returns the settings for the given guild. An empty guild ID (a DM) gets the defaults.
+func (a *App) guildSettings(guildID string) (*GuildSettings, error) {
+	if guildID == "" {
+		return defaultGuildSettings(guildID), nil
+	}
+	if settings, ok := a.settings.get(guildID); ok {
+		return settings, nil
+	}
+
+	settings, err := a.store.GetGuildSettings(a.context, guildID)
+	if err != nil {
+		return nil, err
+	}
+	a.settings.put(settings)
+	return settings, nil
+}
+
+// updateGuildSettings atomically changes the settings of a guild with fn and saves them.
+// fn may run more than once if the settings change underneath it, and if it returns an error nothing is saved.
+func (a *App) updateGuildSettings(guildID string, fn func(*GuildSettings) error) (*GuildSettings, error) {
+	settings, err := a.store.UpdateGuildSettings(a.context, guildID, fn)
+	if err != nil {
+		return nil, err
+	}
+	a.settings.invalidate(guildID)
+	return settings, nil
+}
+
+// isAdmin reports whether the author of a message can run admin commands, which is whether they're listed in Config.Admins.
+// Discord doesn't fill in member permissions on the messages the gateway forwards, and the bot has no guild or role
+// state to work them out from, so it can't tell who runs a server. Admins have to be configured.
+func (a *App) isAdmin(m *Message) bool {
+	for _, id := range a.Config.Admins {
+		if id == m.Author.ID {
+			return true
+		}
+	}
+	return false
+}
+
+// handleConfigList handles the !config list command.
+func handleConfigList(a *App, m *Message, args *Args) error {
+	if m.GuildID == "" {
+		return a.handleOutgoingMessage(m.RespondToChannelOrThread(a.Config.AppID, "Settings are per server. Run this in a server.", true, false))
+	}
+
+	settings, err := a.guildSettings(m.GuildID)
+	if err != nil {
+		return err
+	}
+
+	lines := []string{"** Settings for this server **"}
+	for _, setting := range guildSettingsList {
+		lines = append(lines, fmt.Sprintf("- %s: %s - %s", setting.Name, setting.get(settings), setting.Description))
+	}
+	return a.handleOutgoingMessage(m.RespondToChannelOrThread(a.Config.AppID, strings.Join(lines, "\n"), true, false))
+}
+
+// handleConfigGet handles the !config get command.
+func handleConfigGet(a *App, m *Message, args *Args) error {
+	if m.GuildID == "" {
+		return a.handleOutgoingMessage(m.RespondToChannelOrThread(a.Config.AppID, "Settings are per server. Run this in a server.", true, false))
+	}
+
+	settings, err := a.guildSettings(m.GuildID)
+	if err != nil {
+		return err
+	}
+
+	setting := findGuildSetting(args.String("setting"))
+	msg := fmt.Sprintf("%s is %s", setting.Name, setting.get(settings))
+	return a.handleOutgoingMessage(m.RespondToChannelOrThread(a.Config.AppID, msg, true, false))
+}
+
+// handleConfigSet handles the !config set command.
+func handleConfigSet(a *App, m *Message, args *Args) error {
+	if m.GuildID == "" {
+		return a.handleOutgoingMessage(m.RespondToChannelOrThread(a.Config.AppID, "Settings are per server. Run this in a server.", true, false))
+	}
+
+	setting := findGuildSetting(args.String("setting"))
+	var invalid error
+	settings, err := a.updateGuildSettings(m.GuildID, func(s *GuildSettings) error {
+		invalid = setting.set(s, strings.Fields(args.String("value")))
+		return invalid
+	})
+	if invalid != nil {
+		return a.handleOutgoingMessage(m.RespondToChannelOrThread(a.Config.AppID, "That didn't work: "+invalid.Error()+".", true, false))
+	}
+	if err != nil {
+		return err
+	}
+
+	a.logger.Info().
+		Str("user", m.Author.Username).
+		Str("guild", m.GuildID).
+		Str("setting", setting.Name).
+		Str("value", setting.get(settings)).
+		Msg("guild setting changed")
+
+	msg := fmt.Sprintf("%s is now %s", setting.Name, setting.get(settings))
+	return a.handleOutgoingMessage(m.RespondToChannelOrThread(a.Config.AppID, msg, true, false))
+}
+
+// findGuildSetting returns the setting with the given name, or nil if there isn't one.
+func findGuildSetting(name string) *guildSetting {
+	for _, setting := range guildSettingsList {
+		if setting.Name == name {
+			return setting
+		}
+	}
+	return nil
+}
+
+// parseChannels parses a list of channels. "none" on its own means an empty list.
+func parseChannels(words []string) ([]string, error) {
+	channels := []string{}
+	if len(words) == 1 && strings.EqualFold(words[0], "none") {
+		return channels, nil
+	}
+	if len(words) == 0 {
+		return nil, fmt.Errorf("give one or more channels, like #general, or none")
+	}
+	for _, word := range words {
+		id, ok := parseChannel(word)
+		if !ok {
+			return nil, fmt.Errorf("`%s` is not a channel", word)
+		}
+		channels = append(channels, id)
+	}
+	return channels, nil
+}
+
+// channelList formats a list of channel IDs as channel links.
+func channelList(channels []string) string {
+	if len(channels) == 0 {
+		return "none"
+	}
+	links := make([]string, 0, len(channels))
+	for _, id := range channels {
+		links = append(links, "<#"+id+">")
+	}
+	return strings.Join(links, " ")
+}
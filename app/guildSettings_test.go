@@ -0,0 +1,45 @@
+package app
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestConfigNeedsConfiguredAdmin(t *testing.T) {
+	a, transport := newTestApp(t)
+	a.Config.Admins = []string{"admin"}
+
+	send(t, a, transport, "1", "!config set prefix ?")
+	if settings, err := a.guildSettings(testGuild); err != nil || settings.Prefix != defaultCommandPrefix {
+		t.Fatalf("somebody who isn't an admin changed the prefix to %q (%v)", settings.Prefix, err)
+	}
+
+	send(t, a, transport, "admin", "!config set prefix ?")
+	if replies := send(t, a, transport, "admin", "?config get prefix"); !strings.Contains(strings.Join(replies, "\n"), "`?`") {
+		t.Errorf("replied %q, want the new prefix", replies)
+	}
+}
+
+func TestUpdateGuildSettingsAllOrNothing(t *testing.T) {
+	a, _ := newTestApp(t)
+	if _, err := a.updateGuildSettings(testGuild, func(s *GuildSettings) error {
+		s.Prefix = "?"
+		return errors.New("changed my mind")
+	}); err == nil {
+		t.Fatal("the update didn't fail")
+	}
+	if settings, err := a.guildSettings(testGuild); err != nil || settings.Prefix != defaultCommandPrefix {
+		t.Fatalf("prefix %q (%v) after a failed update, want %q", settings.Prefix, err, defaultCommandPrefix)
+	}
+
+	if _, err := a.updateGuildSettings(testGuild, func(s *GuildSettings) error {
+		s.Prefix = "?"
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if settings, err := a.guildSettings(testGuild); err != nil || settings.Prefix != "?" {
+		t.Fatalf("prefix %q (%v) after the update, want ?", settings.Prefix, err)
+	}
+}
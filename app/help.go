@@ -0,0 +1,236 @@
+package app
+
+import (
+	"fmt"
+	"strings"
+)
+
+/*
+Help
+
+Help is generated from the command registry, so a command shows up in !help as soon as it's registered.
+!help lists every command grouped by subsystem, !help <command> shows the details of a single command,
+and unknown commands get a "did you mean" suggestion based on edit distance to the known names.
+*/
+
+const helpHeader = `** Don't Break the Chat ** is an experimental chat-based game using the Bytebot ecosystem. It's a work in progress.`
+
+// helpFooter ends the list of commands. It's formatted with the guild's command prefix.
+const helpFooter = "Type `%shelp <command>` for details on a command.\nFile an issue on Github at https://github.com/bytebot-chat/dont-break-the-chat/issues"
+
+// helpPrefix stands in for the command prefix in a command's Help, since that depends on the guild it's shown in.
+const helpPrefix = "{prefix}"
+
+// maxSuggestionDistance is the most edits a typo can be away from a command for us to suggest it.
+const maxSuggestionDistance = 2
+
+// handleHelp handles the !help command.
+// With no arguments it lists every command. Otherwise it shows the details of the command named by the arguments.
+// Commands are written with the guild's prefix, since that's what people have to type.
+func handleHelp(a *App, m *Message, args *Args) error {
+	prefix := args.Prefix
+
+	words := strings.Fields(args.String("command"))
+	if len(words) == 0 {
+		return a.handleOutgoingMessage(m.RespondToChannelOrThread(a.Config.AppID, commands.helpText(prefix), true, false))
+	}
+
+	// Let people ask for "!help !jobs take" as well as "!help jobs take"
+	words[0] = strings.TrimPrefix(words[0], prefix)
+
+	cmd, rest := commands.Resolve(words)
+	if cmd == nil || len(rest) > 0 {
+		msg := fmt.Sprintf("There's no command called `%s%s`.", prefix, strings.Join(words, " "))
+		if suggestion := commands.suggest(words); suggestion != "" {
+			msg += fmt.Sprintf(" Did you mean `%s%s`?", prefix, suggestion)
+		}
+		return a.handleOutgoingMessage(m.RespondToChannelOrThread(a.Config.AppID, msg, true, false))
+	}
+
+	return a.handleOutgoingMessage(m.RespondToChannelOrThread(a.Config.AppID, cmd.helpText(prefix), true, false))
+}
+
+// helpSubcommand returns a "help" sub-command that shows the detailed help of whatever command it's registered under.
+func helpSubcommand() *Command {
+	help := &Command{
+		Name:    "help",
+		Summary: "Get help with this command (you're looking at it)",
+	}
+	help.Handler = func(a *App, m *Message, args *Args) error {
+		return a.handleOutgoingMessage(m.RespondToChannelOrThread(a.Config.AppID, help.parent.helpText(args.Prefix), true, false))
+	}
+	return help
+}
+
+// helpText lists every command in the registry, grouped by subsystem in the order the groups were registered.
+// Commands are written with the given prefix.
+func (r *CommandRegistry) helpText(prefix string) string {
+	groups := []string{}
+	byGroup := map[string][]string{}
+	for _, cmd := range r.Commands() {
+		cmd.walk(func(c *Command) {
+			if c.Handler == nil || c.Name == "help" && c.parent != nil {
+				return
+			}
+			if _, ok := byGroup[c.Group]; !ok {
+				groups = append(groups, c.Group)
+			}
+			byGroup[c.Group] = append(byGroup[c.Group], fmt.Sprintf("- %s%s - %s", prefix, c.Usage(), c.Summary))
+		})
+	}
+
+	lines := []string{helpHeader, ""}
+	for _, group := range groups {
+		lines = append(lines, "** "+group+" **")
+		lines = append(lines, byGroup[group]...)
+		lines = append(lines, "")
+	}
+	lines = append(lines, fmt.Sprintf(helpFooter, prefix))
+	return strings.Join(lines, "\n")
+}
+
+// helpText describes a single command in detail: usage, arguments, aliases, examples and sub-commands.
+// Commands are written with the given prefix.
+func (c *Command) helpText(prefix string) string {
+	lines := []string{"** " + prefix + c.Path() + " **"}
+	if c.Help != "" {
+		lines = append(lines, strings.ReplaceAll(c.Help, helpPrefix, prefix))
+	} else if c.Summary != "" {
+		lines = append(lines, c.Summary)
+	}
+
+	if c.Handler != nil {
+		lines = append(lines, "", "Usage: `"+prefix+c.Usage()+"`")
+	}
+
+	if len(c.Args) > 0 {
+		lines = append(lines, "", "** Arguments **")
+		for _, arg := range c.Args {
+			optional := ""
+			if arg.Optional {
+				optional = " (optional)"
+			}
+			lines = append(lines, fmt.Sprintf("- %s%s - %s", arg.Name, optional, arg.Description))
+		}
+	}
+
+	if len(c.Aliases) > 0 {
+		lines = append(lines, "", "Also known as: "+prefix+strings.Join(c.aliasPaths(), ", "+prefix))
+	}
+
+	if len(c.Subcommands) > 0 {
+		lines = append(lines, "", "** Commands **")
+		for _, sub := range c.Subcommands {
+			lines = append(lines, fmt.Sprintf("- %s%s - %s", prefix, sub.Usage(), sub.Summary))
+		}
+	}
+
+	if len(c.Examples) > 0 {
+		lines = append(lines, "", "** Examples **")
+		for _, example := range c.Examples {
+			lines = append(lines, "- `"+prefix+example+"`")
+		}
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+// aliasPaths returns the full path of the command under each of its aliases.
+func (c *Command) aliasPaths() []string {
+	prefix := ""
+	if c.parent != nil {
+		prefix = c.parent.Path() + " "
+	}
+	paths := []string{}
+	for _, alias := range c.Aliases {
+		paths = append(paths, prefix+alias)
+	}
+	return paths
+}
+
+// walk calls fn for the command and every command nested under it, parents first.
+func (c *Command) walk(fn func(*Command)) {
+	fn(c)
+	for _, sub := range c.Subcommands {
+		sub.walk(fn)
+	}
+}
+
+// suggest returns the path of the command closest to what the user typed, or "" if nothing is close enough.
+// The known part of the path is kept and only the first word that didn't match is corrected,
+// so "!jobs tkae 3" suggests "jobs take" and "!job" suggests "jobs".
+func (r *CommandRegistry) suggest(words []string) string {
+	if len(words) == 0 {
+		return ""
+	}
+
+	// Find the candidates for the first word that doesn't match anything
+	var candidates []*Command
+	typo := words[0]
+	prefix := ""
+	cmd, rest := r.Resolve(words)
+	if cmd == nil {
+		candidates = r.Commands()
+	} else {
+		if len(rest) == 0 {
+			return ""
+		}
+		candidates = cmd.Subcommands
+		typo = rest[0]
+		prefix = cmd.Path() + " "
+	}
+
+	best, bestDistance := "", maxSuggestionDistance+1
+	for _, candidate := range candidates {
+		for _, name := range candidate.names() {
+			distance := editDistance(strings.ToLower(typo), name)
+			// Don't "correct" a short word into something completely different
+			if distance >= len(name) || distance >= len(typo) {
+				continue
+			}
+			if distance < bestDistance {
+				best, bestDistance = candidate.Name, distance
+			}
+		}
+	}
+	if best == "" {
+		return ""
+	}
+	return prefix + best
+}
+
+// editDistance returns the Levenshtein distance between two strings.
+func editDistance(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+
+	// Only keep two rows of the table around
+	prev := make([]int, len(rb)+1)
+	curr := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+
+	for i := 1; i <= len(ra); i++ {
+		curr[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			curr[j] = min3(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+		}
+		prev, curr = curr, prev
+	}
+	return prev[len(rb)]
+}
+
+// min3 returns the smallest of three integers.
+func min3(a, b, c int) int {
+	if b < a {
+		a = b
+	}
+	if c < a {
+		a = c
+	}
+	return a
+}
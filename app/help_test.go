@@ -0,0 +1,37 @@
+package app
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRepliesUseGuildPrefix(t *testing.T) {
+	a, transport := newTestApp(t)
+	if _, err := a.updateGuildSettings(testGuild, func(s *GuildSettings) error {
+		s.Prefix = "?"
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		command string // What the user types
+		want    string // Part of the reply
+	}{
+		{"?help pay", "`?pay confirm`"},
+		{"?inventory", "`?shop`"},
+		{"?jobs quit", "`?jobs list`"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.command, func(t *testing.T) {
+			replies := send(t, a, transport, "1", tt.command)
+			reply := strings.Join(replies, "\n")
+			if !strings.Contains(reply, tt.want) {
+				t.Errorf("replied %q, want %q", replies, tt.want)
+			}
+			if strings.Contains(reply, "`!") {
+				t.Errorf("replied %q, which tells the user to type the default prefix", replies)
+			}
+		})
+	}
+}
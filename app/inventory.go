@@ -1,10 +1,322 @@
 package app
 
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+)
+
+/*
+Inventories
+
+An inventory holds everything a user owns. Most items are interchangeable, so they're kept as stacks:
+one entry per item with a count. Some items are one of a kind (the catalog marks them "instanced"),
+and every one of those is kept as its own instance with an ID, when it was acquired and whatever
+metadata came with it, like where it came from.
+
+An item's stack limit counts both, so nobody can hold more of an item than the catalog allows.
+
+Inventories used to carry a balance of their own that nothing ever read or wrote. Profile.Balance is
+the only balance, and the 3 -> 4 profile migration folds any old inventory balance into it.
+*/
+
+// inventoryPageSize is how many lines a page of !inventory has.
+const inventoryPageSize = 10
+
+// instanceIDLength is how many characters an item instance ID has.
+const instanceIDLength = 8
+
 type Inventory struct {
 	// The unique ID of the inventory. This is the same as the snowflake ID of the user in Discord.
 	ID string `json:"id"`
-	// The user's currency balance
-	Balance int `json:"balance"`
-	// The User's demerits
-	Demerits int `json:"demerits"`
+	// The balance inventories used to have. Only the 3 -> 4 profile migration reads it, and it's always zero afterwards.
+	LegacyBalance int `json:"balance,omitempty"`
+	// The demerit count inventories used to have. Only the 4 -> 5 profile migration reads it, see Profile.Demerits for the real ones.
+	LegacyDemerits int `json:"demerits,omitempty"`
+	// The interchangeable items the user owns, one stack per item. See app/catalog.go for what the items are.
+	Stacks []ItemStack `json:"stacks"`
+	// The one of a kind items the user owns, oldest first
+	Instances []ItemInstance `json:"instances"`
+}
+
+// ItemStack is some number of the same item.
+type ItemStack struct {
+	ItemID   string `json:"item_id"`
+	Quantity int    `json:"quantity"`
+}
+
+// ItemInstance is a single one of a kind item.
+type ItemInstance struct {
+	ID         string            `json:"id"`          // Short random ID, unique within the inventory
+	ItemID     string            `json:"item_id"`     // What the item is
+	AcquiredAt time.Time         `json:"acquired_at"` // When the user got it
+	Metadata   map[string]string `json:"metadata"`    // Anything else worth knowing about it, e.g. where it came from
+}
+
+// The !inventory command tree
+func init() {
+	commands.Register(&Command{
+		Name:    "inventory",
+		Aliases: []string{"inv", "items"},
+		Group:   "Shop",
+		Summary: "See what you own",
+		Help:    "Lists everything you own. Use `{prefix}inventory inspect` to take a closer look at something.",
+		Args: []ArgSpec{
+			{Name: "page", Description: "Which page to show", Type: ArgInt, Optional: true},
+		},
+		Examples: []string{"inventory", "inventory 2", "inventory inspect stim-pack"},
+		Handler:  handleInventoryCommand,
+		Subcommands: []*Command{
+			{
+				Name:    "inspect",
+				Summary: "Take a closer look at something you own",
+				Help:    "Shows the details of an item you own. Name it by its ID, its name or, for one of a kind items, the instance ID shown by `{prefix}inventory`.",
+				Args: []ArgSpec{
+					{Name: "item", Description: "The item or instance to inspect", Rest: true},
+				},
+				Examples: []string{"inventory inspect stim-pack", "inventory inspect \"space suit\"", "inventory inspect 1a2b3c4d"},
+				Handler:  handleInventoryInspect,
+			},
+			helpSubcommand(),
+		},
+	})
+}
+
+// quantity returns how many of an item the inventory holds, stacked or not.
+func (inv *Inventory) quantity(itemID string) int {
+	total := 0
+	for _, stack := range inv.Stacks {
+		if stack.ItemID == itemID {
+			total += stack.Quantity
+		}
+	}
+	for _, instance := range inv.Instances {
+		if instance.ItemID == itemID {
+			total++
+		}
+	}
+	return total
+}
+
+// addItem adds some of an item to the inventory. Instanced items get an instance each, with a copy of metadata.
+// Callers check the stack limit.
+func (inv *Inventory) addItem(item *Item, quantity int, metadata map[string]string) {
+	if item.Instanced {
+		for i := 0; i < quantity; i++ {
+			instance := ItemInstance{
+				ID:         inv.newInstanceID(),
+				ItemID:     item.ID,
+				AcquiredAt: time.Now(),
+				Metadata:   map[string]string{},
+			}
+			for k, v := range metadata {
+				instance.Metadata[k] = v
+			}
+			inv.Instances = append(inv.Instances, instance)
+		}
+		return
+	}
+
+	for i := range inv.Stacks {
+		if inv.Stacks[i].ItemID == item.ID {
+			inv.Stacks[i].Quantity += quantity
+			return
+		}
+	}
+	inv.Stacks = append(inv.Stacks, ItemStack{ItemID: item.ID, Quantity: quantity})
+}
+
+// removeItem takes some of an item out of the inventory, oldest instances first. Empty stacks are dropped.
+// It returns false and changes nothing if there aren't enough.
+func (inv *Inventory) removeItem(itemID string, quantity int) bool {
+	if inv.quantity(itemID) < quantity {
+		return false
+	}
+
+	for i := 0; i < len(inv.Stacks) && quantity > 0; i++ {
+		if inv.Stacks[i].ItemID != itemID {
+			continue
+		}
+		taken := quantity
+		if taken > inv.Stacks[i].Quantity {
+			taken = inv.Stacks[i].Quantity
+		}
+		inv.Stacks[i].Quantity -= taken
+		quantity -= taken
+		if inv.Stacks[i].Quantity == 0 {
+			inv.Stacks = append(inv.Stacks[:i], inv.Stacks[i+1:]...)
+			i--
+		}
+	}
+
+	kept := inv.Instances[:0]
+	for _, instance := range inv.Instances {
+		if instance.ItemID == itemID && quantity > 0 {
+			quantity--
+			continue
+		}
+		kept = append(kept, instance)
+	}
+	inv.Instances = kept
+	return true
+}
+
+// instance returns the instance with the given ID, or nil if there isn't one. IDs are matched ignoring case and a leading "#".
+func (inv *Inventory) instance(id string) *ItemInstance {
+	id = strings.TrimPrefix(strings.ToLower(strings.TrimSpace(id)), "#")
+	for i := range inv.Instances {
+		if inv.Instances[i].ID == id {
+			return &inv.Instances[i]
+		}
+	}
+	return nil
+}
+
+// newInstanceID returns an instance ID nothing in the inventory has yet.
+func (inv *Inventory) newInstanceID() string {
+	for {
+		id := strings.ReplaceAll(uuid.NewV4().String(), "-", "")[:instanceIDLength]
+		if inv.instance(id) == nil {
+			return id
+		}
+	}
+}
+
+// itemName returns what to call an item in chat. Items that have been taken out of the catalog only have their ID.
+func itemName(itemID string) string {
+	if item := catalog.item(itemID); item != nil {
+		return item.Name
+	}
+	return itemID + " (discontinued)"
+}
+
+// lines describes everything in the inventory, one line per stack or instance, sorted by name.
+func (inv *Inventory) lines() []string {
+	type entry struct {
+		name string
+		line string
+	}
+	entries := []entry{}
+	for _, stack := range inv.Stacks {
+		name := itemName(stack.ItemID)
+		entries = append(entries, entry{name, fmt.Sprintf("- %s x%d (`%s`)", name, stack.Quantity, stack.ItemID)})
+	}
+	for _, instance := range inv.Instances {
+		name := itemName(instance.ItemID)
+		entries = append(entries, entry{name, fmt.Sprintf("- %s #%s", name, instance.ID)})
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		return strings.ToLower(entries[i].name) < strings.ToLower(entries[j].name)
+	})
+
+	lines := make([]string, 0, len(entries))
+	for _, e := range entries {
+		lines = append(lines, e.line)
+	}
+	return lines
+}
+
+// handleInventoryCommand handles the !inventory command.
+func handleInventoryCommand(a *App, m *Message, args *Args) error {
+	page := 1
+	if args.Has("page") {
+		page = args.Int("page")
+	}
+	if page < 1 {
+		return a.handleOutgoingMessage(m.RespondToChannelOrThread(a.Config.AppID, "Pages start at 1.", true, false))
+	}
+
+	profile, err := a.getProfile(m.Author.ID)
+	if err != nil {
+		return err
+	}
+
+	lines := profile.Inventory.lines()
+	if len(lines) == 0 {
+		msg := fmt.Sprintf("You don't own anything yet, just %d dollars. Type `%sshop` to see what's for sale.", profile.Balance, args.Prefix)
+		return a.handleOutgoingMessage(m.RespondToChannelOrThread(a.Config.AppID, msg, true, false))
+	}
+
+	pages := (len(lines) + inventoryPageSize - 1) / inventoryPageSize
+	if page > pages {
+		msg := fmt.Sprintf("That's past the last page. Your inventory ends at page %d.", pages)
+		return a.handleOutgoingMessage(m.RespondToChannelOrThread(a.Config.AppID, msg, true, false))
+	}
+
+	start := (page - 1) * inventoryPageSize
+	end := start + inventoryPageSize
+	if end > len(lines) {
+		end = len(lines)
+	}
+
+	msg := []string{fmt.Sprintf("** Your inventory ** (page %d of %d)", page, pages)}
+	msg = append(msg, lines[start:end]...)
+	msg = append(msg, "", fmt.Sprintf("You also have %d dollars.", profile.Balance))
+	if page < pages {
+		msg = append(msg, fmt.Sprintf("Type `%sinventory %d` for the next page.", args.Prefix, page+1))
+	}
+	return a.handleOutgoingMessage(m.RespondToChannelOrThread(a.Config.AppID, strings.Join(msg, "\n"), true, false))
+}
+
+// handleInventoryInspect handles the !inventory inspect command.
+func handleInventoryInspect(a *App, m *Message, args *Args) error {
+	profile, err := a.getProfile(m.Author.ID)
+	if err != nil {
+		return err
+	}
+	query := args.String("item")
+
+	// A single instance
+	if instance := profile.Inventory.instance(query); instance != nil {
+		lines := []string{fmt.Sprintf("** %s #%s **", itemName(instance.ItemID), instance.ID)}
+		if item := catalog.item(instance.ItemID); item != nil {
+			lines = append(lines, item.Description, fmt.Sprintf("Rarity: %s. The shop would give you %d dollars for it.", item.Rarity, a.sellPrice(item)))
+		}
+		lines = append(lines, "Acquired: "+instance.AcquiredAt.UTC().Format("2006-01-02 15:04 MST"))
+		lines = append(lines, metadataLines(instance.Metadata)...)
+		return a.handleOutgoingMessage(m.RespondToChannelOrThread(a.Config.AppID, strings.Join(lines, "\n"), true, false))
+	}
+
+	// Everything of an item
+	item := catalog.find(query)
+	if item == nil {
+		msg := fmt.Sprintf("There's no item called `%s`. Type `%sinventory` to see what you have.", query, args.Prefix)
+		return a.handleOutgoingMessage(m.RespondToChannelOrThread(a.Config.AppID, msg, true, false))
+	}
+	held := profile.Inventory.quantity(item.ID)
+	if held == 0 {
+		msg := fmt.Sprintf("You don't have any %s.", item.Name)
+		return a.handleOutgoingMessage(m.RespondToChannelOrThread(a.Config.AppID, msg, true, false))
+	}
+
+	lines := []string{
+		fmt.Sprintf("** %s ** (`%s`)", item.Name, item.ID),
+		item.Description,
+		fmt.Sprintf("Rarity: %s. You have %d of %d you can carry.", item.Rarity, held, item.StackLimit),
+		fmt.Sprintf("The shop would give you %d dollars each.", a.sellPrice(item)),
+	}
+	for _, instance := range profile.Inventory.Instances {
+		if instance.ItemID == item.ID {
+			lines = append(lines, fmt.Sprintf("- #%s, acquired %s", instance.ID, instance.AcquiredAt.UTC().Format("2006-01-02")))
+		}
+	}
+	return a.handleOutgoingMessage(m.RespondToChannelOrThread(a.Config.AppID, strings.Join(lines, "\n"), true, false))
+}
+
+// metadataLines formats item metadata one key per line, sorted by key.
+func metadataLines(metadata map[string]string) []string {
+	keys := make([]string, 0, len(metadata))
+	for k := range metadata {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	lines := make([]string, 0, len(keys))
+	for _, k := range keys {
+		lines = append(lines, fmt.Sprintf("%s: %s", strings.ReplaceAll(k, "_", " "), metadata[k]))
+	}
+	return lines
 }
@@ -0,0 +1,213 @@
+package app
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+)
+
+/*
+Job Boards
+
+Every user has a board of jobs to pick from, and a board is good for one day: the user's day, in their time zone (see
+app/timezones.go). The first time they look at it on a new day, yesterday's board is thrown out and they get a new one,
+whatever was left on it. Nothing has to run at midnight for that; the board just knows which day it's for.
+
+Taking a job doesn't take it off the board. The board marks it as taken, so it can't be taken again that day and the
+numbers of the other jobs stay the same.
+
+A user who doesn't like their board can reroll it with !jobs refresh. The first Config.Economy.JobFreeRerolls of the
+day are free, every one after that costs JobRerollCost, and after JobMaxRerolls there are no more until tomorrow.
+
+Moving to a time zone further east can start the user's next day early, but never more than once: a board is only
+replaced by one for a later day.
+
+The board is kept on the user's profile, so taking a job, marking it taken and paying for a reroll all happen in the
+same updateProfile. Two commands at once can't take the same job twice or sneak past the reroll limit. Boards used to
+be kept under "jobs:<user_id>" in Redis. Those are yesterday's boards by now and are never read again.
+*/
+
+var (
+	// ErrBoardReplaced is returned when the user's board changed between reading it and acting on it,
+	// because the day turned over or they rerolled it.
+	ErrBoardReplaced = errors.New("job board was replaced")
+
+	// ErrJobTaken is returned for a job the user already took from today's board.
+	ErrJobTaken = errors.New("job already taken today")
+
+	// ErrJobClosed is returned for a job whose offer has expired.
+	ErrJobClosed = errors.New("job offer is closed")
+
+	// ErrNoRerolls is returned when the user has rerolled their board as often as they can today.
+	ErrNoRerolls = errors.New("no rerolls left today")
+
+	// ErrRerollLocked is returned when a reroll costs money and the user's record doesn't let them spend any.
+	ErrRerollLocked = errors.New("locked out of paid rerolls")
+)
+
+// JobBoard is the jobs a user can take on a given day.
+type JobBoard struct {
+	Day     string      // The day the board is for, in the user's time zone. Empty for a board that was never set up.
+	Rerolls int         // How many times the user rerolled it that day
+	Taken   []uuid.UUID // The jobs the user took from it that day, rerolled ones included
+	Jobs    []Job       // The jobs on it, in the order they're numbered
+}
+
+// jobBoardJSON is how a JobBoard is stored. Jobs can be of any type, so they go through AnyJob.
+type jobBoardJSON struct {
+	Day     string      `json:"day"`
+	Rerolls int         `json:"rerolls"`
+	Taken   []uuid.UUID `json:"taken"`
+	Jobs    []AnyJob    `json:"jobs"`
+}
+
+// MarshalJSON stores the board with the type of every job.
+func (b *JobBoard) MarshalJSON() ([]byte, error) {
+	return json.Marshal(jobBoardJSON{
+		Day:     b.Day,
+		Rerolls: b.Rerolls,
+		Taken:   b.Taken,
+		Jobs:    anyJobs(b.Jobs),
+	})
+}
+
+// UnmarshalJSON loads a stored board. Boards from before they had days were just the list of jobs.
+// Those come back without a day, so they're replaced the next time they're looked at.
+func (b *JobBoard) UnmarshalJSON(data []byte) error {
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte("[")) {
+		var jobs []AnyJob
+		if err := json.Unmarshal(data, &jobs); err != nil {
+			return err
+		}
+		*b = JobBoard{Jobs: unwrapJobs(jobs)}
+		return nil
+	}
+
+	var stored jobBoardJSON
+	if err := json.Unmarshal(data, &stored); err != nil {
+		return err
+	}
+	*b = JobBoard{
+		Day:     stored.Day,
+		Rerolls: stored.Rerolls,
+		Taken:   stored.Taken,
+		Jobs:    unwrapJobs(stored.Jobs),
+	}
+	return nil
+}
+
+// taken reports whether the user took the job with the given ID from the board today.
+func (b *JobBoard) taken(id uuid.UUID) bool {
+	for _, taken := range b.Taken {
+		if uuid.Equal(taken, id) {
+			return true
+		}
+	}
+	return false
+}
+
+// open returns how many jobs on the board can still be taken.
+func (b *JobBoard) open(now time.Time) int {
+	open := 0
+	for _, job := range b.Jobs {
+		if job.Info().open(now) && !b.taken(job.Info().ID) {
+			open++
+		}
+	}
+	return open
+}
+
+// replacedAt returns when the board makes way for the next day's, in the user's time zone loc.
+func (b *JobBoard) replacedAt(loc *time.Location) time.Time {
+	day, err := time.ParseInLocation(dayLayout, b.Day, loc)
+	if err != nil {
+		return nextDay(time.Now(), loc)
+	}
+	return day.AddDate(0, 0, 1)
+}
+
+// rerollCost returns what rerolling the board costs. It's only worth asking while there are rerolls left.
+func (b *JobBoard) rerollCost(e EconomyConfig) int {
+	if b.Rerolls < e.JobFreeRerolls {
+		return 0
+	}
+	return e.JobRerollCost
+}
+
+// rerollsLeft returns how many more times the board can be rerolled today.
+func (b *JobBoard) rerollsLeft(e EconomyConfig) int {
+	if b.Rerolls >= e.JobMaxRerolls {
+		return 0
+	}
+	return e.JobMaxRerolls - b.Rerolls
+}
+
+// board returns the user's job board, or an empty one with no day if they've never had one.
+func (p *Profile) board() *JobBoard {
+	if p.JobBoard == nil {
+		return &JobBoard{}
+	}
+	return p.JobBoard
+}
+
+// expire marks the offers on the board that are past their expiry as expired. It doesn't save the board.
+func (b *JobBoard) expire(now time.Time) error {
+	for _, job := range b.Jobs {
+		j := job.Info()
+		if j.State == JOB_OFFERED && !j.open(now) {
+			if err := j.transition(JOB_EXPIRED, now); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// jobBoard gets the user's profile with their board for today. If the one they have is for an earlier day, or they don't
+// have one, they get a new one, made of the content pack of the server they're in, and fresh is true.
+// Offers past their expiry come back expired.
+func (a *App) jobBoard(userID, guildID string) (profile *Profile, board *JobBoard, fresh bool, err error) {
+	profile, err = a.getProfile(userID)
+	if err != nil {
+		return nil, nil, false, err
+	}
+
+	now := time.Now()
+	if today := dayOf(now, profile.location(a.Config.Economy)); today > profile.board().Day {
+		content, err := a.contentPack(guildID)
+		if err != nil {
+			return nil, nil, false, err
+		}
+		jobs, err := a.generateJobs(profile, content, a.Config.Economy.JobBoardSize)
+		if err != nil {
+			return nil, nil, false, err
+		}
+
+		// Another command might have beaten us to it
+		profile, err = a.updateProfile(userID, func(p *Profile) error {
+			fresh = today > p.board().Day
+			if fresh {
+				p.JobBoard = &JobBoard{Day: today, Jobs: jobs}
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, nil, false, err
+		}
+		if fresh {
+			a.logger.Info().
+				Str("user", userID).
+				Str("day", today).
+				Msg("New job board for the day")
+		}
+	}
+
+	board = profile.board()
+	if err := board.expire(now); err != nil {
+		return nil, nil, false, err
+	}
+	return profile, board, fresh, nil
+}
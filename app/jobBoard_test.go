@@ -0,0 +1,53 @@
+package app
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	uuid "github.com/satori/go.uuid"
+)
+
+func TestJobBoardUnmarshal(t *testing.T) {
+	taken := uuid.NewV4()
+	tests := []struct {
+		name   string
+		stored string
+		want   JobBoard
+	}{
+		{
+			name:   "legacy list of jobs",
+			stored: `[{"name": "Old job"}, {"type": "combat", "enemy": "a rat"}]`,
+			want:   JobBoard{Jobs: []Job{&TimedJob{JobInfo{Name: "Old job"}}, &CombatJob{Enemy: "a rat"}}},
+		},
+		{
+			name:   "board",
+			stored: `{"day": "2022-10-17", "rerolls": 1, "taken": ["` + taken.String() + `"], "jobs": [{"type": "timed", "name": "Job"}]}`,
+			want:   JobBoard{Day: "2022-10-17", Rerolls: 1, Taken: []uuid.UUID{taken}, Jobs: []Job{&TimedJob{JobInfo{Name: "Job"}}}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var board JobBoard
+			if err := json.Unmarshal([]byte(tt.stored), &board); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(board, tt.want) {
+				t.Errorf("loaded %#v, want %#v", board, tt.want)
+			}
+
+			// Whatever it was stored as, it's stored as a board from now on
+			data, err := json.Marshal(&board)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var reloaded JobBoard
+			if err := json.Unmarshal(data, &reloaded); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(reloaded, tt.want) {
+				t.Errorf("reloaded %#v, want %#v", reloaded, tt.want)
+			}
+		})
+	}
+}
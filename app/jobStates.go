@@ -0,0 +1,146 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+/*
+Job States
+
+Every job is in exactly one state, and only moves between them along these lines:
+
+	offered --take--> active --> succeeded
+	   |                 |-----> failed
+	   v                 '-----> abandoned
+	expired
+
+A job is offered when it's put on a board. Offers don't last forever: one nobody takes by OfferExpiresAt expires.
+That's a different clock from how long the job takes to do, which only starts ticking when the job is taken.
+Once the work time is up the job succeeds or, with a chance set by its Risk, fails. Quitting abandons it.
+
+Anything else is a bug or a race, like a completion task running after the job was quit, and transition refuses it.
+
+Every way a job can end has its own outcome, fixed when the job is offered so nobody is surprised:
+succeeding pays Payout, failing costs FailPenalty plus a demerit if the job was risky, abandoning costs QuitFee
+plus a demerit if the config says so, and an expired offer costs nothing. Jobs that succeed or fail are also worth
+some XP (see app/levels.go). Walking out on one teaches nobody anything.
+*/
+
+// JobState is where a job is in its life.
+type JobState string
+
+const (
+	JOB_OFFERED   JobState = "offered"   // On a board, waiting to be taken
+	JOB_ACTIVE    JobState = "active"    // Taken and being worked on
+	JOB_SUCCEEDED JobState = "succeeded" // Done and paid
+	JOB_FAILED    JobState = "failed"    // Done, but it went wrong
+	JOB_ABANDONED JobState = "abandoned" // Quit before it was done
+	JOB_EXPIRED   JobState = "expired"   // Nobody took the offer in time
+)
+
+// jobTransitions lists the states each state can move to. States that aren't keys are final.
+var jobTransitions = map[JobState][]JobState{
+	JOB_OFFERED: {JOB_ACTIVE, JOB_EXPIRED},
+	JOB_ACTIVE:  {JOB_SUCCEEDED, JOB_FAILED, JOB_ABANDONED},
+}
+
+// ErrInvalidJobTransition is returned when a job is asked to move to a state it can't get to from where it is.
+var ErrInvalidJobTransition = errors.New("invalid job state transition")
+
+// JobOutcome is what ending a job did to the user.
+type JobOutcome struct {
+	State   JobState // How the job ended
+	Payout  int      // What the user was paid
+	Penalty int      // What the user was charged
+	Demerit bool     // Whether the user got a demerit
+	Jailed  bool     // Whether that demerit put them in jail
+	XP      int      // What the user learned from it
+	Levels  int      // How many levels that XP got them
+}
+
+// canTransition reports whether the job can move from its current state to the given one.
+func (j *JobInfo) canTransition(to JobState) bool {
+	for _, next := range jobTransitions[j.State] {
+		if next == to {
+			return true
+		}
+	}
+	return false
+}
+
+// transition moves the job to another state at now and keeps its timestamps in step.
+func (j *JobInfo) transition(to JobState, now time.Time) error {
+	if !j.canTransition(to) {
+		return fmt.Errorf("%w: %s to %s", ErrInvalidJobTransition, j.State, to)
+	}
+
+	switch to {
+	case JOB_ACTIVE:
+		j.StartedAt = now.Unix()
+		j.DueAt = now.Unix() + j.WorkTime
+	case JOB_SUCCEEDED, JOB_FAILED, JOB_ABANDONED, JOB_EXPIRED:
+		j.FinishedAt = now.Unix()
+	}
+	j.State = to
+	return nil
+}
+
+// active reports whether the job is being worked on.
+func (j *JobInfo) active() bool {
+	return j.State == JOB_ACTIVE
+}
+
+// open reports whether the job is on offer and can still be taken at now.
+func (j *JobInfo) open(now time.Time) bool {
+	return j.State == JOB_OFFERED && now.Unix() < j.OfferExpiresAt
+}
+
+// finishJob ends the user's active job in the given state and settles its outcome.
+// Like every other profile change it belongs inside updateProfile.
+func (p *Profile) finishJob(e EconomyConfig, to JobState) (JobOutcome, error) {
+	j := p.ActiveJob.Info()
+	if err := j.transition(to, time.Now()); err != nil {
+		return JobOutcome{}, err
+	}
+
+	outcome := JobOutcome{State: to}
+	ref := j.ID.String()
+	switch to {
+	case JOB_SUCCEEDED:
+		outcome.Payout = p.cutPayout(e, j.Payout)
+		j.Paid = outcome.Payout
+		p.adjustBalance(outcome.Payout, LEDGER_JOB, ref)
+		p.JobsCompleted++
+		outcome.XP = j.Payout * e.XPPerJobPercent / 100
+	case JOB_FAILED:
+		outcome.Penalty = p.charge(j.FailPenalty, LEDGER_JOB_FAILED, ref)
+		outcome.XP = e.XPPerFailedJob
+		if j.Risk >= e.JobRiskyAt {
+			outcome.Demerit = true
+			outcome.Jailed = p.addDemerit(e, DEMERIT_FAILED_JOB, fmt.Sprintf("botched '%s'", j.Name), "")
+		}
+	case JOB_ABANDONED:
+		outcome.Penalty = p.charge(j.QuitFee, LEDGER_JOB_QUIT, ref)
+		if e.JobQuitDemerit {
+			outcome.Demerit = true
+			outcome.Jailed = p.addDemerit(e, DEMERIT_QUIT_JOB, fmt.Sprintf("walked out on '%s'", j.Name), "")
+		}
+	}
+	p.ActiveJob.settle(p, to)
+	outcome.Levels = p.gainXP(e, outcome.XP)
+	return outcome, nil
+}
+
+// charge takes a penalty out of the balance, but nobody goes into debt over one. It returns what was actually taken.
+func (p *Profile) charge(amount int, reason, ref string) int {
+	if amount > p.Balance {
+		amount = p.Balance
+	}
+	if amount <= 0 {
+		return 0
+	}
+	p.adjustBalance(-amount, reason, ref)
+	return amount
+}
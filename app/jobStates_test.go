@@ -0,0 +1,281 @@
+package app
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+)
+
+func TestJobTransition(t *testing.T) {
+	states := []JobState{JOB_OFFERED, JOB_ACTIVE, JOB_SUCCEEDED, JOB_FAILED, JOB_ABANDONED, JOB_EXPIRED}
+	allowed := map[JobState]map[JobState]bool{
+		JOB_OFFERED: {JOB_ACTIVE: true, JOB_EXPIRED: true},
+		JOB_ACTIVE:  {JOB_SUCCEEDED: true, JOB_FAILED: true, JOB_ABANDONED: true},
+	}
+	now := time.Unix(1000, 0)
+	for _, from := range states {
+		for _, to := range states {
+			t.Run(string(from)+" to "+string(to), func(t *testing.T) {
+				j := &JobInfo{State: from, WorkTime: 600}
+				if got := j.canTransition(to); got != allowed[from][to] {
+					t.Fatalf("canTransition = %v, want %v", got, allowed[from][to])
+				}
+
+				err := j.transition(to, now)
+				if !allowed[from][to] {
+					if !errors.Is(err, ErrInvalidJobTransition) || j.State != from {
+						t.Errorf("transition = %v and the job is %s, want it refused", err, j.State)
+					}
+					return
+				}
+				if err != nil || j.State != to {
+					t.Fatalf("transition = %v and the job is %s, want it %s", err, j.State, to)
+				}
+				if to == JOB_ACTIVE && (j.StartedAt != 1000 || j.DueAt != 1600) {
+					t.Errorf("started at %d and due at %d, want 1000 and 1600", j.StartedAt, j.DueAt)
+				}
+				if to != JOB_ACTIVE && j.FinishedAt != 1000 {
+					t.Errorf("finished at %d, want 1000", j.FinishedAt)
+				}
+			})
+		}
+	}
+}
+
+func TestFinishJob(t *testing.T) {
+	e := DefaultConfig().Economy
+	tests := []struct {
+		name     string
+		balance  int
+		risk     int
+		demerits int // Active demerits before the job ends
+		to       JobState
+		want     JobOutcome
+		after    int // Balance afterwards
+	}{
+		{
+			name:    "succeeded",
+			balance: 10,
+			to:      JOB_SUCCEEDED,
+			want:    JobOutcome{State: JOB_SUCCEEDED, Payout: 100, XP: 100 * e.XPPerJobPercent / 100},
+			after:   110,
+		},
+		{
+			name:     "succeeded on probation",
+			balance:  10,
+			demerits: e.DemeritCutAt,
+			to:       JOB_SUCCEEDED,
+			want:     JobOutcome{State: JOB_SUCCEEDED, Payout: 100 * (100 - e.DemeritCutPercent) / 100, XP: 100 * e.XPPerJobPercent / 100},
+			after:    10 + 100*(100-e.DemeritCutPercent)/100,
+		},
+		{
+			name:    "failed safe job",
+			balance: 100,
+			to:      JOB_FAILED,
+			want:    JobOutcome{State: JOB_FAILED, Penalty: 30, XP: e.XPPerFailedJob},
+			after:   70,
+		},
+		{
+			name:    "failed risky job",
+			balance: 100,
+			risk:    e.JobRiskyAt,
+			to:      JOB_FAILED,
+			want:    JobOutcome{State: JOB_FAILED, Penalty: 30, Demerit: true, XP: e.XPPerFailedJob},
+			after:   70,
+		},
+		{
+			name:    "failed without the money for the penalty",
+			balance: 10,
+			to:      JOB_FAILED,
+			want:    JobOutcome{State: JOB_FAILED, Penalty: 10, XP: e.XPPerFailedJob},
+			after:   0,
+		},
+		{
+			name:    "abandoned",
+			balance: 100,
+			to:      JOB_ABANDONED,
+			want:    JobOutcome{State: JOB_ABANDONED, Penalty: 20, Demerit: e.JobQuitDemerit},
+			after:   80,
+		},
+		{
+			name:     "abandoned into jail",
+			balance:  100,
+			demerits: e.DemeritJailAt - 1,
+			to:       JOB_ABANDONED,
+			want:     JobOutcome{State: JOB_ABANDONED, Penalty: 20, Demerit: true, Jailed: true},
+			after:    80,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newProfile("1")
+			p.adjustBalance(tt.balance, LEDGER_WORK, "")
+			for i := 0; i < tt.demerits; i++ {
+				p.addDemerit(e, DEMERIT_LEGACY, "", "")
+			}
+			p.ActiveJob = AnyJob{&TimedJob{JobInfo{
+				ID:          uuid.NewV4(),
+				State:       JOB_ACTIVE,
+				Payout:      100,
+				Risk:        tt.risk,
+				FailPenalty: 30,
+				QuitFee:     20,
+			}}}
+			p.ledger = nil
+
+			outcome, err := p.finishJob(e, tt.to)
+			if err != nil {
+				t.Fatal(err)
+			}
+			outcome.Levels = 0
+			if outcome != tt.want {
+				t.Errorf("outcome %+v, want %+v", outcome, tt.want)
+			}
+			if p.Balance != tt.after {
+				t.Errorf("balance %d, want %d", p.Balance, tt.after)
+			}
+			if j := p.ActiveJob.Info(); j.State != tt.to || j.Paid != tt.want.Payout {
+				t.Errorf("job is %s and paid %d, want %s and %d", j.State, j.Paid, tt.to, tt.want.Payout)
+			}
+			if tt.want.Demerit != (len(p.Demerits) > tt.demerits) {
+				t.Errorf("%d demerits from %d, want a demerit: %v", len(p.Demerits), tt.demerits, tt.want.Demerit)
+			}
+			if moved := tt.after - tt.balance; moved != 0 && (len(p.ledger) != 1 || p.ledger[0].Delta != moved) {
+				t.Errorf("ledger %+v, want one entry for %d", p.ledger, moved)
+			}
+		})
+	}
+}
+
+func TestFinishJobTwice(t *testing.T) {
+	p := newProfile("1")
+	p.ActiveJob = AnyJob{&TimedJob{JobInfo{ID: uuid.NewV4(), State: JOB_ACTIVE, Payout: 100}}}
+	if _, err := p.finishJob(DefaultConfig().Economy, JOB_SUCCEEDED); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.finishJob(DefaultConfig().Economy, JOB_SUCCEEDED); !errors.Is(err, ErrInvalidJobTransition) {
+		t.Errorf("second finish = %v, want %v", err, ErrInvalidJobTransition)
+	}
+	if p.Balance != 100 {
+		t.Errorf("balance %d, want it paid once", p.Balance)
+	}
+}
+
+func TestAbandonedDeliveryReturnsItem(t *testing.T) {
+	var item *Item
+	for _, candidate := range catalog.Items {
+		if !candidate.Instanced {
+			item = candidate
+			break
+		}
+	}
+	if item == nil {
+		t.Skip("the catalog has nothing to deliver")
+	}
+
+	p := newProfile("1")
+	p.Inventory.addItem(item, 1, nil)
+	job := &DeliveryJob{JobInfo: JobInfo{ID: uuid.NewV4(), State: JOB_OFFERED}, ItemID: item.ID}
+	if err := job.take(p); err != nil {
+		t.Fatal(err)
+	}
+	if err := job.transition(JOB_ACTIVE, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	p.ActiveJob = AnyJob{job}
+	if p.Inventory.quantity(item.ID) != 0 {
+		t.Fatal("taking the delivery didn't hand the item over")
+	}
+
+	if _, err := p.finishJob(DefaultConfig().Economy, JOB_ABANDONED); err != nil {
+		t.Fatal(err)
+	}
+	if p.Inventory.quantity(item.ID) != 1 {
+		t.Error("walking out on the delivery didn't give the item back")
+	}
+}
+
+func TestJobCompleteTask(t *testing.T) {
+	tests := []struct {
+		name  string
+		risk  int
+		state JobState
+	}{
+		{"no risk", 0, JOB_SUCCEEDED},
+		{"all risk", 100, JOB_FAILED},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, transport := newTestApp(t)
+			setBalance(t, a, "1", 50)
+			job := &TimedJob{JobInfo{ID: uuid.NewV4(), State: JOB_OFFERED, Name: "Job", Payout: 100, Risk: tt.risk, FailPenalty: 30, WorkTime: 60, Gateway: testGateway, ChannelID: testChannel}}
+			if err := job.transition(JOB_ACTIVE, time.Now()); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := a.updateProfile("1", func(p *Profile) error {
+				p.ActiveJob = AnyJob{job}
+				return nil
+			}); err != nil {
+				t.Fatal(err)
+			}
+
+			payload, err := json.Marshal(jobCompleteTask{UserID: "1", JobID: job.ID})
+			if err != nil {
+				t.Fatal(err)
+			}
+			task := &Task{ID: job.ID.String(), Kind: TASK_JOB_COMPLETE, Payload: payload}
+
+			// Tasks can run more than once, but the job only ends once
+			for i := 0; i < 2; i++ {
+				if err := handleJobCompleteTask(a, task); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			p, err := a.findProfile("1")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if state := p.ActiveJob.Info().State; state != tt.state {
+				t.Errorf("job %s, want %s", state, tt.state)
+			}
+			want := map[JobState]int{JOB_SUCCEEDED: 150, JOB_FAILED: 20}[tt.state]
+			if p.Balance != want {
+				t.Errorf("balance %d, want %d", p.Balance, want)
+			}
+			if replies := transport.replies(); len(replies) != 1 {
+				t.Errorf("announced %q, want the outcome once", replies)
+			}
+		})
+	}
+}
+
+func TestJobCompleteTaskForAnotherJob(t *testing.T) {
+	a, _ := newTestApp(t)
+	job := &TimedJob{JobInfo{ID: uuid.NewV4(), State: JOB_ACTIVE, Payout: 100}}
+	if _, err := a.updateProfile("1", func(p *Profile) error {
+		p.ActiveJob = AnyJob{job}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	// A task left over from a job the user quit must not end the one they took after it
+	payload, err := json.Marshal(jobCompleteTask{UserID: "1", JobID: uuid.NewV4()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := handleJobCompleteTask(a, &Task{Kind: TASK_JOB_COMPLETE, Payload: payload}); err != nil {
+		t.Fatal(err)
+	}
+	p, err := a.findProfile("1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !p.ActiveJob.Info().active() || p.Balance != 0 {
+		t.Errorf("job %s and balance %d, want the job still active and nothing paid", p.ActiveJob.Info().State, p.Balance)
+	}
+}
@@ -0,0 +1,367 @@
+package app
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"strings"
+)
+
+/*
+Job Types
+
+Jobs come in types, and every type brings its own idea of how long the job takes, what it pays and how it ends:
+
+  - timed jobs are the plain kind. Wait out the clock and you're paid, unless it goes wrong, with a chance set by its risk.
+  - delivery jobs need an item from the shop, which is handed over when the job is taken. Delivered, it pays for itself
+    on top of the fee. Lost along the way, it's gone. Walk out on the job and you get it back.
+  - combat jobs are quick, pay well and end in a fight. The higher your level, the better your odds.
+
+Job is the interface every type implements. What all jobs have in common, like their state and payout, is in JobInfo,
+which every type embeds, so the rest of the job system mostly deals in JobInfo and only asks the type when it matters.
+
+Types are registered by name from init, like commands. A job written to JSON is its own fields plus a "type" field
+with that name, and reading it back goes through the registry to get the same type of job, so profiles and boards
+can hold any mix of them. Always store jobs as AnyJob, which does this. Jobs from before there were types have no
+"type" field, and they were all timed jobs anyway.
+*/
+
+// Job types
+const (
+	JOB_TYPE_TIMED    = "timed"
+	JOB_TYPE_DELIVERY = "delivery"
+	JOB_TYPE_COMBAT   = "combat"
+)
+
+// Job is a job of any type.
+type Job interface {
+	// Info returns what the job has in common with every other job.
+	Info() *JobInfo
+	// Type returns the name the job's type is registered under.
+	Type() string
+
+	// roll fills in what's particular to a newly offered job for a user at the given level: its name, description,
+	// risk and whatever else the type has. Names and flavor text come from the content pack, see app/contentPacks.go.
+	roll(c *ContentPack, e EconomyConfig, level int)
+	// workTime returns how long the job takes once it's taken, in seconds.
+	workTime(e EconomyConfig, level int, tier jobTier) int64
+	// payout returns what the job pays.
+	payout(e EconomyConfig, level int, tier jobTier) int
+
+	// requirements describes what it takes to take the job, or returns an empty string if it's open to anybody.
+	requirements() string
+	// take is called inside updateProfile when the user takes the job. It returns ErrJobRequirements if they can't.
+	take(p *Profile) error
+	// outcome decides how the job ends once its work time is up. roll is a number from 0 to 99, rolled once per job.
+	outcome(e EconomyConfig, p *Profile, roll int) JobState
+	// settle is called inside updateProfile after the job has ended in to and the usual outcome is settled,
+	// for whatever else the type does when it ends that way.
+	settle(p *Profile, to JobState)
+}
+
+// ErrJobRequirements is returned when a user tries to take a job they don't meet the requirements for.
+var ErrJobRequirements = errors.New("job requirements not met")
+
+// jobTypeInfo is a registered type of job.
+type jobTypeInfo struct {
+	Name   string     // What the type is called in JSON
+	Weight int        // How often the type is offered compared to the others
+	new    func() Job // Returns an empty job of the type
+}
+
+// jobTypes is every type of job, in the order they were registered.
+var jobTypes = []*jobTypeInfo{}
+
+// registerJobType adds a type of job. It panics if the name is taken, since that's a bug.
+func registerJobType(name string, weight int, new func() Job) {
+	if jobTypeNamed(name) != nil {
+		panic(fmt.Sprintf("job type %q registered twice", name))
+	}
+	jobTypes = append(jobTypes, &jobTypeInfo{Name: name, Weight: weight, new: new})
+}
+
+// jobTypeNamed returns the job type with the given name, or nil if there isn't one.
+func jobTypeNamed(name string) *jobTypeInfo {
+	for _, t := range jobTypes {
+		if t.Name == name {
+			return t
+		}
+	}
+	return nil
+}
+
+// randomJobType picks a job type, each as often as its weight says.
+func randomJobType() *jobTypeInfo {
+	total := 0
+	for _, t := range jobTypes {
+		total += t.Weight
+	}
+	pick := rand.Intn(total) // nolint:gosec // This is not a security issue
+	for _, t := range jobTypes {
+		if pick < t.Weight {
+			return t
+		}
+		pick -= t.Weight
+	}
+	return jobTypes[0]
+}
+
+// AnyJob holds a job of any type, or none. It's how jobs are stored: it writes the job to JSON with its type
+// and reads it back as the same type.
+type AnyJob struct {
+	Job
+}
+
+// Info returns what the job has in common with every other job. With no job that's an empty JobInfo,
+// which isn't active or open, so callers don't have to check for nil first.
+func (j AnyJob) Info() *JobInfo {
+	if j.Job == nil {
+		return &JobInfo{}
+	}
+	return j.Job.Info()
+}
+
+// MarshalJSON writes the job's fields along with a "type" field naming its type.
+func (j AnyJob) MarshalJSON() ([]byte, error) {
+	if j.Job == nil {
+		return []byte("null"), nil
+	}
+	data, err := json.Marshal(j.Job)
+	if err != nil {
+		return nil, err
+	}
+	fields := map[string]json.RawMessage{}
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, err
+	}
+	fields["type"], err = json.Marshal(j.Job.Type())
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(fields)
+}
+
+// UnmarshalJSON reads a job written by MarshalJSON back as the type it was. Jobs without a type are timed jobs.
+func (j *AnyJob) UnmarshalJSON(data []byte) error {
+	if string(data) == "null" {
+		j.Job = nil
+		return nil
+	}
+	var discriminator struct {
+		Type string `json:"type"`
+	}
+	if err := json.Unmarshal(data, &discriminator); err != nil {
+		return err
+	}
+	if discriminator.Type == "" {
+		discriminator.Type = JOB_TYPE_TIMED
+	}
+	t := jobTypeNamed(discriminator.Type)
+	if t == nil {
+		return fmt.Errorf("unknown job type %q", discriminator.Type)
+	}
+	job := t.new()
+	if err := json.Unmarshal(data, job); err != nil {
+		return err
+	}
+	j.Job = job
+	return nil
+}
+
+// anyJobs wraps jobs for storing.
+func anyJobs(jobs []Job) []AnyJob {
+	wrapped := make([]AnyJob, len(jobs))
+	for i, job := range jobs {
+		wrapped[i] = AnyJob{job}
+	}
+	return wrapped
+}
+
+// unwrapJobs unwraps stored jobs.
+func unwrapJobs(wrapped []AnyJob) []Job {
+	jobs := make([]Job, len(wrapped))
+	for i, job := range wrapped {
+		jobs[i] = job.Job
+	}
+	return jobs
+}
+
+// cloneJob returns a copy of the job that shares nothing with it.
+func cloneJob(job Job) (Job, error) {
+	data, err := json.Marshal(AnyJob{job})
+	if err != nil {
+		return nil, err
+	}
+	var clone AnyJob
+	if err := json.Unmarshal(data, &clone); err != nil {
+		return nil, err
+	}
+	return clone.Job, nil
+}
+
+// baseWorkTime returns how long a plain job takes at the given level and tier, in seconds.
+func baseWorkTime(e EconomyConfig, level int, tier jobTier) int64 {
+	duration := randBetween(int(e.JobMinDuration.Seconds()), int(e.JobMaxDuration.Seconds()))
+	return int64(levelScale(duration, e.JobLevelDurationPercent, level) * tier.DurationPercent / 100)
+}
+
+// basePayout returns what a plain job pays at the given level and tier.
+func basePayout(e EconomyConfig, level int, tier jobTier) int {
+	payout := randBetween(e.JobMinPayout, e.JobMaxPayout)
+	return levelScale(payout, e.JobLevelPayoutPercent, level) * tier.PayoutPercent / 100
+}
+
+func init() {
+	registerJobType(JOB_TYPE_TIMED, 3, func() Job { return &TimedJob{} })
+	registerJobType(JOB_TYPE_DELIVERY, 1, func() Job { return &DeliveryJob{} })
+	registerJobType(JOB_TYPE_COMBAT, 1, func() Job { return &CombatJob{} })
+}
+
+// TimedJob is a job that's done when its time is up. It fails with a chance of its risk.
+type TimedJob struct {
+	JobInfo
+}
+
+func (j *TimedJob) Type() string { return JOB_TYPE_TIMED }
+
+func (j *TimedJob) roll(c *ContentPack, e EconomyConfig, level int) {
+	j.Name, j.Description = c.jobNameAndDescription()
+	j.Risk = randBetween(0, e.JobMaxRisk)
+}
+
+func (j *TimedJob) workTime(e EconomyConfig, level int, tier jobTier) int64 {
+	return baseWorkTime(e, level, tier)
+}
+
+func (j *TimedJob) payout(e EconomyConfig, level int, tier jobTier) int {
+	return basePayout(e, level, tier)
+}
+
+func (j *TimedJob) requirements() string { return "" }
+
+func (j *TimedJob) take(p *Profile) error { return nil }
+
+func (j *TimedJob) outcome(e EconomyConfig, p *Profile, roll int) JobState {
+	if roll < j.Risk {
+		return JOB_FAILED
+	}
+	return JOB_SUCCEEDED
+}
+
+func (j *TimedJob) settle(p *Profile, to JobState) {}
+
+// DeliveryJob is a job taking an item somewhere. The user hands the item over when they take the job.
+type DeliveryJob struct {
+	JobInfo
+	ItemID      string `json:"item_id"`     // What's being delivered
+	Destination string `json:"destination"` // Where it's going
+}
+
+func (j *DeliveryJob) Type() string { return JOB_TYPE_DELIVERY }
+
+// roll picks something from the shop that comes in stacks. loadCatalog makes sure there is something.
+// Deliveries are safer than most jobs.
+func (j *DeliveryJob) roll(c *ContentPack, e EconomyConfig, level int) {
+	items := catalog.deliverable()
+	item := items[rand.Intn(len(items))] // nolint:gosec // This is not a security issue
+	j.ItemID = item.ID
+	j.Destination = pick(c.DeliveryDestinations)
+	j.Name = fmt.Sprintf("Deliver %s to %s", item.Name, j.Destination)
+	j.Description = strings.NewReplacer("{item}", item.Name, "{destination}", j.Destination).Replace(pick(c.DeliveryDescriptions))
+	j.Risk = randBetween(0, e.JobMaxRisk/2)
+}
+
+// workTime is half a plain job's. It's just a walk.
+func (j *DeliveryJob) workTime(e EconomyConfig, level int, tier jobTier) int64 {
+	return baseWorkTime(e, level, tier) / 2
+}
+
+// payout covers the item on top of a plain job's.
+func (j *DeliveryJob) payout(e EconomyConfig, level int, tier jobTier) int {
+	payout := basePayout(e, level, tier)
+	if item := catalog.item(j.ItemID); item != nil {
+		payout += item.Price
+	}
+	return payout
+}
+
+func (j *DeliveryJob) requirements() string {
+	return "1 " + itemName(j.ItemID)
+}
+
+func (j *DeliveryJob) take(p *Profile) error {
+	if !p.Inventory.removeItem(j.ItemID, 1) {
+		return ErrJobRequirements
+	}
+	return nil
+}
+
+func (j *DeliveryJob) outcome(e EconomyConfig, p *Profile, roll int) JobState {
+	if roll < j.Risk {
+		return JOB_FAILED
+	}
+	return JOB_SUCCEEDED
+}
+
+// settle gives the item back to users who walk out on the delivery. Items taken out of the catalog since are lost.
+func (j *DeliveryJob) settle(p *Profile, to JobState) {
+	if to != JOB_ABANDONED {
+		return
+	}
+	if item := catalog.item(j.ItemID); item != nil {
+		p.Inventory.addItem(item, 1, map[string]string{"source": "abandoned delivery"})
+	}
+}
+
+// Combat odds
+const (
+	combatMinStrength = 10 // The weakest an enemy gets
+	combatMaxStrength = 50 // The strongest an enemy gets
+	combatLevelEdge   = 2  // How much better the odds get for every level above 1
+)
+
+// CombatJob is a fight. When it's over, the user rolls to see whether they won, with a bonus for their level.
+type CombatJob struct {
+	JobInfo
+	Enemy    string `json:"enemy"`    // Who the fight is against
+	Strength int    `json:"strength"` // How tough they are. The user wins if their roll plus their edge gets to it.
+}
+
+func (j *CombatJob) Type() string { return JOB_TYPE_COMBAT }
+
+// roll picks a fight. Its risk is the chance of losing at the level the user is now.
+func (j *CombatJob) roll(c *ContentPack, e EconomyConfig, level int) {
+	j.Enemy = pick(c.CombatEnemies)
+	j.Strength = randBetween(combatMinStrength, combatMaxStrength)
+	j.Name = "Fight " + j.Enemy
+	j.Description = strings.ReplaceAll(pick(c.CombatDescriptions), "{enemy}", j.Enemy)
+	j.Risk = j.Strength - combatLevelEdge*(level-1)
+	if j.Risk < 0 {
+		j.Risk = 0
+	}
+}
+
+// workTime is half a plain job's. Fights don't last.
+func (j *CombatJob) workTime(e EconomyConfig, level int, tier jobTier) int64 {
+	return baseWorkTime(e, level, tier) / 2
+}
+
+// payout is half again a plain job's, for the danger.
+func (j *CombatJob) payout(e EconomyConfig, level int, tier jobTier) int {
+	return basePayout(e, level, tier) * 3 / 2
+}
+
+func (j *CombatJob) requirements() string { return "" }
+
+func (j *CombatJob) take(p *Profile) error { return nil }
+
+// outcome is the success roll. Users who levelled up since taking the job fight at their new level.
+func (j *CombatJob) outcome(e EconomyConfig, p *Profile, roll int) JobState {
+	if roll+combatLevelEdge*(p.level(e)-1) >= j.Strength {
+		return JOB_SUCCEEDED
+	}
+	return JOB_FAILED
+}
+
+func (j *CombatJob) settle(p *Profile, to JobState) {}
@@ -0,0 +1,82 @@
+package app
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	uuid "github.com/satori/go.uuid"
+)
+
+func TestAnyJobRoundTrip(t *testing.T) {
+	info := JobInfo{ID: uuid.NewV4(), Name: "Job", State: JOB_OFFERED, Payout: 100, Risk: 10, WorkTime: 600}
+	jobs := []Job{
+		&TimedJob{JobInfo: info},
+		&DeliveryJob{JobInfo: info, ItemID: "rat-meat-pie", Destination: "the docks"},
+		&CombatJob{JobInfo: info, Enemy: "a rat", Strength: 20},
+	}
+	for _, job := range jobs {
+		t.Run(job.Type(), func(t *testing.T) {
+			data, err := json.Marshal(AnyJob{job})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var fields map[string]interface{}
+			if err := json.Unmarshal(data, &fields); err != nil {
+				t.Fatal(err)
+			}
+			if fields["type"] != job.Type() {
+				t.Errorf("stored with type %v, want %s", fields["type"], job.Type())
+			}
+
+			var loaded AnyJob
+			if err := json.Unmarshal(data, &loaded); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(loaded.Job, job) {
+				t.Errorf("loaded %#v, want %#v", loaded.Job, job)
+			}
+		})
+	}
+}
+
+func TestAnyJobUnmarshal(t *testing.T) {
+	tests := []struct {
+		name   string
+		stored string
+		want   Job // Nil for no job
+		ok     bool
+	}{
+		{"no job", `null`, nil, true},
+		{"untyped jobs are timed", `{"name": "Old job", "payout": 5}`, &TimedJob{JobInfo{Name: "Old job", Payout: 5}}, true},
+		{"typed", `{"type": "combat", "enemy": "a rat"}`, &CombatJob{Enemy: "a rat"}, true},
+		{"unknown type", `{"type": "heist"}`, nil, false},
+		{"not a job", `[1, 2]`, nil, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			loaded := AnyJob{&TimedJob{}}
+			err := json.Unmarshal([]byte(tt.stored), &loaded)
+			if (err == nil) != tt.ok {
+				t.Fatalf("error = %v, want ok %v", err, tt.ok)
+			}
+			if tt.ok && !reflect.DeepEqual(loaded.Job, tt.want) {
+				t.Errorf("loaded %#v, want %#v", loaded.Job, tt.want)
+			}
+		})
+	}
+}
+
+func TestAnyJobMarshalNoJob(t *testing.T) {
+	data, err := json.Marshal(AnyJob{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "null" {
+		t.Errorf("stored as %s, want null", data)
+	}
+	if info := (AnyJob{}).Info(); info.active() {
+		t.Error("no job is active")
+	}
+}
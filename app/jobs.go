@@ -2,191 +2,270 @@ package app
 
 import (
 	"encoding/json"
-	"fmt"
+	"errors"
 	"math/rand"
 	"time"
 
 	uuid "github.com/satori/go.uuid"
 )
 
-const JOBS_REDIS_KEY = "jobs"
-
-var QUEST_TITLE_VERBS = [...]string{
-	"Find",
-	"Collect",
-	"Deliver",
-	"Steal",
-	"Retrieve",
-	"Return",
-	"Destroy",
-	"Kill",
-	"Capture",
-	"Rescue",
-	"Escort",
-	"Protect",
-	"Defend",
-	"Assassinate",
-}
+// JobInfo is what every job has, whatever its type. Job types embed it, see app/jobTypes.go.
+type JobInfo struct {
+	ID          uuid.UUID `json:"id"`           // The ID of the job
+	Name        string    `json:"name"`         // The name of the job
+	Description string    `json:"description"`  // The description of the job
+	State       JobState  `json:"state"`        // Where the job is in its life. See app/jobStates.go
+	Payout      int       `json:"payout"`       // Amount of currency the user gets for completing the job
+	Risk        int       `json:"risk"`         // Percent chance the job fails
+	FailPenalty int       `json:"fail_penalty"` // Amount of currency the user is charged if the job fails
+	QuitFee     int       `json:"quit_fee"`     // Amount of currency the user is charged for quitting the job
+	Paid        int       `json:"paid"`         // What the user actually got for the job once demerits took their cut. Zero until it succeeds.
+	WorkTime    int64     `json:"work_time"`    // How long the job takes once it's taken, in seconds
+	Tier        int       `json:"tier"`         // How good a job it is, starting at 1. See jobTiers in app/levels.go
+
+	CreatedAt      int64 `json:"created_at"`       // The time the job was offered
+	OfferExpiresAt int64 `json:"offer_expires_at"` // The time the offer comes off the board if nobody takes it
+	StartedAt      int64 `json:"started_at"`       // The time the job was taken
+	DueAt          int64 `json:"due_at"`           // The time the work is done
+	FinishedAt     int64 `json:"finished_at"`      // The time the job ended, however it ended
+
+	// Where the job was taken, so the outcome can be announced there long after the message that took it is gone
+	Gateway   string `json:"gateway"`    // The gateway the job was taken through. Empty for jobs from before jobs remembered it.
+	GuildID   string `json:"guild_id"`   // The server the job was taken in. Empty for a DM.
+	ChannelID string `json:"channel_id"` // The channel or thread the job was taken in
 
-var QUEST_TITLE_NOUNS = [...]string{
-	"the airlock",
-	"the bridge",
-	"a stim pack",
-	"the captain",
-	"the captain's cat",
-	"your boss's space suit",
-	"a rat meat pie",
-	"the last iPod shuffle",
+	// What jobs looked like before they had states. Only the 5 -> 6 profile migration reads these.
+	LegacyExpiresAt int64 `json:"expires_at,omitempty"`
+	LegacyCompleted bool  `json:"completed,omitempty"`
 }
 
-// The Job struct is the base struct for all jobs
-// Eventually this should become an interface to allow for more complex jobs
-// But for now I don't want to figure out how to mess with the JSON unmarshaller to handle interfaces
-type Job struct {
-	ID          uuid.UUID `json:"id"`          // The ID of the job
-	Name        string    `json:"name"`        // The name of the job
-	Description string    `json:"description"` // The description of the job
-	Payout      int       `json:"payout"`      // Amount of currency the user gets for completing the job
-	CreatedAt   int64     `json:"created_at"`  // The time the job was created
-	ExpiresAt   int64     `json:"expires_at"`  // The time the job expires
-	Completed   bool      `json:"completed"`   // Whether or not the job has been completed
+// Info returns the job info itself, which makes it part of the Job interface for every type that embeds it.
+func (j *JobInfo) Info() *JobInfo {
+	return j
 }
 
-// work does the work for the given job
-// It sleeps for the duration of the job and then updates the user's balance with the payout before returning
-func (j *Job) work(a *App, userID string) error {
-	// Get the user's profile
-	profile, err := a.getProfile(userID)
-	if err != nil {
-		return err
-	}
+var (
+	// ErrJobActive is returned when a user tries to take a job while they're still working on one.
+	ErrJobActive = errors.New("already working on a job")
+
+	// ErrNoActiveJob is returned when a user tries to do something with their active job but doesn't have one.
+	ErrNoActiveJob = errors.New("no active job")
+)
 
-	// Calculate the duration and payout of the job before sleeping
-	duration := j.Duration()
-	payout := j.Payout
+// TASK_JOB_COMPLETE is the scheduler task kind that settles a job once its work time has passed.
+const TASK_JOB_COMPLETE = "job:complete"
 
-	// Sleep for the duration of the job
+// jobCompleteTask is the payload of a TASK_JOB_COMPLETE task.
+type jobCompleteTask struct {
+	UserID string    `json:"user_id"` // The user working the job
+	JobID  uuid.UUID `json:"job_id"`  // The job being worked
+}
+
+// scheduleCompletion schedules the end of the job for when it's due.
+// The job ID doubles as the task ID so the completion can be found again later.
+func (j *JobInfo) scheduleCompletion(a *App, userID string) error {
 	a.logger.Debug().
 		Str("user", userID).
 		Str("job", j.ID.String()).
-		Int("duration", duration).
-		Msg("Job started")
-
-	time.Sleep(time.Duration(duration) * time.Second)
+		Int64("work_time", j.WorkTime).
+		Msg("Scheduled job completion")
 
-	// Update the user's balance with the payout
-	profile.Balance += payout
-
-	// Set the job as completed
-	profile.ActiveJob.Completed = true
+	return a.scheduler.Schedule(j.ID.String(), TASK_JOB_COMPLETE, time.Unix(j.DueAt, 0), jobCompleteTask{
+		UserID: userID,
+		JobID:  j.ID,
+	})
+}
 
-	// Save the user's profile
-	err = profile.save(a)
+// recoverJobs schedules the completion of every active job that doesn't have one scheduled yet. That's the jobs
+// taken before there was a scheduler, whose goroutine died on a restart. Overdue ones are settled on the first tick.
+// Once they all have a task there's nothing left for it to do, so it only does something the first time it runs.
+func (a *App) recoverJobs() {
+	userIDs, err := a.store.ProfileIDs(a.context)
 	if err != nil {
 		a.logger.Error().
 			Err(err).
-			Str("user", userID).
-			Str("job", j.ID.String()).
-			Msg("Failed to save user profile after completing job")
-		return err
+			Msg("Failed to list profiles to recover jobs from")
+		return
 	}
 
-	// Log the job completion
-	a.logger.Info().
-		Str("user", userID).
-		Str("job", j.ID.String()).
-		Int("duration", duration).
-		Int("payout", payout).
-		Msg("Job completed")
+	recovered := 0
+	for _, userID := range userIDs {
+		ok, err := a.recoverJob(userID)
+		if err != nil {
+			a.logger.Error().
+				Err(err).
+				Str("user", userID).
+				Msg("Failed to recover job")
+			continue
+		}
+		if ok {
+			recovered++
+		}
+	}
 
-	return nil
+	a.logger.Info().
+		Int("profiles", len(userIDs)).
+		Int("recovered", recovered).
+		Msg("Looked for jobs without a completion task")
 }
 
-// saveAvailableJobs saves the list of available jobs to the database
-func (a *App) setAvailableJobs(p *Profile, jobs []Job) error {
-	// Marshal the jobs into a json string
-	jobsBytes, err := json.Marshal(jobs)
-	if err != nil {
-		return err
+// recoverJob schedules the completion of the user's active job if it doesn't have one scheduled.
+// It returns whether it had to.
+func (a *App) recoverJob(userID string) (bool, error) {
+	profile, err := a.findProfile(userID)
+	if err != nil || profile == nil {
+		return false, err
+	}
+	job := profile.ActiveJob.Info()
+	if !job.active() {
+		return false, nil
 	}
 
-	// Save the jobs to the database
-	err = a.redis.Set(a.context, JOBS_REDIS_KEY+":"+p.ID, jobsBytes, 0).Err()
-	if err != nil {
+	// The job ID doubles as the task ID
+	scheduled, err := a.store.HasTask(a.context, job.ID.String())
+	if err != nil || scheduled {
+		return false, err
+	}
+	if err := job.scheduleCompletion(a, userID); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// handleJobCompleteTask ends a job when its completion task comes due. The job succeeds, or fails with a chance of its Risk.
+// Tasks can run more than once, so it does nothing unless the job is still the user's active job.
+func handleJobCompleteTask(a *App, t *Task) error {
+	var payload jobCompleteTask
+	if err := json.Unmarshal(t.Payload, &payload); err != nil {
 		return err
 	}
 
-	return nil
-}
+	// Roll once up front, so a retried update doesn't get a second chance at success
+	roll := rand.Intn(100) // nolint:gosec // This is not a security issue
 
-// Duration returns the duration of the job in hours
-func (j *Job) Duration() int {
-	return int(j.ExpiresAt - j.CreatedAt)
-}
+	// Settle the job and its outcome in one go
+	settled := false
+	var outcome JobOutcome
+	profile, err := a.updateProfile(payload.UserID, func(profile *Profile) error {
+		settled = false
 
-// timeRemaining returns the time remaining for the job in seconds
-func (j *Job) timeRemaining() int {
-	return int(j.ExpiresAt - time.Now().Unix())
-}
+		// Make sure we're still looking at the same job and that it hasn't ended yet
+		job := profile.ActiveJob.Info()
+		if !uuid.Equal(job.ID, payload.JobID) || !job.active() {
+			return nil
+		}
 
-// getAvailableJobs gets the available jobs for the given user profile
-// if the user has no available jobs, it returns an empty list
-func (a *App) getAvailableJobs(p *Profile) ([]Job, error) {
-	// Get the list of available jobs from the database
-	j, err := a.redis.Get(a.context, JOBS_REDIS_KEY+":"+p.ID).Result()
+		// How it ends is up to the type of job
+		to := profile.ActiveJob.outcome(a.Config.Economy, profile, roll)
+		var err error
+		outcome, err = profile.finishJob(a.Config.Economy, to)
+		if err != nil {
+			return err
+		}
+		settled = true
+		return nil
+	})
 	if err != nil {
-		return []Job{}, nil
+		a.logger.Error().
+			Err(err).
+			Str("user", payload.UserID).
+			Str("job", payload.JobID.String()).
+			Msg("Failed to save user profile after completing job")
+		return err
 	}
 
-	// Unmarshal the list of jobs
-	var jobs []Job
-	err = json.Unmarshal([]byte(j), &jobs)
-	if err != nil {
-		return nil, err
+	if !settled {
+		a.logger.Debug().
+			Str("user", payload.UserID).
+			Str("job", payload.JobID.String()).
+			Msg("Job already settled, skipping")
+		return nil
 	}
 
-	// Return the list of jobs
-	return jobs, nil
+	// Log the job completion
+	a.logger.Info().
+		Str("user", payload.UserID).
+		Str("job", payload.JobID.String()).
+		Str("state", string(outcome.State)).
+		Int("payout", outcome.Payout).
+		Int("penalty", outcome.Penalty).
+		Bool("demerit", outcome.Demerit).
+		Int("xp", outcome.XP).
+		Msg("Job completed")
+
+	// The job is settled either way, so a notification that doesn't make it isn't worth running the task again for
+	if err := a.notifyJobOutcome(profile, profile.ActiveJob.Info(), outcome); err != nil {
+		a.logger.Error().
+			Err(err).
+			Str("user", payload.UserID).
+			Str("job", payload.JobID.String()).
+			Msg("Failed to notify user of job outcome")
+	}
+	if job := profile.ActiveJob.Info(); outcome.Levels > 0 && job.ChannelID != "" {
+		level := profile.level(a.Config.Economy)
+		if err := a.announceLevelUp(job.Gateway, job.GuildID, job.ChannelID, payload.UserID, level, outcome.Levels); err != nil {
+			a.logLevelUpError(err, payload.UserID, level)
+		}
+	}
+	return nil
+}
+
+// Duration returns how long the job takes once it's taken
+func (j *JobInfo) Duration() time.Duration {
+	return time.Duration(j.WorkTime) * time.Second
 }
 
-// generateJobs generates a new set of jobs for the given user profile
-func (a *App) generateJobs(p *Profile, count int) ([]Job, error) {
+// timeRemaining returns how long until the job is done
+func (j *JobInfo) timeRemaining() time.Duration {
+	return time.Until(time.Unix(j.DueAt, 0))
+}
+
+// generateJobs generates a new set of jobs for the given user profile, made of what's in the given content pack
+func (a *App) generateJobs(p *Profile, content *ContentPack, count int) ([]Job, error) {
 	// Generate a list of jobs with randomized names, descriptions, durations, and payouts
+	economy := content.economy(a.Config.Economy)
+	level := p.level(economy)
+	tiers := unlockedJobTiers(economy, level)
 	jobs := []Job{}
 	for i := 0; i < count; i++ {
-		// Generate a new job
-		name, desc := generateJobNameAndDescription()
-		j := Job{
-			ID:          uuid.NewV4(),
-			Name:        name,                                           // TODO: Generate a random name
-			Description: desc,                                           // TODO: Generate a random description
-			Payout:      rand.Intn(950) + 50,                            // 50 minimum, 1000 maximum
-			CreatedAt:   time.Now().Unix(),                              // Now
-			ExpiresAt:   time.Now().Unix() + int64(rand.Intn(3600)+300), // 5-60 minutes from now
-			Completed:   false,
-		}
+		// Generate a new job of a random type, scaled to the user's level and a tier they've unlocked.
+		// The type decides what it's called and how long it takes, what it pays and how risky it is.
+		job := randomJobType().new()
+		job.roll(content, economy, level)
+		tier := randBetween(1, tiers)
+		payout := job.payout(economy, level, jobTiers[tier-1])
+
+		j := job.Info()
+		j.ID = uuid.NewV4()
+		j.State = JOB_OFFERED
+		j.Payout = payout
+		j.FailPenalty = payout * economy.JobFailPenaltyPercent / 100 // A cut of the payout
+		j.QuitFee = payout * economy.JobQuitFeePercent / 100         // A cut of the payout
+		j.WorkTime = job.workTime(economy, level, jobTiers[tier-1])
+		j.Tier = tier                                                 // One the user has unlocked
+		j.CreatedAt = time.Now().Unix()                               // Now
+		j.OfferExpiresAt = time.Now().Add(economy.JobOfferTTL).Unix() // However long offers last
+
 		// Add the job to the list of jobs
-		jobs = append(jobs, j)
+		jobs = append(jobs, job)
 	}
 
 	// Debug log the generated jobs
 	a.logger.Debug().
 		Str("user", p.ID).
-		Interface("jobs", jobs).
+		Int("level", level).
+		Str("content", content.ID).
+		Interface("jobs", anyJobs(jobs)).
 		Msg("Generated new jobs")
 
 	// Return the list of jobs
 	return jobs, nil
 }
 
-// generateJobNameAndDescription generates a random job name and description
-func generateJobNameAndDescription() (string, string) {
-	// Generate a random name
-	name := fmt.Sprintf("%s %s", QUEST_TITLE_VERBS[rand.Intn(len(QUEST_TITLE_VERBS))], QUEST_TITLE_NOUNS[rand.Intn(len(QUEST_TITLE_NOUNS))])
-
-	// Generate a random description
-	desc := fmt.Sprintf("I need you to %s.", name)
-
-	// Return the name and description
-	return name, desc
+// randBetween returns a random integer between min and max, inclusive.
+func randBetween(min, max int) int {
+	if max <= min {
+		return min
+	}
+	return rand.Intn(max-min+1) + min // nolint:gosec // This is not a security issue
 }
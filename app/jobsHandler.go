@@ -1,13 +1,11 @@
 package app
 
 import (
-	"errors"
 	"fmt"
-	"strconv"
 	"strings"
 	"time"
 
-	"github.com/rs/zerolog/log"
+	uuid "github.com/satori/go.uuid"
 )
 
 /*
@@ -16,243 +14,295 @@ The Job System
 Yes, I am aware there's also "!work" right now. I guess I'll merge the two experimental systems into one eventually.
 The job system is meant to be an evolution of the idea of "!work" that allows users to take on jobs of various difficulty and risk.
 Upon the first request in the calendar day for that user, the app will generate a list of jobs that the user can take on.
-Jobs will have an ID, a name, and must bring their own functions for computing time and rewards.
+The day is the user's, in their time zone, and the board lasts until it's over. See app/jobBoard.go.
+Jobs will have an ID, a name, and must bring their own functions for computing time and rewards. Those come with the
+type of job, see app/jobTypes.go. What jobs are called and what they're about comes from the server's content pack,
+see app/contentPacks.go.
 The user will be able to select a job and the app will start a timer for that job. When the timer is up, the user will receive their reward.
+They hear how it went in the channel they took it in, or wherever they asked with !jobs notify. See app/notifications.go.
 A user will have an "active job" field in their profile that will be set to the job they are currently working on.
 If they have no active job, they will be able to start a new job. If they have an active job, they must wait or quit the job.
-Quitting a job should carry a penalty, but I haven't decided what that penalty should be yet.
+Quitting a job carries a penalty: a fee out of the job's payout, a demerit and a wait before the next job, each of which
+can be tuned or turned off in the config.
 */
 
-const jobsHelpResponse = `
-** Jobs **
-The jobs system allows you to earn money by taking on randomized jobs.
-Jobs are scaled to your level, so the higher your level, the more money you can earn.
-
-** Commands **
-- !jobs 			- Get a list of available jobs
-- !jobs help 		- Get help with the jobs system (you're looking at it)
-- !jobs list 		- Get a list of available jobs
-- !jobs refresh 	- Refresh the list of available jobs
-- !jobs take <job> 	- Take a job
-`
-
-const jobsUnknownCommandResponse = `I don't know what you mean by that. Try !jobs help.`
-
-// handleJob handles the !job command. It represents the entrypoint for the job system.
-// to parse the commands, each successive handler function should strip the 0th element from the splitCmd slice
-// and pass the rest of the slice to the next function until the command is fully parsed.
-func handleJobs(a *App, m *Message) error {
-	// Split the incoming message into a slice of strings
-	splitCmd := strings.Split(m.Content, " ")
-
-	// Pop the first element off the slice to get the command
-	cmd, splitCmd := splitCmd[0], splitCmd[1:]
-
-	// Make sure the command is !job
-	if cmd != "!jobs" {
-		return errors.New("invalid command for handleJob. expected !job, got " + cmd)
-	}
-
-	// If there are no more elements in the slice, we're at the end of the command chain
-	// and we can handle the command.
-	if len(splitCmd) == 0 {
-		return handleJobsList(a, m, []string{})
-	}
-
-	// Otherwise, we need to keep parsing the command.
-	// Pop the next element off the slice to get the subcommand
-	subCmd, splitCmd := splitCmd[0], splitCmd[1:]
-
-	// Switch on the subcommand
-	switch subCmd {
-	case "list":
-		return handleJobsList(a, m, splitCmd)
-	case "refresh":
-		return handleJobsRefresh(a, m, splitCmd)
-	case "start":
-		return handleJobsStart(a, m, splitCmd)
-	case "take":
-		return handleJobsStart(a, m, splitCmd)
-	case "active":
-		return handleJobsActive(a, m, splitCmd)
-	case "help":
-		return handleJobsHelp(a, m, splitCmd)
-	default:
-		return handleJobsUnknownCommand(a, m, splitCmd)
-	}
+// The !jobs command tree. It represents the entrypoint for the job system.
+func init() {
+	commands.Register(&Command{
+		Name:    "jobs",
+		Group:   "Jobs",
+		Summary: "List available jobs and their requirements",
+		Help: "The jobs system allows you to earn money by taking on randomized jobs.\n" +
+			"Jobs are scaled to your level, so the higher your level, the more money you can earn.",
+		Examples: []string{"jobs", "jobs take 3", "jobs active"},
+		LockedAt: STANDING_JAILED,
+		Handler:  handleJobsList,
+		Subcommands: []*Command{
+			{
+				Name:    "list",
+				Summary: "Get a list of available jobs",
+				Handler: handleJobsList,
+			},
+			{
+				Name:    "refresh",
+				Aliases: []string{"reroll"},
+				Summary: "Reroll your board for a new list of jobs",
+				Help:    "You get a new board every day. Until then you can reroll it a few times a day. Some rerolls are free, the rest cost money.",
+				Handler: handleJobsRefresh,
+			},
+			{
+				Name:    "take",
+				Aliases: []string{"start"},
+				Summary: "Take a job",
+				Args: []ArgSpec{
+					{Name: "job", Description: "Number of the job on your board", Type: ArgInt},
+				},
+				Handler: handleJobsStart,
+			},
+			{
+				Name:    "quit",
+				Aliases: []string{"abandon"},
+				Summary: "Walk out on your active job",
+				Help:    "Quit the job you're working on. You don't get paid for it, and quitting costs you: a fee, a demerit and a wait before you can take another job.",
+				Handler: handleJobsQuit,
+			},
+			{
+				Name:    "active",
+				Summary: "See how your active job is going",
+				Handler: handleJobsActive,
+			},
+			{
+				Name:    "notify",
+				Summary: "Choose where you hear how your jobs went",
+				Help: "When a job is done, or goes wrong, I'll tell you in the channel you took it in. Choose `dm` to hear about it in your DMs instead, or `off` to not hear about it at all.\n" +
+					"For `dm` to work you have to DM me once, so I know where to find you.",
+				Args: []ArgSpec{
+					{Name: "where", Description: "Where to tell you", Type: ArgEnum, Choices: []string{NOTIFY_CHANNEL, NOTIFY_DM, NOTIFY_OFF}, Optional: true},
+				},
+				Examples: []string{"jobs notify", "jobs notify dm"},
+				Handler:  handleJobsNotify,
+			},
+			helpSubcommand(),
+		},
+	})
 }
 
-// handleJobList handles the !jobs list command. It lists all available jobs.
-func handleJobsList(a *App, m *Message, splitCmd []string) error {
-	// Get the user's profile
+// handleJobList handles the !jobs list command. It lists the jobs on the user's board for today.
+func handleJobsList(a *App, m *Message, args *Args) error {
+	// Get the user's profile and today's board. The first look of the day gets a new one.
 	// This also initializes the user's profile if it doesn't exist
 	a.logger.Info().
 		Str("user", m.Author.Username).
 		Msg("getting user profile")
-	profile, err := a.getProfile(m.Author.ID)
+	profile, board, fresh, err := a.jobBoard(m.Author.ID, m.GuildID)
 	if err != nil {
-		return err
-	}
-
-	// Check for existing available jobs
-	jobs, err := a.getAvailableJobs(profile)
-	// If there's a redis nil error, it means the user has no jobs so we skip error handling
-	// So we handle everything else
-	if err != nil && err.Error() != "redis: nil" {
-		return err
-	}
-
-	// If there are no jobs, tell the user in the channel
-	if len(jobs) == 0 {
-		a.logger.Info().
+		a.logger.Error().
+			Err(err).
 			Str("user", m.Author.Username).
-			Msg("no jobs available")
-		msg := m.RespondToChannelOrThread("dbtg", "There are no jobs available right now. Looking for new work...", true, false)
-		a.handleOutgoingMessage(msg)
-		time.Sleep(5 * time.Second) // Give the impression that the bot is working on something
-
-		// Generate a new list of jobs
-		jobs, err = a.generateJobs(profile, 10)
-
-		// If there's an error, return it
-		if err != nil {
-			m.RespondToChannelOrThread("dbtg", "Nobody's hiring, kid. Come back later.", true, false)
-			return err
-		}
-
-		// Set the jobs in redis and proceed with the rest of the function
-		err = a.setAvailableJobs(profile, jobs)
-		if err != nil {
-			return err
-		}
+			Msg("error getting job board")
+		a.handleOutgoingMessage(m.RespondToChannelOrThread(a.Config.AppID, "Nobody's hiring, kid. Come back later.", true, false))
+		return err
 	}
 
 	// Respond to the user with the list of jobs
 	a.logger.Info().
 		Str("user", m.Author.Username).
+		Bool("fresh", fresh).
 		Msg("sending job list")
-	jobString := []string{}
-	jobString = append(jobString, "There's some folks looking for help. Here's what they need:\n")
-	jobString = append(jobString, "```")
-	for i, job := range jobs {
-		jobString = append(jobString, fmt.Sprintf("%d - %s", i, job.Name))
-	}
-	jobString = append(jobString, "```")
-	jobString = append(jobString, "To take a job, type `!jobs take <job ID>`")
-	msg := m.RespondToChannelOrThread("dbtg", strings.Join(jobString, "\n"), true, false)
+	msg := m.RespondToChannelOrThread(a.Config.AppID, formatJobBoard(a.Config.Economy, args.Prefix, profile, board, fresh), true, false)
 	return a.handleOutgoingMessage(msg)
 }
 
-// handleJobRefresh handles the !jobs refresh command. It generates a new list of jobs.
-func handleJobsRefresh(a *App, m *Message, splitCmd []string) error {
-	// Get the user's profile
+// handleJobRefresh handles the !jobs refresh command. It rerolls the user's board for today, if they have rerolls left
+// and can pay for them.
+func handleJobsRefresh(a *App, m *Message, args *Args) error {
+	economy := a.Config.Economy
+
+	// Get the user's profile and board
 	// This also initializes the user's profile if it doesn't exist
 	a.logger.Info().
 		Str("user", m.Author.Username).
 		Msg("getting user profile")
-	profile, err := a.getProfile(m.Author.ID)
+	profile, board, fresh, err := a.jobBoard(m.Author.ID, m.GuildID)
 	if err != nil {
 		return err
 	}
+	// Nobody pays to reroll a board they haven't seen yet
+	if fresh {
+		msg := m.RespondToChannelOrThread(a.Config.AppID, formatJobBoard(economy, args.Prefix, profile, board, fresh), true, false)
+		return a.handleOutgoingMessage(msg)
+	}
 
-	// Generate a new list of jobs
-	jobs, err := a.generateJobs(profile, 10)
+	// Generate a new list of jobs, made of what this server's into
+	content, err := a.contentPack(m.GuildID)
 	if err != nil {
-		log.Error().
+		return err
+	}
+	jobs, err := a.generateJobs(profile, content, economy.JobBoardSize)
+	if err != nil {
+		a.logger.Error().
 			Err(err).
 			Str("user", m.Author.Username).
 			Msg("error generating jobs")
-		m.RespondToChannelOrThread("dbtg", "Nobody's hiring, kid. Come back later.", true, false)
+		a.handleOutgoingMessage(m.RespondToChannelOrThread(a.Config.AppID, "Nobody's hiring, kid. Come back later.", true, false))
 		return err
 	}
 
-	// Set the jobs in redis and proceed with the rest of the function
-	err = a.setAvailableJobs(profile, jobs)
-	if err != nil {
-		log.Error().
+	// Pay for it, if it isn't free, and put the new jobs up in one go, so rerolls at the same time are all counted and paid for
+	day := board.Day
+	untilTomorrow := time.Until(board.replacedAt(profile.location(economy)))
+	cost, balance := 0, 0
+	profile, err = a.updateProfile(m.Author.ID, func(p *Profile) error {
+		board := p.board()
+		if board.Day != day {
+			return ErrBoardReplaced
+		}
+		if board.rerollsLeft(economy) == 0 {
+			return ErrNoRerolls
+		}
+		cost, balance = board.rerollCost(economy), p.Balance
+		if cost > 0 {
+			if p.standing(economy, time.Now()) >= STANDING_LOCKED {
+				return ErrRerollLocked
+			}
+			if p.Balance < cost {
+				return ErrInsufficientFunds
+			}
+			p.adjustBalance(-cost, LEDGER_JOB_REROLL, day)
+		}
+		board.Rerolls++
+		board.Jobs = jobs
+		return nil
+	})
+	switch err {
+	case nil:
+	case ErrBoardReplaced:
+		msg := fmt.Sprintf("Your board just changed under you. Type `%sjobs list` to see what's on it now.", args.Prefix)
+		return a.handleOutgoingMessage(m.RespondToChannelOrThread(a.Config.AppID, msg, true, false))
+	case ErrNoRerolls:
+		msg := fmt.Sprintf("That's all the rerolls you get today. You'll get a new board in %s.", formatDuration(untilTomorrow))
+		return a.handleOutgoingMessage(m.RespondToChannelOrThread(a.Config.AppID, msg, true, false))
+	case ErrRerollLocked:
+		msg := fmt.Sprintf("You're out of free rerolls, and with your record you can't spend money on more. You'll get a new board in %s.", formatDuration(untilTomorrow))
+		return a.handleOutgoingMessage(m.RespondToChannelOrThread(a.Config.AppID, msg, true, false))
+	case ErrInsufficientFunds:
+		msg := fmt.Sprintf("Another reroll costs %d dollars, and you've only got %d.", cost, balance)
+		return a.handleOutgoingMessage(m.RespondToChannelOrThread(a.Config.AppID, msg, true, false))
+	default:
+		a.logger.Error().
 			Err(err).
 			Str("user", m.Author.Username).
-			Msg("error setting jobs in redis")
-		m.RespondToChannelOrThread("dbtg", "I found some jobs for you but I lost the paperwork on the way over. Better luck next time.", true, false)
+			Msg("error saving jobs")
+		a.handleOutgoingMessage(m.RespondToChannelOrThread(a.Config.AppID, "I found some jobs for you but I lost the paperwork on the way over. Better luck next time.", true, false))
 		return err
 	}
+	board = profile.board()
 
 	// Respond to the user with the list of jobs
-	// TODO: This is a copy/paste of handleJobsList. Refactor this into a function.
 	a.logger.Info().
 		Str("user", m.Author.Username).
+		Int("rerolls", board.Rerolls).
+		Int("cost", cost).
 		Msg("sending job list")
+	reply := formatJobBoard(economy, args.Prefix, profile, board, false)
+	if cost > 0 {
+		reply = fmt.Sprintf("That reroll cost you %d dollars, you've got %d left.\n", cost, profile.Balance) + reply
+	}
+	msg := m.RespondToChannelOrThread(a.Config.AppID, reply, true, false) // Generate a reply message
+	return a.handleOutgoingMessage(msg)                                   // Send the message
+}
 
+// formatJobBoard formats a job board for the user. Jobs are numbered by where they are on the board,
+// which is what !jobs take goes by, so jobs that were taken or expired stay on the list to keep the numbers the same.
+// prefix is the guild's command prefix, and fresh says whether it's the first look at a new day's board.
+func formatJobBoard(e EconomyConfig, prefix string, p *Profile, board *JobBoard, fresh bool) string {
 	// Format a multi-line string with the job info
 	jobString := []string{}
-	jobString = append(jobString, "There's some folks looking for help. Here's what they need:")
+	if fresh {
+		jobString = append(jobString, "New day, new work. Here's who's looking for help today:")
+	} else {
+		jobString = append(jobString, "There's some folks looking for help. Here's what they need:")
+	}
 	jobString = append(jobString, "```") // Use a code block to make it look nice
 
 	// Loop through the jobs and append the info to the string
-	for i, job := range jobs {
-		jobString = append(jobString, fmt.Sprintf("%d - %s", i, job.Name))
+	for i, job := range board.Jobs {
+		j := job.Info()
+		if board.taken(j.ID) {
+			jobString = append(jobString, fmt.Sprintf("%d - %s (taken)", i, j.Name))
+			continue
+		}
+		if j.State != JOB_OFFERED {
+			jobString = append(jobString, fmt.Sprintf("%d - %s (%s)", i, j.Name, j.State))
+			continue
+		}
+		needs := ""
+		if requirements := job.requirements(); requirements != "" {
+			needs = ", needs " + requirements
+		}
+		jobString = append(jobString, fmt.Sprintf("%d - %s: %s (%d credits, takes %s, %d%% risk%s)", i, tierOf(j).Name, j.Name, j.Payout, formatDuration(j.Duration()), j.Risk, needs))
 	}
 
-	jobString = append(jobString, "```")                                                  // Close the code block
-	jobString = append(jobString, "To take a job, type `!jobs take <job ID>`")            // Tell the user how to take a job
-	msg := m.RespondToChannelOrThread("dbtg", strings.Join(jobString, "\n"), true, false) // Generate a reply message
-	return a.handleOutgoingMessage(msg)                                                   // Send the message
+	jobString = append(jobString, "```") // Close the code block
+	if board.open(time.Now()) > 0 {
+		jobString = append(jobString, fmt.Sprintf("To take a job, type `%sjobs take <job ID>`", prefix)) // Tell the user how to take a job
+	} else {
+		jobString = append(jobString, "That's all the work there is for today.")
+	}
+
+	// Tell them when the board turns over, and what it takes to turn it over sooner
+	untilTomorrow := formatDuration(time.Until(board.replacedAt(p.location(e))))
+	switch left := board.rerollsLeft(e); {
+	case left == 0:
+		jobString = append(jobString, fmt.Sprintf("You'll get a new board in %s.", untilTomorrow))
+	case board.rerollCost(e) == 0:
+		jobString = append(jobString, fmt.Sprintf("You'll get a new board in %s, or type `%sjobs refresh` to reroll it now for free (%d left today).", untilTomorrow, prefix, left))
+	default:
+		jobString = append(jobString, fmt.Sprintf("You'll get a new board in %s, or type `%sjobs refresh` to reroll it now for %d dollars (%d left today).", untilTomorrow, prefix, board.rerollCost(e), left))
+	}
+	return strings.Join(jobString, "\n")
 }
 
 // handleJobStart handles the !job start command. It starts a job for the user.
 // Jobs are stored in a slice in the user's profile
 // Profile -> Jobs -> Job by index
-// An active job must be removed from the AvailableJobs slice and added to the ActiveJobs field
-// And then a goroutine must be started to act as a timer for the job.
-// It should sleep for the duration of the job and then grant the user the reward and remove the job from the ActiveJobs slice when it returns.
-func handleJobsStart(a *App, m *Message, splitCmd []string) error {
-
-	// Make sure splitCmd is not empty and contains an integer
-	if len(splitCmd) == 0 {
-		a.logger.Info().
-			Str("user", m.Author.Username).
-			Msg("no job ID provided")
-		a.handleOutgoingMessage(m.RespondToChannelOrThread("dbtg", "You need to provide a job ID. Type `!jobs list` to see a list of available jobs.", true, false))
-		return errors.New("no job ID provided")
-	}
-
-	// Make sure splitCmd[0] is a valid, positive integer
-	jobID, err := strconv.Atoi(splitCmd[0])
-	if err != nil || jobID < 0 {
-		a.logger.Error().
-			Err(err).
-			Str("user", m.Author.Username).
-			Msg("error converting job ID to valid integer for indexing")
-		a.handleOutgoingMessage(m.RespondToChannelOrThread("dbtg", "That's not a valid job ID. Type `!jobs list` to see a list of available jobs.", true, false))
-		return err
-	}
-
-	// Get the user's profile
-	profile, err := a.getProfile(m.Author.ID)
+// An active job is a copy of the one on the board, which is marked as taken
+// The completion of the job is handed to the scheduler first, which grants the user the reward
+// once the duration of the job has passed, even if the app restarted in the meantime.
+func handleJobsStart(a *App, m *Message, args *Args) error {
+	// The router already made sure this is a number. Whether it's on the board is checked below.
+	jobID := args.Int("job")
+
+	// Quitters have to wait a bit before taking another job
+	remaining, err := a.cooldownRemaining(m.Author.ID, COOLDOWN_JOB)
 	if err != nil {
-		a.logger.Error().
-			Err(err).
-			Str("user", m.Author.Username).
-			Msg("error getting user profile")
 		return err
 	}
-	a.logger.Info().
-		Str("user", m.Author.Username).
-		Msg("User profile retrieved")
+	if remaining > 0 {
+		msg := fmt.Sprintf("You just walked out on a job. Nobody's hiring you for another %s.", formatDuration(remaining))
+		return a.handleOutgoingMessage(m.RespondToChannelOrThread(a.Config.AppID, msg, true, false))
+	}
 
-	// Check for existing available jobs
-	jobs, err := a.getAvailableJobs(profile)
+	// Get the user's profile and today's board
+	_, board, fresh, err := a.jobBoard(m.Author.ID, m.GuildID)
 	if err != nil {
 		a.logger.Error().
 			Err(err).
 			Str("user", m.Author.Username).
-			Msg("error getting available jobs")
+			Msg("error getting job board")
 		return err
 	}
+	jobs := board.Jobs
 	a.logger.Info().
 		Str("user", m.Author.Username).
 		Msgf("%d available jobs retrieved", len(jobs))
 
+	// The numbers they know are from yesterday's board
+	if fresh {
+		msg := fmt.Sprintf("It's a new day, and there's a new board. Type `%sjobs list` to see what's on it before you take anything.", args.Prefix)
+		return a.handleOutgoingMessage(m.RespondToChannelOrThread(a.Config.AppID, msg, true, false))
+	}
+
 	// Make sure the job ID is valid
 	if jobID < 0 || jobID >= len(jobs) {
 		a.logger.Error().
@@ -260,35 +310,104 @@ func handleJobsStart(a *App, m *Message, splitCmd []string) error {
 			Int("jobID", jobID).
 			Int("numJobs", len(jobs)).
 			Msg("Invalid job ID: job ID out of range")
-		return errors.New("invalid job ID")
+		msg := fmt.Sprintf("There's no job %d on your board. Type `%sjobs list` to see a list of available jobs.", jobID, args.Prefix)
+		return a.handleOutgoingMessage(m.RespondToChannelOrThread(a.Config.AppID, msg, true, false))
 	}
 
-	// Assign the job to the user's ActiveJob field
-	// Make a copy of the job so we don't modify the original
-	activeJob := jobs[jobID]
-	profile.ActiveJob = activeJob
+	// Every job on the board can be taken once a day
+	if board.taken(jobs[jobID].Info().ID) {
+		msg := fmt.Sprintf("You already took job %d today. Type `%sjobs list` to see what's still going.", jobID, args.Prefix)
+		return a.handleOutgoingMessage(m.RespondToChannelOrThread(a.Config.AppID, msg, true, false))
+	}
 
-	// Save profile
-	err = profile.save(a)
+	// Offers don't wait around forever
+	if !jobs[jobID].Info().open(time.Now()) {
+		msg := fmt.Sprintf("Job %d is %s. Type `%sjobs list` to see what's still going.", jobID, jobs[jobID].Info().State, args.Prefix)
+		return a.handleOutgoingMessage(m.RespondToChannelOrThread(a.Config.AppID, msg, true, false))
+	}
+
+	// Make a copy of the job so we don't modify the original, and start it
+	day := board.Day
+	activeJob, err := cloneJob(jobs[jobID])
+	if err != nil {
+		return err
+	}
+	active := activeJob.Info()
+	active.Gateway = m.Metadata.Source
+	active.GuildID = m.GuildID
+	active.ChannelID = m.ChannelID
+	if err := active.transition(JOB_ACTIVE, time.Now()); err != nil {
+		return err
+	}
+
+	// Schedule the payout for when the job is done before the job is the user's, so an active job always has a completion.
+	// If the job doesn't end up theirs, the completion is cancelled again.
+	err = active.scheduleCompletion(a, m.Author.ID)
 	if err != nil {
 		a.logger.Error().
 			Err(err).
 			Str("user", m.Author.Username).
-			Msg("error saving profile")
+			Msg("error scheduling job completion")
 		return err
 	}
 
-	a.logger.Info().
-		Str("user", m.Author.Username).
-		Str("job", profile.ActiveJob.Name).
-		Str("id", profile.ActiveJob.ID.String()).
-		Msg("Job assigned to user")
-
-	// Remove the job from the AvailableJobs slice
-	// and tell the app to save the jobs to the database
-	jobs = append(jobs[:jobID], jobs[jobID+1:]...)
-	err = a.setAvailableJobs(profile, jobs)
+	// Assign the job to the user's ActiveJob field and mark it as taken on the board, so it can't be taken again today.
+	// The board is checked again first, in case another command got to it in the meantime.
+	var current *JobInfo
+	profile, err := a.updateProfile(m.Author.ID, func(p *Profile) error {
+		// One job at a time. Starting another would leave the first one's completion paying out a job nobody's working.
+		current = nil
+		if p.ActiveJob.Info().active() {
+			current = p.ActiveJob.Info()
+			return ErrJobActive
+		}
+		board := p.board()
+		if board.Day != day || jobID >= len(board.Jobs) || !uuid.Equal(board.Jobs[jobID].Info().ID, active.ID) {
+			return ErrBoardReplaced
+		}
+		if board.taken(active.ID) {
+			return ErrJobTaken
+		}
+		if !board.Jobs[jobID].Info().open(time.Now()) {
+			return ErrJobClosed
+		}
+		if err := activeJob.take(p); err != nil {
+			return err
+		}
+		p.ActiveJob = AnyJob{activeJob}
+		board.Taken = append(board.Taken, active.ID)
+		return nil
+	})
 	if err != nil {
+		// The completion belongs to the job that's running if it's this very one, taken twice at once
+		if current == nil || !uuid.Equal(current.ID, active.ID) {
+			if cancelErr := a.scheduler.Cancel(active.ID.String()); cancelErr != nil {
+				a.logger.Error().
+					Err(cancelErr).
+					Str("user", m.Author.Username).
+					Str("job", active.ID.String()).
+					Msg("error cancelling job completion")
+			}
+		}
+	}
+	switch err {
+	case nil:
+	case ErrJobActive:
+		msg := fmt.Sprintf("You're already working on '%s'. Finish it first, or type `%sjobs quit` to walk out on it.", current.Name, args.Prefix)
+		return a.handleOutgoingMessage(m.RespondToChannelOrThread(a.Config.AppID, msg, true, false))
+	case ErrJobRequirements:
+		msg := fmt.Sprintf("You need %s for that job. Type `%sshop` to see what's for sale.", jobs[jobID].requirements(), args.Prefix)
+		return a.handleOutgoingMessage(m.RespondToChannelOrThread(a.Config.AppID, msg, true, false))
+	case ErrBoardReplaced:
+		msg := fmt.Sprintf("Your board just changed under you. Type `%sjobs list` to see what's on it now.", args.Prefix)
+		return a.handleOutgoingMessage(m.RespondToChannelOrThread(a.Config.AppID, msg, true, false))
+	case ErrJobTaken:
+		msg := fmt.Sprintf("You already took job %d today. Type `%sjobs list` to see what's still going.", jobID, args.Prefix)
+		return a.handleOutgoingMessage(m.RespondToChannelOrThread(a.Config.AppID, msg, true, false))
+	case ErrJobClosed:
+		msg := fmt.Sprintf("Job %d is %s. Type `%sjobs list` to see what's still going.", jobID, JOB_EXPIRED, args.Prefix)
+		return a.handleOutgoingMessage(m.RespondToChannelOrThread(a.Config.AppID, msg, true, false))
+	default:
 		a.logger.Error().
 			Err(err).
 			Str("user", m.Author.Username).
@@ -296,56 +415,23 @@ func handleJobsStart(a *App, m *Message, splitCmd []string) error {
 		return err
 	}
 
-	// Start a goroutine to act as a timer for the job
-	go profile.ActiveJob.work(a, profile.ID)
 	a.logger.Info().
 		Str("user", m.Author.Username).
-		Str("job", profile.ActiveJob.Name).
-		Str("id", profile.ActiveJob.ID.String()).
-		Msg("Job started")
+		Str("job", active.Name).
+		Str("type", profile.ActiveJob.Type()).
+		Str("id", active.ID.String()).
+		Msg("Job assigned to user")
 
 	// Construct a message to send to the user with the job info and a timer
-	jobAcceptedMessage := fmt.Sprintf("'%s', eh? I'll let the boss know you're on that one. Get lost.", profile.ActiveJob.Name)
-	jobAcceptedMessage += fmt.Sprintf(" You've got %d seconds to get it done.", profile.ActiveJob.Duration())
-	jobAcceptedMessage += fmt.Sprintf(" If you don't get it done in time, I'll be taking your %d credits.", profile.ActiveJob.Payout)
+	jobAcceptedMessage := fmt.Sprintf("'%s', eh? I'll let the boss know you're on that one. Get lost.", active.Name)
+	jobAcceptedMessage += describeActiveJob(active)
 
 	// Send the message
-	return a.handleOutgoingMessage(m.RespondToChannelOrThread("dbtg", jobAcceptedMessage, true, false))
-}
-
-// handleJobHelp handles the !job help command. It displays help for the job system.
-func handleJobsHelp(a *App, m *Message, splitCmd []string) error {
-	a.logger.Info().
-		Str("user", m.Author.Username).
-		Msg("User requested help message")
-	// Generate the help message
-	help := jobsHelpResponse
-
-	// Send the help message to the user
-	msg := m.RespondToChannelOrThread("dbtg", help, true, false)
-
-	return a.handleOutgoingMessage(msg)
-}
-
-// handleJobUnknownCommand handles an unknown command. It displays help for the job system.
-func handleJobsUnknownCommand(a *App, m *Message, splitCmd []string) error {
-	a.logger.Info().
-		Str("user", m.Author.Username).
-		Str("command", strings.Join(splitCmd, " ")).
-		Str("channel", m.ChannelID).
-		Str("guild", m.GuildID).
-		Msg("User requested unknown command")
-	// Generate the help message
-	help := jobsUnknownCommandResponse
-
-	// Send the help message to the user
-	msg := m.RespondToChannelOrThread("dbtg", help, true, false)
-
-	return a.handleOutgoingMessage(msg)
+	return a.handleOutgoingMessage(m.RespondToChannelOrThread(a.Config.AppID, jobAcceptedMessage, true, false))
 }
 
 // handleJobsActive handles the !jobs active command. It displays the user's active job.
-func handleJobsActive(a *App, m *Message, splitCmd []string) error {
+func handleJobsActive(a *App, m *Message, args *Args) error {
 	a.logger.Info().
 		Str("user", m.Author.Username).
 		Msg("User requested active job")
@@ -365,26 +451,145 @@ func handleJobsActive(a *App, m *Message, splitCmd []string) error {
 
 	// Check if the user has an empty ActiveJob field
 	// This they have never been assigned a job
-	if profile.ActiveJob.ID.String() == "00000000-0000-0000-0000-000000000000" { // A zero UUID is the default value for an empty uuid.UUID
+	if profile.ActiveJob.Info().ID.String() == "00000000-0000-0000-0000-000000000000" { // A zero UUID is the default value for an empty uuid.UUID
 		a.logger.Info().
 			Str("user", m.Author.Username).
 			Msg("User has no active job")
-		return a.handleOutgoingMessage(m.RespondToChannelOrThread("dbtg", "What are you doing? You don't have a job! Go look at the board!", true, false))
+		return a.handleOutgoingMessage(m.RespondToChannelOrThread(a.Config.AppID, "What are you doing? You don't have a job! Go look at the board!", true, false))
 	}
 
-	// Check if the user has completed their active job
-	if profile.ActiveJob.Completed {
-		a.logger.Info().
+	// Tell the user how their latest job is going, or how it went
+	job := profile.ActiveJob.Info()
+	msg := ""
+	switch job.State {
+	case JOB_ACTIVE:
+		msg = fmt.Sprintf("You're currently working on '%s'.", job.Name) + describeActiveJob(job)
+	case JOB_SUCCEEDED:
+		// Jobs from before they kept what was paid out don't know how much demerits cut from it
+		pay := "pay"
+		if job.Paid > 0 {
+			pay = fmt.Sprintf("%d credits", job.Paid)
+		}
+		msg = fmt.Sprintf("You're done here! I sent your %s to your mom already. Grab another one and get out of my hair.", pay)
+	case JOB_FAILED:
+		msg = fmt.Sprintf("You botched '%s'. Don't show your face around here until you've found another job.", job.Name)
+	case JOB_ABANDONED:
+		msg = fmt.Sprintf("You walked out on '%s'. Go look at the board if you want another chance.", job.Name)
+	default:
+		msg = "What are you doing? You don't have a job! Go look at the board!"
+	}
+
+	// Send the message
+	return a.handleOutgoingMessage(m.RespondToChannelOrThread(a.Config.AppID, msg, true, false))
+}
+
+// describeActiveJob tells the user what's riding on the job they're working on.
+func describeActiveJob(job *JobInfo) string {
+	msg := fmt.Sprintf(" You've got %s to get it done, and it pays %d credits.", formatDuration(job.timeRemaining()), job.Payout)
+	if job.timeRemaining() <= 0 {
+		msg = fmt.Sprintf(" It's due any second now, and it pays %d credits.", job.Payout)
+	}
+	if job.Risk > 0 {
+		msg += fmt.Sprintf(" There's a %d%% chance it goes wrong, and if it does I'll be taking %d credits off you.", job.Risk, job.FailPenalty)
+	}
+	return msg
+}
+
+// handleJobsQuit handles the !jobs quit command. It takes the user off their active job, calls off its payout
+// and hands out whatever penalties the config asks for.
+func handleJobsQuit(a *App, m *Message, args *Args) error {
+	economy := a.Config.Economy
+	var quit *JobInfo
+	var outcome JobOutcome
+	profile, err := a.updateProfile(m.Author.ID, func(p *Profile) error {
+		if !p.ActiveJob.Info().active() {
+			return ErrNoActiveJob
+		}
+		var err error
+		outcome, err = p.finishJob(economy, JOB_ABANDONED)
+		quit = p.ActiveJob.Info()
+		return err
+	})
+	if err == ErrNoActiveJob {
+		msg := fmt.Sprintf("You can't quit a job you don't have. Type `%sjobs list` to find one.", args.Prefix)
+		return a.handleOutgoingMessage(m.RespondToChannelOrThread(a.Config.AppID, msg, true, false))
+	}
+	if err != nil {
+		return err
+	}
+
+	// The job's abandoned, so the completion wouldn't settle it anyway. Cancelling it just saves the scheduler the trouble.
+	if err := a.scheduler.Cancel(quit.ID.String()); err != nil {
+		a.logger.Error().
+			Err(err).
 			Str("user", m.Author.Username).
-			Msg("User has completed their active job")
-		return a.handleOutgoingMessage(m.RespondToChannelOrThread("dbtg", fmt.Sprintf("You're done here! I sent your %d credits to your mom already. Grab another one and get out of my hair.", profile.ActiveJob.Payout), true, false))
+			Str("job", quit.ID.String()).
+			Msg("error cancelling job completion")
+	}
+	if _, err := a.startCooldown(m.Author.ID, COOLDOWN_JOB, economy.JobQuitCooldown); err != nil {
+		return err
+	}
+
+	a.logger.Info().
+		Str("user", m.Author.Username).
+		Str("job", quit.ID.String()).
+		Int("fee", outcome.Penalty).
+		Bool("jailed", outcome.Jailed).
+		Msg("Job quit")
+
+	msg := fmt.Sprintf("Fine, walk out on '%s'. See if I care.", quit.Name)
+	if outcome.Penalty > 0 {
+		msg += fmt.Sprintf(" The boss kept %d dollars for the trouble, you've got %d left.", outcome.Penalty, profile.Balance)
+	}
+	if outcome.Jailed {
+		msg += " That's a demerit too, and it's one too many: you're going to jail."
+	} else if outcome.Demerit {
+		msg += " That's a demerit too."
 	}
+	if economy.JobQuitCooldown > 0 {
+		msg += fmt.Sprintf(" Nobody's hiring you for the next %s.", formatDuration(economy.JobQuitCooldown))
+	}
+	return a.handleOutgoingMessage(m.RespondToChannelOrThread(a.Config.AppID, msg, true, false))
+}
 
-	// Construct the message
-	msg := fmt.Sprintf("You're currently working on '%s'.", profile.ActiveJob.Name)
-	msg += fmt.Sprintf(" You've got %d seconds to get it done.", profile.ActiveJob.timeRemaining())
-	msg += fmt.Sprintf(" If you don't get it done in time, I'll be taking your %d credits.", profile.ActiveJob.Payout)
+// handleJobsNotify handles the !jobs notify command. Without an argument it says where notifications go now.
+func handleJobsNotify(a *App, m *Message, args *Args) error {
+	if !args.Has("where") {
+		profile, err := a.getProfile(m.Author.ID)
+		if err != nil {
+			return err
+		}
+		msg := "I'll tell you how your jobs went in the channel you took them in."
+		switch profile.JobNotify {
+		case NOTIFY_DM:
+			msg = "I'll tell you how your jobs went in your DMs."
+		case NOTIFY_OFF:
+			msg = fmt.Sprintf("I won't tell you how your jobs went. Check on them with `%sjobs active`.", args.Prefix)
+		}
+		return a.handleOutgoingMessage(m.RespondToChannelOrThread(a.Config.AppID, msg, true, false))
+	}
 
-	// Send the message
-	return a.handleOutgoingMessage(m.RespondToChannelOrThread("dbtg", msg, true, false))
+	where := args.String("where")
+	if _, err := a.updateProfile(m.Author.ID, func(p *Profile) error {
+		p.JobNotify = where
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	msg := "Got it, I'll tell you how your jobs went in the channel you took them in."
+	switch where {
+	case NOTIFY_DM:
+		msg = "Got it, I'll tell you how your jobs went in your DMs."
+		dm, err := a.store.DMChannel(a.context, m.Metadata.Source, m.Author.ID)
+		if err != nil {
+			return err
+		}
+		if dm == "" {
+			msg += " Send me a DM first so I know where to find you. Until then I'll tell you in the channel."
+		}
+	case NOTIFY_OFF:
+		msg = fmt.Sprintf("Got it, I won't tell you how your jobs went. Check on them with `%sjobs active`.", args.Prefix)
+	}
+	return a.handleOutgoingMessage(m.RespondToChannelOrThread(a.Config.AppID, msg, true, false))
 }
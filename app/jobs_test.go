@@ -0,0 +1,83 @@
+package app
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+)
+
+func TestRecoverJobs(t *testing.T) {
+	a, _ := newTestApp(t)
+	now := time.Now()
+
+	// A job from before the scheduler that was due while the bot was down, one that's still running,
+	// one that already has its task and one that's over
+	jobs := map[string]*TimedJob{
+		"overdue":   {JobInfo{ID: uuid.NewV4(), State: JOB_ACTIVE, Payout: 100, DueAt: now.Add(-time.Hour).Unix()}},
+		"running":   {JobInfo{ID: uuid.NewV4(), State: JOB_ACTIVE, Payout: 100, DueAt: now.Add(time.Hour).Unix()}},
+		"scheduled": {JobInfo{ID: uuid.NewV4(), State: JOB_ACTIVE, Payout: 100, DueAt: now.Add(time.Hour).Unix()}},
+		"finished":  {JobInfo{ID: uuid.NewV4(), State: JOB_SUCCEEDED, Payout: 100, DueAt: now.Add(-time.Hour).Unix()}},
+	}
+	for userID, job := range jobs {
+		job := job
+		if _, err := a.updateProfile(userID, func(p *Profile) error {
+			p.ActiveJob = AnyJob{job}
+			return nil
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := jobs["scheduled"].scheduleCompletion(a, "scheduled"); err != nil {
+		t.Fatal(err)
+	}
+
+	a.recoverJobs()
+	for userID, want := range map[string]bool{"overdue": true, "running": true, "scheduled": true, "finished": false} {
+		scheduled, err := a.store.HasTask(a.context, jobs[userID].ID.String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if scheduled != want {
+			t.Errorf("%s job scheduled: %v, want %v", userID, scheduled, want)
+		}
+	}
+	if pending, err := a.store.PendingTasks(a.context); err != nil || pending != 3 {
+		t.Errorf("%d tasks pending (%v), want 3", pending, err)
+	}
+
+	// The overdue job is settled on the first tick, the running one is left to finish
+	a.scheduler.tick()
+	for userID, want := range map[string]int{"overdue": 100, "running": 0} {
+		if got := balanceOf(t, a, userID); got != want {
+			t.Errorf("%s job paid %d, want %d", userID, got, want)
+		}
+	}
+
+	// Everything has a task now, so there's nothing left to recover
+	a.recoverJobs()
+	if pending, err := a.store.PendingTasks(a.context); err != nil || pending != 2 {
+		t.Errorf("%d tasks pending (%v), want 2", pending, err)
+	}
+}
+
+func TestRecoverLegacyJob(t *testing.T) {
+	a, _ := newTestApp(t)
+	jobID := uuid.NewV4()
+	dueAt := time.Now().Add(time.Hour).Unix()
+
+	// Taken before jobs had states, and still running when the bot was upgraded
+	a.store.(*memoryStore).profiles["1"] = []byte(fmt.Sprintf(
+		`{"id": "1", "schema_version": 5, "current_job": {"id": "%s", "payout": 100, "created_at": %d, "expires_at": %d}}`,
+		jobID, dueAt-600, dueAt))
+
+	a.recoverJobs()
+	scheduled, err := a.store.HasTask(a.context, jobID.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !scheduled {
+		t.Error("the legacy job didn't get a completion task")
+	}
+}
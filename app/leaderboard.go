@@ -0,0 +1,254 @@
+package app
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+)
+
+/*
+Leaderboards
+
+There's a leaderboard for every stat worth bragging about: balance, completed jobs, lifetime earnings and XP.
+Each one is a Redis sorted set of user ID to score, which the store updates in the same transaction
+that saves the profile, so the boards are never behind.
+
+Server leaderboards don't have sets of their own. The bot remembers which users it has seen in which server,
+and a server board is the global board intersected with that server's members.
+
+Names on the board are whatever the user was called the last time the bot saw them say something.
+*/
+
+const (
+	LEADERBOARD_PREFIX = "leaderboard:" // Sorted set of user ID to score per stat, under "leaderboard:<stat>"
+	GUILD_MEMBERS_KEY  = "members"      // Set of user IDs seen in a guild, under "guild:<guild_id>:members"
+	DISPLAY_NAMES_KEY  = "names"        // Hash of user ID to the name they were last seen with
+	DM_CHANNELS_KEY    = "dm_channels"  // Hash of "<gateway>:<user_id>" to the channel of the user's DMs with the bot on that gateway
+)
+
+// dmChannelField returns the field of DM_CHANNELS_KEY the user's DM channel on the gateway is under.
+// Every gateway has its own DM channels, so a user has one per gateway. Fields from before that are just the user ID.
+func dmChannelField(gatewayID, userID string) string {
+	return gatewayID + ":" + userID
+}
+
+// Leaderboard scopes
+const (
+	LEADERBOARD_GLOBAL = "global" // Everybody the bot has ever seen
+	LEADERBOARD_SERVER = "server" // Only the people seen in the current server
+)
+
+// leaderboardPageSize is how many entries a page of a leaderboard has.
+const leaderboardPageSize = 10
+
+// Stats there are leaderboards for
+const (
+	LEADERBOARD_BALANCE = "balance"
+	LEADERBOARD_JOBS    = "jobs"
+	LEADERBOARD_EARNED  = "earned"
+	LEADERBOARD_XP      = "xp"
+)
+
+// leaderboardStats maps every leaderboard to the profile stat it ranks and how to describe a score.
+var leaderboardStats = []struct {
+	Name  string
+	score func(p *Profile) int
+	unit  string
+}{
+	{LEADERBOARD_BALANCE, func(p *Profile) int { return p.Balance }, "dollars"},
+	{LEADERBOARD_JOBS, func(p *Profile) int { return p.JobsCompleted }, "jobs"},
+	{LEADERBOARD_EARNED, func(p *Profile) int { return p.Earned }, "dollars earned"},
+	{LEADERBOARD_XP, func(p *Profile) int { return p.XP }, "XP"},
+}
+
+// LeaderboardQuery picks a page of a leaderboard.
+type LeaderboardQuery struct {
+	Stat    string // One of the LEADERBOARD_ stats
+	GuildID string // Only rank members of this guild. Empty means everybody.
+	UserID  string // Whose own rank to look up
+	Offset  int    // How many entries to skip
+	Limit   int    // How many entries to return
+}
+
+// LeaderboardEntry is a user's place on a leaderboard.
+type LeaderboardEntry struct {
+	Rank   int // 1 is the top
+	UserID string
+	Score  int
+}
+
+// LeaderboardPage is a page of a leaderboard.
+type LeaderboardPage struct {
+	Entries []LeaderboardEntry
+	Total   int               // How many users are on the whole board
+	Own     *LeaderboardEntry // Where the querying user stands, or nil if they aren't on the board
+}
+
+// The !leaderboard command
+func init() {
+	commands.Register(&Command{
+		Name:    "leaderboard",
+		Aliases: []string{"lb", "top"},
+		Group:   "General",
+		Summary: "See who's on top",
+		Help: "Ranks everybody by balance, completed jobs, lifetime earnings or XP.\n" +
+			"In a server you see the people in that server unless you ask for the global board.",
+		Args: []ArgSpec{
+			{Name: "stat", Description: "What to rank by", Type: ArgEnum, Choices: []string{LEADERBOARD_BALANCE, LEADERBOARD_JOBS, LEADERBOARD_EARNED, LEADERBOARD_XP}, Optional: true},
+			{Name: "scope", Description: "Who to rank", Type: ArgEnum, Choices: []string{LEADERBOARD_SERVER, LEADERBOARD_GLOBAL}, Optional: true},
+			{Name: "page", Description: "Which page to show", Type: ArgInt, Optional: true},
+		},
+		Examples: []string{"leaderboard", "leaderboard jobs", "leaderboard earned global", "leaderboard balance server 2"},
+		Handler:  handleLeaderboardCommand,
+		Subcommands: []*Command{
+			helpSubcommand(),
+		},
+	})
+}
+
+// leaderboardKey returns the Redis key for the global leaderboard of a stat.
+func leaderboardKey(stat string) string {
+	return LEADERBOARD_PREFIX + stat
+}
+
+// guildMembersKey returns the Redis key for the users seen in a guild.
+func guildMembersKey(guildID string) string {
+	return GUILD_SETTINGS_PREFIX + guildID + ":" + GUILD_MEMBERS_KEY
+}
+
+// seenAuthors remembers which authors have already been written to the store, and under what name,
+// so we don't write to the store for every single message.
+type seenAuthors struct {
+	mu    sync.Mutex
+	names map[string]string // guild ID + user ID to display name
+}
+
+// rememberAuthor records the name of the author of a message and that they're a member of the guild it was sent in.
+// For a DM it also records the channel, so the bot can DM them later.
+func (a *App) rememberAuthor(m *Message) {
+	if m.Author == nil || m.Author.Bot {
+		return
+	}
+	name := displayName(m)
+	key := m.GuildID + ":" + m.Author.ID
+
+	a.seen.mu.Lock()
+	known := a.seen.names[key] == name
+	a.seen.names[key] = name
+	a.seen.mu.Unlock()
+	if known {
+		return
+	}
+
+	err := a.store.RememberUser(a.context, m.GuildID, m.Author.ID, name)
+	if err == nil && m.GuildID == "" {
+		// A DM is the only way to find out where to DM somebody. See app/notifications.go
+		err = a.store.RememberDMChannel(a.context, m.Metadata.Source, m.Author.ID, m.ChannelID)
+	}
+	if err != nil {
+		a.logger.Error().
+			Err(err).
+			Str("user", m.Author.ID).
+			Str("guild", m.GuildID).
+			Msg("failed to remember user")
+
+		// Try again next time
+		a.seen.mu.Lock()
+		delete(a.seen.names, key)
+		a.seen.mu.Unlock()
+	}
+}
+
+// handleLeaderboardCommand handles the !leaderboard command.
+func handleLeaderboardCommand(a *App, m *Message, args *Args) error {
+	stat := LEADERBOARD_BALANCE
+	if args.Has("stat") {
+		stat = args.String("stat")
+	}
+
+	// Default to the server board in a server. DMs only have the global one.
+	scope := LEADERBOARD_GLOBAL
+	if m.GuildID != "" {
+		scope = LEADERBOARD_SERVER
+	}
+	if args.Has("scope") {
+		scope = args.String("scope")
+	}
+	if scope == LEADERBOARD_SERVER && m.GuildID == "" {
+		msg := fmt.Sprintf("There's no server leaderboard in DMs. Try `%sleaderboard %s global`.", args.Prefix, stat)
+		return a.handleOutgoingMessage(m.RespondToChannelOrThread(a.Config.AppID, msg, true, false))
+	}
+
+	page := 1
+	if args.Has("page") {
+		page = args.Int("page")
+	}
+	if page < 1 {
+		return a.handleOutgoingMessage(m.RespondToChannelOrThread(a.Config.AppID, "Pages start at 1.", true, false))
+	}
+
+	query := LeaderboardQuery{
+		Stat:   stat,
+		UserID: m.Author.ID,
+		Offset: (page - 1) * leaderboardPageSize,
+		Limit:  leaderboardPageSize,
+	}
+	if scope == LEADERBOARD_SERVER {
+		query.GuildID = m.GuildID
+	}
+	result, err := a.store.Leaderboard(a.context, query)
+	if err != nil {
+		return err
+	}
+
+	pages := (result.Total + leaderboardPageSize - 1) / leaderboardPageSize
+	if result.Total == 0 {
+		return a.handleOutgoingMessage(m.RespondToChannelOrThread(a.Config.AppID, "Nobody's on this leaderboard yet. Be the first!", true, false))
+	}
+	if len(result.Entries) == 0 {
+		msg := fmt.Sprintf("That's past the last page. This leaderboard ends at page %d.", pages)
+		return a.handleOutgoingMessage(m.RespondToChannelOrThread(a.Config.AppID, msg, true, false))
+	}
+
+	// Look up the names of everybody we're about to show
+	userIDs := []string{}
+	for _, entry := range result.Entries {
+		userIDs = append(userIDs, entry.UserID)
+	}
+	names, err := a.store.DisplayNames(a.context, userIDs)
+	if err != nil {
+		return err
+	}
+
+	unit := ""
+	for _, s := range leaderboardStats {
+		if s.Name == stat {
+			unit = s.unit
+		}
+	}
+
+	lines := []string{fmt.Sprintf("** %s leaderboard: %s ** (page %d of %d)", strings.ToUpper(scope[:1])+scope[1:], stat, page, pages)}
+	onPage := false
+	for _, entry := range result.Entries {
+		name, ok := names[entry.UserID]
+		if !ok {
+			name = "somebody"
+		}
+		lines = append(lines, fmt.Sprintf("%d. %s - %d %s", entry.Rank, escapeMentions(name), entry.Score, unit))
+		onPage = onPage || entry.UserID == m.Author.ID
+	}
+
+	// Show the caller where they stand even when they're not on this page
+	if !onPage {
+		if result.Own != nil {
+			lines = append(lines, "", fmt.Sprintf("You're #%d with %d %s.", result.Own.Rank, result.Own.Score, unit))
+		} else {
+			lines = append(lines, "", "You're not on this leaderboard yet.")
+		}
+	}
+	if page < pages {
+		lines = append(lines, fmt.Sprintf("Type `%sleaderboard %s %s %d` for the next page.", args.Prefix, stat, scope, page+1))
+	}
+
+	return a.handleOutgoingMessage(m.RespondToChannelOrThread(a.Config.AppID, strings.Join(lines, "\n"), true, false))
+}
@@ -0,0 +1,224 @@
+package app
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+/*
+The Ledger
+
+Every change to a balance is written down as a ledger entry: who, by how much, why, and what it was about
+(a job ID, the other side of a payment, ...). The ledger is append-only, nothing ever edits or removes an entry.
+When somebody says money went missing, the ledger is where to look.
+
+Profiles don't write to the ledger themselves. Profile.adjustBalance changes the balance and remembers the entry,
+and the store writes the remembered entries in the same transaction that saves the profile. So an entry exists
+if and only if the balance change it describes was saved. The only way to change a balance is adjustBalance.
+
+Profiles that had money before the ledger existed get an "opening balance" entry when they're migrated,
+so the entries of every profile add up to its balance. !ledger reconcile checks that they do.
+*/
+
+const LEDGER_PREFIX = "ledger:" // Redis stream of ledger entries, one per user under "ledger:<user_id>"
+
+// Reasons for a balance change
+const (
+	LEDGER_OPENING      = "opening balance" // Balance a profile had before the ledger existed
+	LEDGER_LEGACY_MERGE = "legacy merge"    // Balance folded in from a legacy "profile::<id>" key or an old inventory balance
+	LEDGER_WORK         = "work"            // Paid for a !work shift
+	LEDGER_JOB          = "job"             // Paid for a job. Ref is the job ID.
+	LEDGER_JOB_FAILED   = "job failed"      // Penalty for failing a job. Ref is the job ID.
+	LEDGER_JOB_QUIT     = "job quit"        // Fee for quitting a job. Ref is the job ID.
+	LEDGER_JOB_REROLL   = "job reroll"      // Paid to reroll a job board. Ref is the day of the board.
+	LEDGER_PAY_SENT     = "pay sent"        // Sent with !pay. Ref is the recipient.
+	LEDGER_PAY_RECEIVED = "pay received"    // Received with !pay. Ref is the sender.
+	LEDGER_SHOP_BUY     = "shop buy"        // Spent in the shop. Ref is the item and quantity.
+	LEDGER_SHOP_SELL    = "shop sell"       // Sold to the shop. Ref is the item and quantity.
+)
+
+// ledgerHistorySize is how many entries !balance history shows.
+const ledgerHistorySize = 10
+
+// maxReconcileMismatches is how many mismatches !ledger reconcile lists before it just counts them.
+const maxReconcileMismatches = 20
+
+// LedgerEntry records a single change to a user's balance.
+type LedgerEntry struct {
+	ID      string    // Assigned by the store when the entry is written
+	UserID  string    // Whose balance changed
+	Delta   int       // How much it changed by
+	Balance int       // What the balance was afterwards
+	Reason  string    // Why it changed, one of the LEDGER_ constants
+	Ref     string    // What it was about, e.g. a job ID. Can be empty.
+	At      time.Time // When it changed
+}
+
+// The !ledger command tree. It's for admins chasing missing money.
+func init() {
+	commands.Register(&Command{
+		Name:      "ledger",
+		Group:     "Admin",
+		AdminOnly: true,
+		Summary:   "Audit the transaction ledger",
+		Help:      "Every balance change is recorded in the ledger. These commands check the ledger against the balances.",
+		Subcommands: []*Command{
+			{
+				Name:    "reconcile",
+				Summary: "Check that every balance matches the sum of its ledger entries",
+				Handler: handleLedgerReconcile,
+			},
+			helpSubcommand(),
+		},
+	})
+}
+
+// ledgerKey returns the Redis key for the ledger of the given user.
+func ledgerKey(userID string) string {
+	return LEDGER_PREFIX + userID
+}
+
+// adjustBalance changes the balance by delta and records why. The entry is written when the profile is saved,
+// so like every other profile change this has to happen inside updateProfile.
+// Money earned from work and jobs also counts towards the profile's lifetime earnings.
+func (p *Profile) adjustBalance(delta int, reason, ref string) {
+	p.Balance += delta
+	if delta > 0 && (reason == LEDGER_WORK || reason == LEDGER_JOB) {
+		p.Earned += delta
+	}
+	p.recordLedgerEntry(delta, reason, ref)
+}
+
+// recordLedgerEntry remembers a ledger entry for the balance the profile has now.
+func (p *Profile) recordLedgerEntry(delta int, reason, ref string) {
+	p.ledger = append(p.ledger, LedgerEntry{
+		UserID:  p.ID,
+		Delta:   delta,
+		Balance: p.Balance,
+		Reason:  reason,
+		Ref:     ref,
+		At:      time.Now(),
+	})
+}
+
+// String formats the entry for a human, e.g. "2022-10-17 12:00 +60 work (balance 160)".
+func (e LedgerEntry) String() string {
+	ref := ""
+	if e.Ref != "" {
+		ref = " [" + e.Ref + "]"
+	}
+	return fmt.Sprintf("%s %+d %s%s (balance %d)", e.At.UTC().Format("2006-01-02 15:04"), e.Delta, e.Reason, ref, e.Balance)
+}
+
+// ledgerMismatch is a profile whose balance doesn't match its ledger.
+type ledgerMismatch struct {
+	UserID  string
+	Balance int // Balance on the profile
+	Ledger  int // Sum of the ledger entries
+}
+
+// reconcileLedger compares the balance of every profile to the sum of its ledger entries.
+// Profiles that change while they're being checked can show up as false positives, so mismatches are checked twice.
+func (a *App) reconcileLedger() (int, []ledgerMismatch, error) {
+	userIDs, err := a.store.ProfileIDs(a.context)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	mismatches := []ledgerMismatch{}
+	for _, userID := range userIDs {
+		mismatch, err := a.checkLedger(userID)
+		if err != nil {
+			return 0, nil, err
+		}
+		if mismatch == nil {
+			continue
+		}
+		if mismatch, err = a.checkLedger(userID); err != nil {
+			return 0, nil, err
+		}
+		if mismatch != nil {
+			mismatches = append(mismatches, *mismatch)
+		}
+	}
+	return len(userIDs), mismatches, nil
+}
+
+// checkLedger compares the balance of a single profile to the sum of its ledger entries.
+// It returns nil if they match.
+func (a *App) checkLedger(userID string) (*ledgerMismatch, error) {
+	profile, err := a.getProfile(userID)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := a.store.LedgerEntries(a.context, userID, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	// Profiles that haven't been saved since the ledger started carry their opening balance entry
+	// from the migration instead. It gets written the next time they're saved.
+	sum := 0
+	for _, entry := range append(entries, profile.ledger...) {
+		sum += entry.Delta
+	}
+	if sum == profile.Balance {
+		return nil, nil
+	}
+	return &ledgerMismatch{UserID: userID, Balance: profile.Balance, Ledger: sum}, nil
+}
+
+// handleBalanceHistory handles the !balance history command. It shows the user's most recent ledger entries.
+func handleBalanceHistory(a *App, m *Message, args *Args) error {
+	entries, err := a.store.LedgerEntries(a.context, m.Author.ID, ledgerHistorySize)
+	if err != nil {
+		return err
+	}
+	if len(entries) == 0 {
+		msg := fmt.Sprintf("Your balance has never changed. Try `%swork`.", args.Prefix)
+		return a.handleOutgoingMessage(m.RespondToChannelOrThread(a.Config.AppID, msg, true, false))
+	}
+
+	lines := []string{"** Recent transactions **"}
+	for _, entry := range entries {
+		lines = append(lines, "- "+entry.String())
+	}
+	return a.handleOutgoingMessage(m.RespondToChannelOrThread(a.Config.AppID, strings.Join(lines, "\n"), true, false))
+}
+
+// handleLedgerReconcile handles the !ledger reconcile command.
+func handleLedgerReconcile(a *App, m *Message, args *Args) error {
+	checked, mismatches, err := a.reconcileLedger()
+	if err != nil {
+		return err
+	}
+
+	a.logger.Info().
+		Str("user", m.Author.Username).
+		Int("checked", checked).
+		Int("mismatches", len(mismatches)).
+		Msg("ledger reconciled")
+
+	if len(mismatches) == 0 {
+		msg := fmt.Sprintf("Checked %d profiles. Every balance matches its ledger.", checked)
+		return a.handleOutgoingMessage(m.RespondToChannelOrThread(a.Config.AppID, msg, true, false))
+	}
+
+	lines := []string{fmt.Sprintf("Checked %d profiles. %d don't match their ledger:", checked, len(mismatches))}
+	for i, mismatch := range mismatches {
+		if i == maxReconcileMismatches {
+			lines = append(lines, fmt.Sprintf("...and %d more. Check the logs.", len(mismatches)-i))
+			break
+		}
+		lines = append(lines, fmt.Sprintf("- <@%s>: balance %d, ledger %d", mismatch.UserID, mismatch.Balance, mismatch.Ledger))
+	}
+	for _, mismatch := range mismatches {
+		a.logger.Warn().
+			Str("user", mismatch.UserID).
+			Int("balance", mismatch.Balance).
+			Int("ledger", mismatch.Ledger).
+			Msg("balance does not match ledger")
+	}
+	return a.handleOutgoingMessage(m.RespondToChannelOrThread(a.Config.AppID, strings.Join(lines, "\n"), true, false))
+}
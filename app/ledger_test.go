@@ -0,0 +1,61 @@
+package app
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestReconcileLedger(t *testing.T) {
+	a, transport := newTestApp(t)
+	store := a.store.(*memoryStore)
+
+	// Balances that only ever changed through adjustBalance match their ledger
+	setBalance(t, a, "1", 100)
+	setBalance(t, a, "1", 30)
+	if _, err := a.updateProfile("2", func(p *Profile) error { return nil }); err != nil {
+		t.Fatal(err)
+	}
+
+	// A profile from before the ledger gets its opening balance from the migration, even before it's saved again
+	store.profiles["3"] = []byte(`{"id": "3", "balance": 40}`)
+
+	// Money that appeared out of nowhere doesn't
+	if _, err := a.updateProfile("4", func(p *Profile) error {
+		p.adjustBalance(10, LEDGER_WORK, "")
+		p.Balance += 5
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	checked, mismatches, err := a.reconcileLedger()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if checked != 4 {
+		t.Errorf("checked %d profiles, want 4", checked)
+	}
+	want := []ledgerMismatch{{UserID: "4", Balance: 15, Ledger: 10}}
+	if !reflect.DeepEqual(mismatches, want) {
+		t.Errorf("mismatches %+v, want %+v", mismatches, want)
+	}
+
+	// Once the old profile is saved, its opening balance is in the ledger for good
+	if _, err := a.updateProfile("3", func(p *Profile) error { return nil }); err != nil {
+		t.Fatal(err)
+	}
+	entries, err := a.store.LedgerEntries(a.context, "3", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Reason != LEDGER_OPENING || entries[0].Delta != 40 {
+		t.Errorf("ledger %+v, want the opening balance", entries)
+	}
+	if mismatch, err := a.checkLedger("3"); err != nil || mismatch != nil {
+		t.Errorf("checkLedger = %+v, %v, want a match", mismatch, err)
+	}
+
+	if replies := transport.replies(); len(replies) != 0 {
+		t.Errorf("said %q while reconciling", replies)
+	}
+}
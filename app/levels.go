@@ -0,0 +1,205 @@
+package app
+
+import (
+	"fmt"
+	"math"
+	"strings"
+)
+
+/*
+Levels
+
+Users earn XP for putting in the work: every shift of !work, every job that pays out and, a little less, every job that
+goes wrong. Anything else that should teach people something can hand out XP with Profile.gainXP.
+
+A user's level follows from their XP along a curve: getting from level 1 to 2 takes LevelBaseXP, and every level after
+that takes LevelGrowthPercent more than the one before, up to MaxLevel. Only the XP is stored, so changing the curve
+moves everybody to wherever their XP puts them on the new one.
+
+Levels are what jobs scale with. Every level above 1 makes new jobs pay more and take longer, and every JobTierLevels
+levels unlock a better tier of job that pays a lot more for the trouble. See generateJobs.
+
+Levelling up is announced in the channel it happened in, or the server's notify-channel if it has one.
+*/
+
+// maxMaxLevel is the highest max-level the config allows. Past it the XP a level takes stops fitting in an int.
+const maxMaxLevel = 100
+
+// levelBarWidth is how many characters the progress bar of !level has.
+const levelBarWidth = 20
+
+// jobTier is a grade of job. Better tiers pay more and take longer, and unlock as users level up.
+type jobTier struct {
+	Name            string // What to call jobs of the tier
+	PayoutPercent   int    // What they pay, as a percentage of a plain job's payout
+	DurationPercent int    // How long they take, as a percentage of a plain job's
+}
+
+// jobTiers lists the tiers of jobs from worst to best. A job's Tier is its place in this list, starting at 1.
+var jobTiers = []jobTier{
+	{Name: "Odd job", PayoutPercent: 100, DurationPercent: 100},
+	{Name: "Contract", PayoutPercent: 150, DurationPercent: 125},
+	{Name: "Operation", PayoutPercent: 225, DurationPercent: 150},
+	{Name: "Heist", PayoutPercent: 350, DurationPercent: 200},
+}
+
+// The !level command
+func init() {
+	commands.Register(&Command{
+		Name:    "level",
+		Aliases: []string{"lvl", "xp"},
+		Group:   "General",
+		Summary: "See your level and how far it is to the next one",
+		Help: "You earn XP from working shifts and doing jobs, even the ones that go wrong. Enough XP and you level up.\n" +
+			"The higher your level, the more jobs pay, and every few levels you unlock a better kind of job.",
+		Args: []ArgSpec{
+			{Name: "user", Description: "Whose level to see. Yours if you leave it out.", Type: ArgUser, Optional: true},
+		},
+		Examples: []string{"level", "level @somebody"},
+		Handler:  handleLevelCommand,
+		Subcommands: []*Command{
+			helpSubcommand(),
+		},
+	})
+}
+
+// xpToLevelUp returns how much XP it takes to get from the given level to the next one.
+func xpToLevelUp(e EconomyConfig, level int) int {
+	xp := float64(e.LevelBaseXP) * math.Pow(1+float64(e.LevelGrowthPercent)/100, float64(level-1))
+	if xp > math.MaxInt32 {
+		return math.MaxInt32
+	}
+	return int(xp)
+}
+
+// levelProgress works out the level xp puts a user at, how much XP they have into it and how much the next level
+// takes in total. needed is zero at the max level.
+func levelProgress(e EconomyConfig, xp int) (level, into, needed int) {
+	level = 1
+	for level < e.MaxLevel {
+		needed = xpToLevelUp(e, level)
+		if xp < needed {
+			return level, xp, needed
+		}
+		xp -= needed
+		level++
+	}
+	return level, xp, 0
+}
+
+// level returns the user's level.
+func (p *Profile) level(e EconomyConfig) int {
+	level, _, _ := levelProgress(e, p.XP)
+	return level
+}
+
+// gainXP gives the user some XP and returns how many levels that got them, usually none.
+// Like every other profile change it belongs inside updateProfile.
+func (p *Profile) gainXP(e EconomyConfig, xp int) int {
+	if xp <= 0 {
+		return 0
+	}
+	before := p.level(e)
+	p.XP += xp
+	return p.level(e) - before
+}
+
+// unlockedJobTiers returns how many tiers of jobs are open to a user at the given level.
+func unlockedJobTiers(e EconomyConfig, level int) int {
+	tiers := 1 + (level-1)/e.JobTierLevels
+	if tiers > len(jobTiers) {
+		return len(jobTiers)
+	}
+	return tiers
+}
+
+// tierOf returns the tier of a job. Jobs from before there were tiers are odd jobs.
+func tierOf(j *JobInfo) jobTier {
+	if j.Tier < 1 || j.Tier > len(jobTiers) {
+		return jobTiers[0]
+	}
+	return jobTiers[j.Tier-1]
+}
+
+// levelScale grows amount by percent for every level above 1.
+func levelScale(amount, percent, level int) int {
+	return amount * (100 + percent*(level-1)) / 100
+}
+
+// announceLevelUp tells everybody that a user went up some levels to reach level. gatewayID, guildID and channelID are
+// where they got there.
+func (a *App) announceLevelUp(gatewayID, guildID, channelID, userID string, level, gained int) error {
+	channelID, err := a.notifyChannel(guildID, channelID)
+	if err != nil {
+		return err
+	}
+
+	e := a.Config.Economy
+	msg := fmt.Sprintf("<@%s> is now level %d!", userID, level)
+	if tiers := unlockedJobTiers(e, level); tiers > unlockedJobTiers(e, level-gained) {
+		msg += fmt.Sprintf(" %s jobs are open to them now.", jobTiers[tiers-1].Name)
+	} else if level < e.MaxLevel {
+		msg += " Jobs pay a little better from here on."
+	}
+	if level == e.MaxLevel {
+		msg += " That's as high as it goes."
+	}
+	return a.SendToChannel(gatewayID, channelID, msg)
+}
+
+// logLevelUpError logs an announcement that didn't make it. The XP is saved either way, so it isn't worth failing over.
+func (a *App) logLevelUpError(err error, userID string, level int) {
+	a.logger.Error().
+		Err(err).
+		Str("user", userID).
+		Int("level", level).
+		Msg("Failed to announce level up")
+}
+
+// handleLevelCommand handles the !level command.
+func handleLevelCommand(a *App, m *Message, args *Args) error {
+	userID := m.Author.ID
+	whose := "You're"
+	if args.Has("user") && args.User("user") != m.Author.ID {
+		userID = args.User("user")
+		whose = fmt.Sprintf("<@%s> is", userID)
+	}
+
+	// Looking somebody up doesn't sign them up for the game
+	var profile *Profile
+	var err error
+	if userID == m.Author.ID {
+		profile, err = a.getProfile(userID)
+	} else {
+		profile, err = a.findProfile(userID)
+	}
+	if err != nil {
+		return err
+	}
+	if profile == nil {
+		msg := fmt.Sprintf("<@%s> hasn't played yet, so there's no level to show.", userID)
+		return a.handleOutgoingMessage(m.RespondToChannelOrThread(a.Config.AppID, msg, true, false))
+	}
+
+	e := a.Config.Economy
+	level, into, needed := levelProgress(e, profile.XP)
+	lines := []string{fmt.Sprintf("%s level %d, with %d XP all told.", whose, level, profile.XP)}
+	if needed > 0 {
+		filled := into * levelBarWidth / needed
+		bar := strings.Repeat("#", filled) + strings.Repeat("-", levelBarWidth-filled)
+		lines = append(lines, fmt.Sprintf("`[%s]` %d/%d XP to level %d", bar, into, needed, level+1))
+	} else {
+		lines = append(lines, "That's the max level. There's nowhere left to go.")
+	}
+
+	tiers := unlockedJobTiers(e, level)
+	names := make([]string, 0, tiers)
+	for _, tier := range jobTiers[:tiers] {
+		names = append(names, tier.Name+"s")
+	}
+	lines = append(lines, fmt.Sprintf("Jobs pay %d%% more at this level. Open jobs: %s.", e.JobLevelPayoutPercent*(level-1), strings.Join(names, ", ")))
+	if tiers < len(jobTiers) {
+		lines = append(lines, fmt.Sprintf("%ss open up at level %d.", jobTiers[tiers].Name, tiers*e.JobTierLevels+1))
+	}
+	return a.handleOutgoingMessage(m.RespondToChannelOrThread(a.Config.AppID, strings.Join(lines, "\n"), true, false))
+}
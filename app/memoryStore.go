@@ -0,0 +1,521 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// memoryStore keeps the game state in memory. Everything is lost when the process exits.
+// Values are kept as JSON so callers can never hold a pointer into the store, the same as with Redis.
+type memoryStore struct {
+	mu        sync.Mutex
+	profiles  map[string][]byte
+	guilds    map[string][]byte
+	cooldowns map[string]map[string]int64 // user ID to action to expiry in unix milliseconds
+	strikes   map[string]*memoryStrikes   // cooldownStrikesKey to strikes
+	confirms  map[string]*memoryConfirmation
+	ledgers   map[string][]LedgerEntry   // Oldest first
+	ledgerSeq int64                      // Last ledger entry ID handed out
+	names     map[string]string          // user ID to display name
+	members   map[string]map[string]bool // guild ID to the user IDs seen in it
+	dms       map[string]string          // dmChannelField to the channel of the user's DMs with the bot
+	tasks     map[string]*memoryTask
+}
+
+// memoryTask is a task plus the time it's next visible to ClaimDueTasks.
+type memoryTask struct {
+	task      []byte
+	visibleAt int64 // unix milliseconds
+}
+
+// memoryStrikes counts strikes against a cooldown until it expires.
+type memoryStrikes struct {
+	count     int64
+	expiresAt time.Time
+}
+
+// memoryConfirmation is a pending confirmation plus when it expires.
+type memoryConfirmation struct {
+	payload   []byte
+	expiresAt time.Time
+}
+
+// NewMemoryStore creates an empty in-memory store.
+func NewMemoryStore() Store {
+	return &memoryStore{
+		profiles:  map[string][]byte{},
+		guilds:    map[string][]byte{},
+		cooldowns: map[string]map[string]int64{},
+		strikes:   map[string]*memoryStrikes{},
+		confirms:  map[string]*memoryConfirmation{},
+		ledgers:   map[string][]LedgerEntry{},
+		names:     map[string]string{},
+		members:   map[string]map[string]bool{},
+		dms:       map[string]string{},
+		tasks:     map[string]*memoryTask{},
+	}
+}
+
+// GetProfile gets the profile for the given user ID.
+// If the profile does not exist, it will be created.
+func (s *memoryStore) GetProfile(ctx context.Context, userID string) (*Profile, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.loadProfile(userID)
+}
+
+// FindProfile gets the profile for the given user ID, or nil if there isn't one.
+func (s *memoryStore) FindProfile(ctx context.Context, userID string) (*Profile, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.profiles[userID]; !ok {
+		return nil, nil
+	}
+	return s.loadProfile(userID)
+}
+
+// UpdateProfile holds the store lock for the whole read-modify-write, so there's nothing to retry.
+func (s *memoryStore) UpdateProfile(ctx context.Context, userID string, fn func(*Profile) error) (*Profile, error) {
+	profiles, err := s.UpdateProfiles(ctx, []string{userID}, func(profiles []*Profile) error {
+		return fn(profiles[0])
+	})
+	if err != nil {
+		return nil, err
+	}
+	return profiles[0], nil
+}
+
+// UpdateProfiles holds the store lock for the whole read-modify-write of every profile.
+func (s *memoryStore) UpdateProfiles(ctx context.Context, userIDs []string, fn func([]*Profile) error) ([]*Profile, error) {
+	if _, err := profileKeys(userIDs); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	profiles := make([]*Profile, len(userIDs))
+	for i, userID := range userIDs {
+		profile, err := s.loadProfile(userID)
+		if err != nil {
+			return nil, err
+		}
+		profiles[i] = profile
+	}
+
+	if err := fn(profiles); err != nil {
+		return nil, err
+	}
+
+	// Marshal everything before saving anything, so a failure doesn't leave half the profiles changed
+	profileBytes := make([][]byte, len(profiles))
+	for i, profile := range profiles {
+		b, err := json.Marshal(profile)
+		if err != nil {
+			return nil, err
+		}
+		profileBytes[i] = b
+	}
+	for i, userID := range userIDs {
+		s.profiles[userID] = profileBytes[i]
+		s.appendLedger(profiles[i])
+	}
+
+	return profiles, nil
+}
+
+// loadProfile unmarshals the stored profile, creating it if it doesn't exist. The caller must hold the lock.
+func (s *memoryStore) loadProfile(userID string) (*Profile, error) {
+	profileBytes, ok := s.profiles[userID]
+	if !ok {
+		profile := newProfile(userID)
+		profileBytes, err := json.Marshal(profile)
+		if err != nil {
+			return nil, err
+		}
+		s.profiles[userID] = profileBytes
+		return profile, nil
+	}
+
+	var profile Profile
+	if err := json.Unmarshal(profileBytes, &profile); err != nil {
+		return nil, err
+	}
+	if _, err := migrateProfile(&profile); err != nil {
+		return nil, err
+	}
+	return &profile, nil
+}
+
+// ProfileIDs lists the stored profiles.
+func (s *memoryStore) ProfileIDs(ctx context.Context) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	userIDs := make([]string, 0, len(s.profiles))
+	for userID := range s.profiles {
+		userIDs = append(userIDs, userID)
+	}
+	return userIDs, nil
+}
+
+// appendLedger writes the ledger entries a profile has been holding on to. The caller must hold the lock.
+func (s *memoryStore) appendLedger(p *Profile) {
+	for _, entry := range p.ledger {
+		s.ledgerSeq++
+		entry.ID = strconv.FormatInt(s.ledgerSeq, 10)
+		s.ledgers[p.ID] = append(s.ledgers[p.ID], entry)
+	}
+}
+
+// LedgerEntries returns the user's ledger entries, newest first.
+func (s *memoryStore) LedgerEntries(ctx context.Context, userID string, limit int) ([]LedgerEntry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ledger := s.ledgers[userID]
+	entries := make([]LedgerEntry, 0, len(ledger))
+	for i := len(ledger) - 1; i >= 0; i-- {
+		if limit > 0 && len(entries) == limit {
+			break
+		}
+		entries = append(entries, ledger[i])
+	}
+	return entries, nil
+}
+
+// Leaderboard ranks the stored profiles on the fly. Ties are broken the same way Redis breaks them.
+func (s *memoryStore) Leaderboard(ctx context.Context, query LeaderboardQuery) (*LeaderboardPage, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var score func(p *Profile) int
+	for _, stat := range leaderboardStats {
+		if stat.Name == query.Stat {
+			score = stat.score
+		}
+	}
+	if score == nil {
+		return nil, fmt.Errorf("no leaderboard for %q", query.Stat)
+	}
+
+	board := []LeaderboardEntry{}
+	for userID := range s.profiles {
+		if query.GuildID != "" && !s.members[query.GuildID][userID] {
+			continue
+		}
+		profile, err := s.loadProfile(userID)
+		if err != nil {
+			return nil, err
+		}
+		board = append(board, LeaderboardEntry{UserID: userID, Score: score(profile)})
+	}
+	sort.Slice(board, func(i, j int) bool {
+		if board[i].Score != board[j].Score {
+			return board[i].Score > board[j].Score
+		}
+		return board[i].UserID > board[j].UserID
+	})
+
+	page := &LeaderboardPage{Total: len(board), Entries: []LeaderboardEntry{}}
+	for i := range board {
+		board[i].Rank = i + 1
+		if board[i].UserID == query.UserID {
+			own := board[i]
+			page.Own = &own
+		}
+		if i >= query.Offset && i < query.Offset+query.Limit {
+			page.Entries = append(page.Entries, board[i])
+		}
+	}
+	return page, nil
+}
+
+// RememberUser stores the user's name and adds them to the guild's members.
+func (s *memoryStore) RememberUser(ctx context.Context, guildID, userID, name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.names[userID] = name
+	if guildID == "" {
+		return nil
+	}
+	if s.members[guildID] == nil {
+		s.members[guildID] = map[string]bool{}
+	}
+	s.members[guildID][userID] = true
+	return nil
+}
+
+// DisplayNames looks up the names of the users.
+func (s *memoryStore) DisplayNames(ctx context.Context, userIDs []string) (map[string]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	names := map[string]string{}
+	for _, userID := range userIDs {
+		if name, ok := s.names[userID]; ok {
+			names[userID] = name
+		}
+	}
+	return names, nil
+}
+
+// RememberDMChannel stores the channel of the user's DMs with the bot on the gateway.
+func (s *memoryStore) RememberDMChannel(ctx context.Context, gatewayID, userID, channelID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.dms[dmChannelField(gatewayID, userID)] = channelID
+	return nil
+}
+
+// DMChannel looks up the channel of the user's DMs with the bot on the gateway.
+func (s *memoryStore) DMChannel(ctx context.Context, gatewayID, userID string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.dms[dmChannelField(gatewayID, userID)], nil
+}
+
+// GetGuildSettings gets the settings for the given guild, or the defaults if it has none.
+func (s *memoryStore) GetGuildSettings(ctx context.Context, guildID string) (*GuildSettings, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	settings := defaultGuildSettings(guildID)
+	settingsBytes, ok := s.guilds[guildID]
+	if !ok {
+		return settings, nil
+	}
+	if err := json.Unmarshal(settingsBytes, settings); err != nil {
+		return nil, err
+	}
+	return settings, nil
+}
+
+// UpdateGuildSettings changes the settings for a guild with fn, holding the lock the whole time.
+func (s *memoryStore) UpdateGuildSettings(ctx context.Context, guildID string, fn func(*GuildSettings) error) (*GuildSettings, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	settings := defaultGuildSettings(guildID)
+	if settingsBytes, ok := s.guilds[guildID]; ok {
+		if err := json.Unmarshal(settingsBytes, settings); err != nil {
+			return nil, err
+		}
+	}
+	if err := fn(settings); err != nil {
+		return nil, err
+	}
+	settingsBytes, err := json.Marshal(settings)
+	if err != nil {
+		return nil, err
+	}
+	s.guilds[guildID] = settingsBytes
+	return settings, nil
+}
+
+// StartCooldown starts a cooldown unless one is running.
+func (s *memoryStore) StartCooldown(ctx context.Context, userID, action string, now time.Time, cooldown time.Duration) (time.Duration, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if expiresAt, ok := s.cooldowns[userID][action]; ok && expiresAt > now.UnixMilli() {
+		return time.Duration(expiresAt-now.UnixMilli()) * time.Millisecond, nil
+	}
+	if s.cooldowns[userID] == nil {
+		s.cooldowns[userID] = map[string]int64{}
+	}
+	s.cooldowns[userID][action] = now.Add(cooldown).UnixMilli()
+	return 0, nil
+}
+
+// ClearCooldown removes the cooldown.
+func (s *memoryStore) ClearCooldown(ctx context.Context, userID, action string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.cooldowns[userID], action)
+	return nil
+}
+
+// Cooldowns returns the user's cooldowns that haven't expired yet, dropping the ones that have.
+func (s *memoryStore) Cooldowns(ctx context.Context, userID string, now time.Time) ([]Cooldown, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	cooldowns := []Cooldown{}
+	for action, expiresAt := range s.cooldowns[userID] {
+		if expiresAt <= now.UnixMilli() {
+			delete(s.cooldowns[userID], action)
+			continue
+		}
+		cooldowns = append(cooldowns, Cooldown{Action: action, ExpiresAt: time.UnixMilli(expiresAt)})
+	}
+	return cooldowns, nil
+}
+
+// StrikeCooldown counts a strike, starting over if the last cooldown's strikes expired.
+func (s *memoryStore) StrikeCooldown(ctx context.Context, userID, action string, expiresAt time.Time) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := cooldownStrikesKey(userID, action)
+	strikes, ok := s.strikes[key]
+	if !ok || !strikes.expiresAt.After(time.Now()) {
+		strikes = &memoryStrikes{}
+		s.strikes[key] = strikes
+	}
+	strikes.count++
+	strikes.expiresAt = expiresAt
+	return strikes.count, nil
+}
+
+// SetConfirmation stores the confirmation with an expiry.
+func (s *memoryStore) SetConfirmation(ctx context.Context, userID, action string, payload []byte, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.confirms[confirmationKey(userID, action)] = &memoryConfirmation{payload: payload, expiresAt: time.Now().Add(ttl)}
+	return nil
+}
+
+// TakeConfirmation removes and returns the confirmation if it hasn't expired.
+func (s *memoryStore) TakeConfirmation(ctx context.Context, userID, action string) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := confirmationKey(userID, action)
+	confirmation, ok := s.confirms[key]
+	delete(s.confirms, key)
+	if !ok || time.Now().After(confirmation.expiresAt) {
+		return nil, nil
+	}
+	return confirmation.payload, nil
+}
+
+// ScheduleTask stores the task, replacing any task with the same ID.
+func (s *memoryStore) ScheduleTask(ctx context.Context, task *Task) error {
+	taskBytes, err := json.Marshal(task)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tasks[task.ID] = &memoryTask{task: taskBytes, visibleAt: task.DueAt}
+	return nil
+}
+
+// HasTask looks the task up.
+func (s *memoryStore) HasTask(ctx context.Context, id string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := s.tasks[id]
+	return ok, nil
+}
+
+// CancelTask removes the task.
+func (s *memoryStore) CancelTask(ctx context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.tasks, id)
+	return nil
+}
+
+// ClaimDueTasks returns the earliest due tasks and hides them until the lease runs out.
+func (s *memoryStore) ClaimDueTasks(ctx context.Context, now time.Time, lease time.Duration, limit int) ([]*Task, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	due := []*memoryTask{}
+	for _, t := range s.tasks {
+		if t.visibleAt <= now.UnixMilli() {
+			due = append(due, t)
+		}
+	}
+	sort.Slice(due, func(i, j int) bool {
+		return due[i].visibleAt < due[j].visibleAt
+	})
+	if len(due) > limit {
+		due = due[:limit]
+	}
+
+	tasks := make([]*Task, 0, len(due))
+	for _, t := range due {
+		var task Task
+		if err := json.Unmarshal(t.task, &task); err != nil {
+			return nil, err
+		}
+		t.visibleAt = now.Add(lease).UnixMilli()
+		tasks = append(tasks, &task)
+	}
+	return tasks, nil
+}
+
+// FinishTask removes the task unless it was replaced since it was claimed.
+func (s *memoryStore) FinishTask(ctx context.Context, task *Task) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	current, ok := s.tasks[task.ID]
+	if !ok {
+		return nil
+	}
+
+	var stored Task
+	if err := json.Unmarshal(current.task, &stored); err != nil {
+		return err
+	}
+	if stored.equal(task) {
+		delete(s.tasks, task.ID)
+	}
+	return nil
+}
+
+// PendingTasks counts the stored tasks.
+func (s *memoryStore) PendingTasks(ctx context.Context) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return int64(len(s.tasks)), nil
+}
+
+// MigrateProfiles upgrades every profile to the current schema version.
+// The memory store never had legacy keys, so there is nothing to merge.
+func (s *memoryStore) MigrateProfiles(ctx context.Context, dryRun bool) (*MigrationReport, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	report := &MigrationReport{DryRun: dryRun}
+	for userID, profileBytes := range s.profiles {
+		report.Scanned++
+
+		var profile Profile
+		if err := json.Unmarshal(profileBytes, &profile); err != nil {
+			return report, err
+		}
+		migrated, err := migrateProfile(&profile)
+		if err != nil {
+			return report, err
+		}
+		if !migrated {
+			continue
+		}
+		report.Upgraded = append(report.Upgraded, userID)
+
+		if dryRun {
+			continue
+		}
+		profileBytes, err := json.Marshal(&profile)
+		if err != nil {
+			return report, err
+		}
+		s.profiles[userID] = profileBytes
+		s.appendLedger(&profile)
+	}
+	return report, nil
+}
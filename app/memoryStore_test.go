@@ -0,0 +1,125 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestMemoryStoreUpdateProfiles(t *testing.T) {
+	ctx := context.Background()
+	s := NewMemoryStore()
+	if _, err := s.UpdateProfile(ctx, "1", func(p *Profile) error {
+		p.adjustBalance(100, LEDGER_WORK, "")
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	profiles, err := s.UpdateProfiles(ctx, []string{"1", "2"}, func(profiles []*Profile) error {
+		if profiles[0].ID != "1" || profiles[1].ID != "2" {
+			t.Errorf("got profiles %s and %s, want them in the order asked for", profiles[0].ID, profiles[1].ID)
+		}
+		profiles[0].adjustBalance(-40, LEDGER_PAY_SENT, "2")
+		profiles[1].adjustBalance(40, LEDGER_PAY_RECEIVED, "1")
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if profiles[0].Balance != 60 || profiles[1].Balance != 40 {
+		t.Errorf("returned balances %d and %d, want 60 and 40", profiles[0].Balance, profiles[1].Balance)
+	}
+	for userID, want := range map[string]int{"1": 60, "2": 40} {
+		p, err := s.GetProfile(ctx, userID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if p.Balance != want {
+			t.Errorf("saved balance of %s is %d, want %d", userID, p.Balance, want)
+		}
+	}
+
+	entries, err := s.LedgerEntries(ctx, "2", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Delta != 40 || entries[0].Reason != LEDGER_PAY_RECEIVED || entries[0].ID == "" {
+		t.Errorf("ledger %+v, want the payment", entries)
+	}
+}
+
+func TestMemoryStoreUpdateProfilesAllOrNothing(t *testing.T) {
+	ctx := context.Background()
+	s := NewMemoryStore()
+	if _, err := s.UpdateProfile(ctx, "1", func(p *Profile) error {
+		p.adjustBalance(100, LEDGER_WORK, "")
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	failed := errors.New("failed")
+	_, err := s.UpdateProfiles(ctx, []string{"1", "2"}, func(profiles []*Profile) error {
+		profiles[0].adjustBalance(-40, LEDGER_PAY_SENT, "2")
+		profiles[1].adjustBalance(40, LEDGER_PAY_RECEIVED, "1")
+		return failed
+	})
+	if err != failed {
+		t.Fatalf("UpdateProfiles = %v, want %v", err, failed)
+	}
+
+	// Loading a profile creates it, so the recipient exists now, but nothing the update did was kept
+	for userID, want := range map[string]int{"1": 100, "2": 0} {
+		p, err := s.GetProfile(ctx, userID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if p.Balance != want {
+			t.Errorf("saved balance of %s is %d, want %d", userID, p.Balance, want)
+		}
+	}
+	entries, err := s.LedgerEntries(ctx, "2", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("ledger %+v, want the failed update left out", entries)
+	}
+}
+
+func TestMemoryStoreUpdateProfilesDuplicate(t *testing.T) {
+	called := false
+	_, err := NewMemoryStore().UpdateProfiles(context.Background(), []string{"1", "1"}, func(profiles []*Profile) error {
+		called = true
+		return nil
+	})
+	if err != ErrDuplicateProfile {
+		t.Errorf("UpdateProfiles = %v, want %v", err, ErrDuplicateProfile)
+	}
+	if called {
+		t.Error("fn was called with the same profile twice")
+	}
+}
+
+func TestMemoryStoreProfilesAreCopies(t *testing.T) {
+	ctx := context.Background()
+	s := NewMemoryStore()
+	p, err := s.UpdateProfile(ctx, "1", func(p *Profile) error {
+		p.adjustBalance(10, LEDGER_WORK, "")
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Changing a profile outside of an update must not change what's stored, the same as with Redis
+	p.Balance = 1000
+	stored, err := s.GetProfile(ctx, "1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.Balance != 10 {
+		t.Errorf("stored balance %d, want 10", stored.Balance)
+	}
+}
@@ -0,0 +1,177 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+)
+
+/*
+Profile Migrations
+
+Every profile carries the schema version it was written with. When a profile is loaded with an older
+version, the migrations between its version and the current one are applied in order before anybody
+gets to look at it. The upgraded profile is written back the next time it's updated.
+
+To change the shape of a profile, append a migration to profileMigrations. Never edit or reorder the
+existing ones, profiles in the wild may still need them.
+
+Some problems can't be fixed one profile at a time, like the orphaned "profile::<id>" keys that !work
+used to write to. Those are handled by MigrateProfiles, which walks the whole store.
+*/
+
+// profileMigrations holds the migrations between schema versions. profileMigrations[n] takes a profile from version n to n+1.
+var profileMigrations = []func(p *Profile) error{
+	// 0 -> 1: profiles written before versioning. Nothing changes, they just get a version.
+	func(p *Profile) error { return nil },
+	// 1 -> 2: the ledger starts. Whatever the profile already had becomes its opening balance.
+	func(p *Profile) error {
+		if p.Balance != 0 {
+			p.recordLedgerEntry(p.Balance, LEDGER_OPENING, "")
+		}
+		return nil
+	},
+	// 2 -> 3: profiles start counting completed jobs and lifetime earnings, both from zero.
+	// Saving a profile also puts it on the leaderboards, so this is what gets older profiles onto them.
+	func(p *Profile) error { return nil },
+	// 3 -> 4: inventories stop having a balance of their own. Nothing ever used it, but if one somehow has money in it
+	// the same rule as legacy profiles applies: the larger balance wins.
+	func(p *Profile) error {
+		legacy := p.Inventory.LegacyBalance
+		p.Inventory.LegacyBalance = 0
+		if delta := mergeLegacyBalance(p.Balance, legacy) - p.Balance; delta != 0 {
+			p.adjustBalance(delta, LEDGER_LEGACY_MERGE, "inventory balance")
+		}
+		return nil
+	},
+	// 4 -> 5: demerits move from a bare count in the inventory to a record on the profile. Nothing ever gave any out,
+	// but any that are there become demerits without a known reason that expire a week from now.
+	func(p *Profile) error {
+		now := time.Now()
+		for i := 0; i < p.Inventory.LegacyDemerits; i++ {
+			p.Demerits = append(p.Demerits, Demerit{
+				Reason:    DEMERIT_LEGACY,
+				Note:      "on your record from before demerits had reasons",
+				IssuedAt:  now,
+				ExpiresAt: now.Add(legacyDemeritExpiry),
+			})
+		}
+		p.Inventory.LegacyDemerits = 0
+		return nil
+	},
+	// 5 -> 6: jobs get states instead of a completed flag, and their work time is kept apart from when the offer expires.
+	// Old jobs started the clock when they were offered, so that's the best guess for when they were taken.
+	// Unfinished jobs were paid by a goroutine that died with the process, so the ones that are overdue are paid here,
+	// in full like that goroutine would have. The rest stay active and get a completion task on startup (see recoverJobs).
+	func(p *Profile) error {
+		j := p.ActiveJob.Info()
+		if uuid.Equal(j.ID, uuid.Nil) {
+			return nil
+		}
+		j.WorkTime = j.LegacyExpiresAt - j.CreatedAt
+		j.StartedAt, j.DueAt = j.CreatedAt, j.LegacyExpiresAt
+		j.State = JOB_ACTIVE
+		switch {
+		case j.LegacyCompleted:
+			j.State, j.FinishedAt = JOB_SUCCEEDED, j.LegacyExpiresAt
+		case j.DueAt <= time.Now().Unix():
+			j.State, j.FinishedAt, j.Paid = JOB_SUCCEEDED, j.DueAt, j.Payout
+			p.adjustBalance(j.Payout, LEDGER_JOB, j.ID.String())
+			p.JobsCompleted++
+		}
+		j.LegacyExpiresAt, j.LegacyCompleted = 0, false
+		return nil
+	},
+}
+
+// profileSchemaVersion returns the schema version newly written profiles get.
+func profileSchemaVersion() int {
+	return len(profileMigrations)
+}
+
+// migrateProfile brings a profile up to the current schema version.
+// It returns whether anything was applied.
+func migrateProfile(p *Profile) (bool, error) {
+	if p.SchemaVersion > profileSchemaVersion() {
+		return false, fmt.Errorf("profile %s has schema version %d, newer than this build understands (%d)", p.ID, p.SchemaVersion, profileSchemaVersion())
+	}
+
+	migrated := false
+	for p.SchemaVersion < profileSchemaVersion() {
+		if err := profileMigrations[p.SchemaVersion](p); err != nil {
+			return migrated, fmt.Errorf("failed to migrate profile %s from schema version %d: %w", p.ID, p.SchemaVersion, err)
+		}
+		p.SchemaVersion++
+		migrated = true
+	}
+	return migrated, nil
+}
+
+// MigrationReport describes what MigrateProfiles changed, or would have changed on a dry run.
+type MigrationReport struct {
+	DryRun   bool          // Nothing was written
+	Scanned  int           // Number of profile keys looked at, in both key forms
+	Merged   []LegacyMerge // Orphaned legacy profiles folded into their canonical profile
+	Upgraded []string      // IDs of profiles brought up to the current schema version
+}
+
+// LegacyMerge records a legacy "profile::<id>" key that was merged into the canonical "profile:<id>" key.
+type LegacyMerge struct {
+	UserID           string
+	LegacyBalance    int // Balance held under the legacy key
+	CanonicalBalance int // Balance held under the canonical key before the merge
+	NewBalance       int // Balance of the canonical profile after the merge
+}
+
+// String formats the report for a human.
+func (r *MigrationReport) String() string {
+	lines := []string{}
+	if r.DryRun {
+		lines = append(lines, "dry run, nothing was written")
+	}
+	lines = append(lines, fmt.Sprintf("scanned %d profile keys", r.Scanned))
+
+	lines = append(lines, fmt.Sprintf("merged %d legacy profiles", len(r.Merged)))
+	for _, m := range r.Merged {
+		lines = append(lines, fmt.Sprintf("  %s: legacy %d, canonical %d -> %d", m.UserID, m.LegacyBalance, m.CanonicalBalance, m.NewBalance))
+	}
+
+	lines = append(lines, fmt.Sprintf("upgraded %d profiles to schema version %d", len(r.Upgraded), profileSchemaVersion()))
+	for _, id := range r.Upgraded {
+		lines = append(lines, "  "+id)
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+// mergeLegacyBalance works out the balance of a canonical profile after folding in its legacy profile.
+// !work used to load the canonical profile, add the shift's earnings and save the result under the legacy key,
+// so the legacy balance already includes the canonical balance from the time of the last shift.
+// Adding the two would pay that out twice, so the larger of the two wins.
+func mergeLegacyBalance(canonical, legacy int) int {
+	if legacy > canonical {
+		return legacy
+	}
+	return canonical
+}
+
+// MigrateProfiles connects to the store, merges orphaned legacy profiles into their canonical profile
+// and upgrades every profile to the current schema version. With dryRun set it only reports what it would do.
+func (a *App) MigrateProfiles(ctx context.Context, dryRun bool) (*MigrationReport, error) {
+	a.logger = a.NewLogger()
+	a.context = ctx
+
+	if err := a.connect(); err != nil {
+		return nil, err
+	}
+	defer a.closeRedis()
+
+	a.logger.Info().
+		Bool("dry_run", dryRun).
+		Msg("migrating profiles")
+
+	return a.store.MigrateProfiles(ctx, dryRun)
+}
@@ -0,0 +1,164 @@
+package app
+
+import (
+	"encoding/json"
+	"strconv"
+	"testing"
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+)
+
+func TestMigrateProfile(t *testing.T) {
+	jobID := uuid.NewV4()
+	dueAt := strconv.FormatInt(time.Now().Add(time.Hour).Unix(), 10)
+	tests := []struct {
+		name    string
+		stored  string // The profile as an older build wrote it
+		check   func(t *testing.T, p *Profile)
+		ledger  []LedgerEntry
+		applied bool
+	}{
+		{
+			name:    "unversioned with a balance opens the ledger",
+			stored:  `{"id": "1", "balance": 100}`,
+			ledger:  []LedgerEntry{{UserID: "1", Delta: 100, Balance: 100, Reason: LEDGER_OPENING}},
+			applied: true,
+		},
+		{
+			name:    "unversioned without a balance has nothing to open",
+			stored:  `{"id": "1"}`,
+			applied: true,
+		},
+		{
+			name:    "larger inventory balance wins",
+			stored:  `{"id": "1", "balance": 100, "inventory": {"balance": 250}, "schema_version": 3}`,
+			ledger:  []LedgerEntry{{UserID: "1", Delta: 150, Balance: 250, Reason: LEDGER_LEGACY_MERGE, Ref: "inventory balance"}},
+			applied: true,
+			check: func(t *testing.T, p *Profile) {
+				if p.Balance != 250 || p.Inventory.LegacyBalance != 0 {
+					t.Errorf("balance %d, inventory balance %d, want 250 and 0", p.Balance, p.Inventory.LegacyBalance)
+				}
+			},
+		},
+		{
+			name:    "smaller inventory balance is dropped",
+			stored:  `{"id": "1", "balance": 100, "inventory": {"balance": 50}, "schema_version": 3}`,
+			applied: true,
+			check: func(t *testing.T, p *Profile) {
+				if p.Balance != 100 || p.Inventory.LegacyBalance != 0 {
+					t.Errorf("balance %d, inventory balance %d, want 100 and 0", p.Balance, p.Inventory.LegacyBalance)
+				}
+			},
+		},
+		{
+			name:    "demerit counts become records",
+			stored:  `{"id": "1", "inventory": {"demerits": 2}, "schema_version": 4}`,
+			applied: true,
+			check: func(t *testing.T, p *Profile) {
+				if len(p.Demerits) != 2 || p.Inventory.LegacyDemerits != 0 {
+					t.Fatalf("%d demerits, %d left in the inventory, want 2 and 0", len(p.Demerits), p.Inventory.LegacyDemerits)
+				}
+				for _, d := range p.Demerits {
+					if d.Reason != DEMERIT_LEGACY || !d.ExpiresAt.After(d.IssuedAt) {
+						t.Errorf("demerit %+v is not a legacy demerit that expires", d)
+					}
+				}
+			},
+		},
+		{
+			name:    "completed job succeeded",
+			stored:  `{"id": "1", "schema_version": 5, "current_job": {"id": "` + jobID.String() + `", "created_at": 1000, "expires_at": 1600, "completed": true}}`,
+			applied: true,
+			check: func(t *testing.T, p *Profile) {
+				j := p.ActiveJob.Info()
+				if j.State != JOB_SUCCEEDED || j.WorkTime != 600 || j.StartedAt != 1000 || j.DueAt != 1600 || j.FinishedAt != 1600 {
+					t.Errorf("job %+v, want it succeeded at 1600 after 600s of work", j)
+				}
+				if j.LegacyExpiresAt != 0 || j.LegacyCompleted {
+					t.Error("legacy job fields were left behind")
+				}
+			},
+		},
+		{
+			name:    "overdue job is paid",
+			stored:  `{"id": "1", "schema_version": 5, "current_job": {"id": "` + jobID.String() + `", "payout": 100, "created_at": 1000, "expires_at": 1600}}`,
+			ledger:  []LedgerEntry{{UserID: "1", Delta: 100, Balance: 100, Reason: LEDGER_JOB, Ref: jobID.String()}},
+			applied: true,
+			check: func(t *testing.T, p *Profile) {
+				j := p.ActiveJob.Info()
+				if j.State != JOB_SUCCEEDED || j.FinishedAt != 1600 || j.Paid != 100 {
+					t.Errorf("job %+v, want it succeeded at 1600 and paid 100", j)
+				}
+				if p.Balance != 100 || p.JobsCompleted != 1 || p.Earned != 100 {
+					t.Errorf("balance %d, %d jobs completed and %d earned, want the job paid", p.Balance, p.JobsCompleted, p.Earned)
+				}
+			},
+		},
+		{
+			name:    "running job stays active",
+			stored:  `{"id": "1", "schema_version": 5, "current_job": {"id": "` + jobID.String() + `", "payout": 100, "created_at": 1000, "expires_at": ` + dueAt + `}}`,
+			applied: true,
+			check: func(t *testing.T, p *Profile) {
+				if j := p.ActiveJob.Info(); j.State != JOB_ACTIVE || strconv.FormatInt(j.DueAt, 10) != dueAt || j.FinishedAt != 0 {
+					t.Errorf("job %+v, want it active until %s", j, dueAt)
+				}
+				if p.Balance != 0 {
+					t.Errorf("balance %d, want nothing paid yet", p.Balance)
+				}
+			},
+		},
+		{
+			name:    "no job stays no job",
+			stored:  `{"id": "1", "schema_version": 5, "current_job": null}`,
+			applied: true,
+			check: func(t *testing.T, p *Profile) {
+				if p.ActiveJob.Job != nil {
+					t.Errorf("job %+v appeared out of nowhere", p.ActiveJob.Job)
+				}
+			},
+		},
+		{
+			name:   "current profiles are left alone",
+			stored: `{"id": "1", "balance": 100, "schema_version": ` + strconv.Itoa(profileSchemaVersion()) + `}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var p Profile
+			if err := json.Unmarshal([]byte(tt.stored), &p); err != nil {
+				t.Fatal(err)
+			}
+			applied, err := migrateProfile(&p)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if applied != tt.applied {
+				t.Errorf("applied = %v, want %v", applied, tt.applied)
+			}
+			if p.SchemaVersion != profileSchemaVersion() {
+				t.Errorf("schema version %d, want %d", p.SchemaVersion, profileSchemaVersion())
+			}
+			if len(p.ledger) != len(tt.ledger) {
+				t.Fatalf("ledger %+v, want %+v", p.ledger, tt.ledger)
+			}
+			for i, want := range tt.ledger {
+				got := p.ledger[i]
+				got.At = want.At
+				if got != want {
+					t.Errorf("ledger entry %d = %+v, want %+v", i, got, want)
+				}
+			}
+			if tt.check != nil {
+				tt.check(t, &p)
+			}
+		})
+	}
+}
+
+func TestMigrateProfileFromTheFuture(t *testing.T) {
+	p := &Profile{ID: "1", SchemaVersion: profileSchemaVersion() + 1}
+	if applied, err := migrateProfile(p); err == nil || applied {
+		t.Errorf("migrateProfile = %v, %v, want an error", applied, err)
+	}
+}
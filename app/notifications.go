@@ -0,0 +1,98 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+/*
+Notifications
+
+Almost everything the bot says is a reply to a message somebody just sent. Notifications are the rest: things the bot
+says on its own, long after whatever caused them, like a job coming to an end. By then there's no message left to reply
+to, so whatever wants to notify somebody has to remember where to do it up front, then send it with SendToChannel or
+SendDM. Jobs remember the gateway, server and channel (or thread) they were taken in.
+
+Users choose where they hear about their jobs with !jobs notify:
+
+  - channel, the default: the channel the job was taken in, or the server's notify-channel if an admin set one.
+  - dm: their DMs with the bot. Discord only tells the bot where somebody's DMs are when they DM it, so this
+    needs one DM first. Until then notifications go to the channel.
+  - off: nowhere. They can still check with !jobs active.
+*/
+
+// Where a user hears how their jobs went
+const (
+	NOTIFY_CHANNEL = "channel" // Where the job was taken
+	NOTIFY_DM      = "dm"      // In their DMs
+	NOTIFY_OFF     = "off"     // Not at all
+)
+
+// notifyJobOutcome tells the user how the job they were working on ended, wherever they asked to hear about it.
+// Jobs taken before they remembered where they were taken can still go to DMs, but have no channel to go to.
+func (a *App) notifyJobOutcome(p *Profile, job *JobInfo, outcome JobOutcome) error {
+	if p.JobNotify == NOTIFY_OFF {
+		return nil
+	}
+
+	msg := jobOutcomeMessage(a.Config.Economy, p, job, outcome)
+	if p.JobNotify == NOTIFY_DM {
+		// Until they've DMed the bot, the channel will have to do
+		err := a.SendDM(job.Gateway, p.ID, msg)
+		if !errors.Is(err, ErrNoDMChannel) {
+			return err
+		}
+	}
+	if job.ChannelID == "" {
+		return nil
+	}
+
+	channelID, err := a.notifyChannel(job.GuildID, job.ChannelID)
+	if err != nil {
+		return err
+	}
+	return a.SendToChannel(job.Gateway, channelID, msg)
+}
+
+// notifyChannel returns where notifications about something that happened in a channel go:
+// the server's notify-channel if an admin set one, the channel itself otherwise.
+func (a *App) notifyChannel(guildID, channelID string) (string, error) {
+	if guildID == "" {
+		return channelID, nil
+	}
+	settings, err := a.guildSettings(guildID)
+	if err != nil {
+		return "", err
+	}
+	if settings.NotifyChannel != "" {
+		return settings.NotifyChannel, nil
+	}
+	return channelID, nil
+}
+
+// jobOutcomeMessage describes how a job ended to the user who worked it.
+func jobOutcomeMessage(e EconomyConfig, p *Profile, job *JobInfo, outcome JobOutcome) string {
+	msg := []string{fmt.Sprintf("<@%s>,", p.ID)}
+	switch outcome.State {
+	case JOB_SUCCEEDED:
+		msg = append(msg, fmt.Sprintf("you're done with '%s'. You got paid %d credits and you've got %d now.", job.Name, outcome.Payout, p.Balance))
+		if outcome.Payout < job.Payout {
+			msg = append(msg, fmt.Sprintf("Your demerits cost you the other %d.", job.Payout-outcome.Payout))
+		}
+	case JOB_FAILED:
+		msg = append(msg, fmt.Sprintf("'%s' went wrong.", job.Name))
+		if outcome.Penalty > 0 {
+			msg = append(msg, fmt.Sprintf("Cleaning up the mess cost you %d credits, you've got %d left.", outcome.Penalty, p.Balance))
+		}
+		if outcome.Jailed {
+			msg = append(msg, fmt.Sprintf("It was risky, so that's a demerit too, and it's one too many: you're going to jail for %s.", formatDuration(e.JailTime)))
+		} else if outcome.Demerit {
+			msg = append(msg, "It was risky, so that's a demerit too.")
+		}
+	}
+	if outcome.XP > 0 {
+		msg = append(msg, fmt.Sprintf("(+%d XP)", outcome.XP))
+	}
+	return strings.Join(msg, " ")
+}
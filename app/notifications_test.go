@@ -0,0 +1,38 @@
+package app
+
+import (
+	"context"
+	"testing"
+)
+
+func TestNotifyJobOutcomeWithoutChannel(t *testing.T) {
+	a, transport := newTestApp(t)
+	if err := a.store.RememberDMChannel(context.Background(), a.Config.GatewayID, "1", "dm"); err != nil {
+		t.Fatal(err)
+	}
+
+	// A job from before jobs remembered where they were taken
+	job := &JobInfo{Name: "Old job", Payout: 100}
+	outcome := JobOutcome{State: JOB_SUCCEEDED, Payout: 100}
+	for _, tt := range []struct {
+		notify string
+		sent   int
+	}{
+		{NOTIFY_DM, 1},
+		{NOTIFY_CHANNEL, 0},
+		{NOTIFY_OFF, 0},
+	} {
+		t.Run(tt.notify, func(t *testing.T) {
+			if err := a.notifyJobOutcome(&Profile{ID: "1", JobNotify: tt.notify}, job, outcome); err != nil {
+				t.Fatal(err)
+			}
+			if sent := len(transport.sent); sent != tt.sent {
+				t.Errorf("sent %d notifications, want %d", sent, tt.sent)
+			}
+			if tt.sent > 0 && transport.sent[0].ChannelID != "dm" {
+				t.Errorf("notified in %s, want the DMs", transport.sent[0].ChannelID)
+			}
+			transport.replies()
+		})
+	}
+}
@@ -0,0 +1,200 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+)
+
+/*
+Payments
+
+!pay moves money from one user to another. Both profiles are changed in a single updateProfiles call,
+so the money either moves completely or not at all, and nobody can spend the same dollars twice by
+paying two people at once.
+
+Big payments have to be confirmed with !pay confirm, in case somebody fat-fingers an extra zero.
+
+Money only goes to people. A mention says whether it's a bot, but a raw user ID doesn't say anything, so a recipient
+given by ID has to be somebody the bot has seen talk. Bots are never remembered, and neither is an ID somebody made up,
+so neither gets a profile out of thin air.
+*/
+
+const CONFIRM_PAY = "pay" // Confirmation action for payments waiting on !pay confirm
+
+// maxMemoLength is the longest memo a payment can carry.
+const maxMemoLength = 100
+
+// ErrInsufficientFunds is returned when a user tries to spend more than they have.
+var ErrInsufficientFunds = errors.New("insufficient funds")
+
+// payment is a transfer of money from one user to another.
+type payment struct {
+	From   string `json:"from"`
+	To     string `json:"to"`
+	Amount int    `json:"amount"`
+	Memo   string `json:"memo"`
+}
+
+// The !pay command tree
+func init() {
+	commands.Register(&Command{
+		Name:    "pay",
+		Aliases: []string{"send"},
+		Group:   "Currency",
+		Summary: "Send money to somebody",
+		Help: "Send some of your money to another user, with an optional note.\n" +
+			"Big payments have to be confirmed with `{prefix}pay confirm` before they go through.",
+		Args: []ArgSpec{
+			{Name: "user", Description: "Who to pay", Type: ArgUser},
+			{Name: "amount", Description: "How much to pay, e.g. 250 or 1.5k", Type: ArgAmount},
+			{Name: "memo", Description: "A note for the recipient", Optional: true, Rest: true},
+		},
+		Examples: []string{"pay @somebody 250", "pay @somebody 1.5k for the pizza", "pay confirm"},
+		LockedAt: STANDING_LOCKED,
+		Handler:  handlePayCommand,
+		Subcommands: []*Command{
+			{
+				Name:    "confirm",
+				Summary: "Send the payment you were asked to confirm",
+				Handler: handlePayConfirm,
+			},
+			{
+				Name:    "cancel",
+				Summary: "Forget about the payment you were asked to confirm",
+				Handler: handlePayCancel,
+			},
+			helpSubcommand(),
+		},
+	})
+}
+
+// handlePayCommand handles the !pay command.
+// Small payments go through straight away. Big ones are parked until the sender confirms them.
+func handlePayCommand(a *App, m *Message, args *Args) error {
+	p := &payment{
+		From:   m.Author.ID,
+		To:     args.User("user"),
+		Amount: args.Int("amount"),
+		// Don't let a memo ping the whole server
+		Memo: escapeMentions(args.String("memo")),
+	}
+
+	if p.To == p.From {
+		return a.handleOutgoingMessage(m.RespondToChannelOrThread(a.Config.AppID, "You can't pay yourself. Nice try.", true, false))
+	}
+	refusal, err := a.checkRecipient(m, p.To)
+	if err != nil {
+		return err
+	}
+	if refusal != "" {
+		return a.handleOutgoingMessage(m.RespondToChannelOrThread(a.Config.AppID, refusal, true, false))
+	}
+	if len([]rune(p.Memo)) > maxMemoLength {
+		msg := fmt.Sprintf("That memo is too long. Keep it under %d characters.", maxMemoLength)
+		return a.handleOutgoingMessage(m.RespondToChannelOrThread(a.Config.AppID, msg, true, false))
+	}
+
+	if threshold := a.Config.Economy.PayConfirmAbove; threshold > 0 && p.Amount > threshold {
+		if err := a.requestConfirmation(m.Author.ID, CONFIRM_PAY, p); err != nil {
+			return err
+		}
+		msg := fmt.Sprintf("That's a lot of money. Type `%[1]spay confirm` within %[2]s to send %[3]d dollars to <@%[4]s>, or `%[1]spay cancel` to forget about it.",
+			args.Prefix, formatDuration(confirmationTTL), p.Amount, p.To)
+		return a.handleOutgoingMessage(m.RespondToChannelOrThread(a.Config.AppID, msg, true, false))
+	}
+
+	return a.sendPayment(m, p)
+}
+
+// checkRecipient makes sure userID is a person the bot knows. It returns what to tell the sender if they aren't,
+// or an empty string if they are.
+func (a *App) checkRecipient(m *Message, userID string) (string, error) {
+	for _, mentioned := range m.Mentions {
+		if mentioned.ID == userID {
+			if mentioned.Bot {
+				return "Bots don't need money. Trust me.", nil
+			}
+			return "", nil
+		}
+	}
+
+	names, err := a.store.DisplayNames(a.context, []string{userID})
+	if err != nil {
+		return "", err
+	}
+	if _, ok := names[userID]; !ok {
+		return fmt.Sprintf("I don't know anybody with the ID `%s`. They have to say something before they can get paid.", userID), nil
+	}
+	return "", nil
+}
+
+// handlePayConfirm handles the !pay confirm command. It sends the payment waiting on confirmation, if there is one.
+func handlePayConfirm(a *App, m *Message, args *Args) error {
+	var p payment
+	ok, err := a.takeConfirmation(m.Author.ID, CONFIRM_PAY, &p)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return a.handleOutgoingMessage(m.RespondToChannelOrThread(a.Config.AppID, "There's no payment waiting on you. Maybe it expired?", true, false))
+	}
+	return a.sendPayment(m, &p)
+}
+
+// handlePayCancel handles the !pay cancel command.
+func handlePayCancel(a *App, m *Message, args *Args) error {
+	var p payment
+	ok, err := a.takeConfirmation(m.Author.ID, CONFIRM_PAY, &p)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return a.handleOutgoingMessage(m.RespondToChannelOrThread(a.Config.AppID, "There's no payment waiting on you.", true, false))
+	}
+	msg := fmt.Sprintf("Okay, I won't send %d dollars to <@%s>.", p.Amount, p.To)
+	return a.handleOutgoingMessage(m.RespondToChannelOrThread(a.Config.AppID, msg, true, false))
+}
+
+// sendPayment moves the money, then tells the sender and the recipient how it went.
+func (a *App) sendPayment(m *Message, p *payment) error {
+	var balance int
+	profiles, err := a.updateProfiles([]string{p.From, p.To}, func(profiles []*Profile) error {
+		from, to := profiles[0], profiles[1]
+		balance = from.Balance
+		if from.Balance < p.Amount {
+			return ErrInsufficientFunds
+		}
+		from.adjustBalance(-p.Amount, LEDGER_PAY_SENT, p.To)
+		to.adjustBalance(p.Amount, LEDGER_PAY_RECEIVED, p.From)
+		return nil
+	})
+	if err == ErrInsufficientFunds {
+		msg := fmt.Sprintf("You can't afford that. You only have %d dollars.", balance)
+		return a.handleOutgoingMessage(m.RespondToChannelOrThread(a.Config.AppID, msg, true, false))
+	}
+	if err != nil {
+		return err
+	}
+	from, to := profiles[0], profiles[1]
+
+	a.logger.Info().
+		Str("from", p.From).
+		Str("to", p.To).
+		Int("amount", p.Amount).
+		Msg("payment sent")
+
+	memo := ""
+	if p.Memo != "" {
+		memo = " with the note \"" + p.Memo + "\""
+	}
+
+	msg := fmt.Sprintf("You sent %d dollars to <@%s>%s. You now have %d dollars and they have %d dollars.",
+		p.Amount, p.To, memo, from.Balance, to.Balance)
+	if err := a.handleOutgoingMessage(m.RespondToChannelOrThread(a.Config.AppID, msg, true, false)); err != nil {
+		return err
+	}
+
+	// Let the recipient know. Mentioning them is what gets their attention.
+	notice := fmt.Sprintf("<@%s>, <@%s> sent you %d dollars%s. You now have %d dollars.", p.To, p.From, p.Amount, memo, to.Balance)
+	return a.handleOutgoingMessage(m.RespondToChannelOrThread(a.Config.AppID, notice, false, false))
+}
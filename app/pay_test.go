@@ -0,0 +1,99 @@
+package app
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// Users in the payment tests. Raw IDs have to look like snowflakes to be taken as users.
+const (
+	payer     = "100000000000000001"
+	recipient = "100000000000000002"
+	stranger  = "100000000000000003"
+)
+
+func TestPay(t *testing.T) {
+	tests := []struct {
+		name      string
+		balance   int    // What the payer has
+		command   string // What the payer types
+		reply     string // Part of the first reply
+		paid      int    // What the recipient gets
+		confirmed bool   // Whether the payer confirms with !pay confirm afterwards
+	}{
+		{"pay", 500, "!pay " + recipient + " 200", "You sent 200 dollars", 200, false},
+		{"pay with a mention", 500, "!pay <@" + recipient + "> 200 for the pie", `with the note "for the pie"`, 200, false},
+		{"pay everything", 500, "!pay " + recipient + " 500", "You now have 0 dollars", 500, false},
+		{"not enough money", 100, "!pay " + recipient + " 200", "You only have 100 dollars", 0, false},
+		{"yourself", 500, "!pay " + payer + " 200", "can't pay yourself", 0, false},
+		{"somebody the bot has never seen", 500, "!pay " + stranger + " 200", "I don't know anybody", 0, false},
+		{"memo that mentions everyone", 500, "!pay " + recipient + " 5 @everyone", "You sent 5 dollars", 5, false},
+		{"big payment waits", 5000, "!pay " + recipient + " 2k", "Type `!pay confirm`", 0, false},
+		{"big payment confirmed", 5000, "!pay " + recipient + " 2k", "Type `!pay confirm`", 2000, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, transport := newTestApp(t)
+			setBalance(t, a, payer, tt.balance)
+			setBalance(t, a, recipient, 0)
+			send(t, a, transport, recipient, "hello") // So the bot knows them
+
+			replies := send(t, a, transport, payer, tt.command)
+			if len(replies) == 0 || !strings.Contains(replies[0], tt.reply) {
+				t.Fatalf("replied %q, want %q", replies, tt.reply)
+			}
+			if strings.Contains(strings.Join(replies, "\n"), "@everyone") {
+				t.Errorf("replied %q, which pings everyone", replies)
+			}
+			if tt.confirmed {
+				send(t, a, transport, payer, "!pay confirm")
+			}
+
+			if got := balanceOf(t, a, recipient); got != tt.paid {
+				t.Errorf("recipient has %d, want %d", got, tt.paid)
+			}
+			if got := balanceOf(t, a, payer); got != tt.balance-tt.paid {
+				t.Errorf("payer has %d, want %d", got, tt.balance-tt.paid)
+			}
+			if p, err := a.findProfile(stranger); err != nil || p != nil {
+				t.Errorf("stranger has profile %+v (%v), want none", p, err)
+			}
+		})
+	}
+}
+
+func TestPayBot(t *testing.T) {
+	a, transport := newTestApp(t)
+	setBalance(t, a, payer, 500)
+
+	m := testMessage(payer, "!pay <@"+recipient+"> 200")
+	m.Mentions = []*discordgo.User{{ID: recipient, Bot: true}}
+	if err := handleMessage(a, m); err != nil {
+		t.Fatal(err)
+	}
+	if replies := transport.replies(); len(replies) != 1 || !strings.Contains(replies[0], "Bots don't need money") {
+		t.Errorf("replied %q, want the payment refused", replies)
+	}
+	if got := balanceOf(t, a, payer); got != 500 {
+		t.Errorf("payer has %d, want 500", got)
+	}
+}
+
+func TestPayCancel(t *testing.T) {
+	a, transport := newTestApp(t)
+	setBalance(t, a, payer, 5000)
+	send(t, a, transport, recipient, "hello")
+
+	send(t, a, transport, payer, "!pay "+recipient+" 2k")
+	if replies := send(t, a, transport, payer, "!pay cancel"); len(replies) != 1 || !strings.Contains(replies[0], "won't send 2000 dollars") {
+		t.Errorf("replied %q to !pay cancel", replies)
+	}
+	if replies := send(t, a, transport, payer, "!pay confirm"); len(replies) != 1 || !strings.Contains(replies[0], "no payment waiting") {
+		t.Errorf("replied %q to !pay confirm after cancelling", replies)
+	}
+	if got := balanceOf(t, a, payer); got != 5000 {
+		t.Errorf("payer has %d, want 5000", got)
+	}
+}
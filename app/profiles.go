@@ -1,104 +1,136 @@
 package app
 
 import (
-	"encoding/json"
-	"math/rand"
+	"errors"
 	"strconv"
+	"time"
 )
 
 // Prefix for consistent key names in the database.
 const REDIS_PROFILE_PREFIX = "profile:"
 
+// LEGACY_PROFILE_PREFIX is the broken prefix !work used to save profiles under ("profile::<user_id>").
+// Nothing should write to it anymore. MigrateProfiles folds these keys back into the canonical ones.
+const LEGACY_PROFILE_PREFIX = REDIS_PROFILE_PREFIX + ":"
+
+// maxProfileUpdateAttempts is how many times updateProfile tries before giving up on a busy profile.
+const maxProfileUpdateAttempts = 10
+
+// ErrProfileContention is returned by updateProfile when the profile kept changing underneath it.
+var ErrProfileContention = errors.New("profile is too busy to update, try again")
+
+// ErrDuplicateProfile is returned by updateProfiles when it's asked to update the same profile twice.
+var ErrDuplicateProfile = errors.New("the same profile can't be updated twice at once")
+
 // Profile is a struct that represents a user's profile.
 // It's the main data structure for the game and tracks the state for a user.
 // State is maintained in redis under the top-level key "profile:<user_id>".
 // Profiles have the ID field of the user to facilitate lookups and writing the profile back to Redis.
 type Profile struct {
-	ID        string    `json:"id"`          // The unique ID of the profile. This is the same as the snowflake ID of the user in Discord.
-	Inventory Inventory `json:"inventory"`   // The user's inventory
-	Balance   int       `json:"balance"`     // The user's available spending balance
-	ActiveJob Job       `json:"current_job"` // Active job
-}
-
-// getProfile gets the profile for the given user ID.
-// If the profile does not exist, it will be created.
-func (a *App) getProfile(userID string) (*Profile, error) {
-	// Check for the profile in the database
-	p, err := a.redis.Get(a.context, "profile:"+userID).Result()
-	if err != nil {
-		// If the profile does not exist, create a new profile
-		profile := &Profile{
-			ID:        userID,
-			Inventory: Inventory{},
-			Balance:   0,
-		}
+	ID        string    `json:"id"`                  // The unique ID of the profile. This is the same as the snowflake ID of the user in Discord.
+	Inventory Inventory `json:"inventory"`           // The user's inventory
+	Balance   int       `json:"balance"`             // The user's available spending balance. Only change it with adjustBalance.
+	ActiveJob AnyJob    `json:"current_job"`         // Active job, or the last one if it's over. Any type of job, see app/jobTypes.go
+	JobBoard  *JobBoard `json:"job_board,omitempty"` // The jobs the user can take today. See app/jobBoard.go
 
-		// Marshal the profile
-		profileBytes, err := json.Marshal(profile)
-		if err != nil {
-			return nil, err
-		}
+	JobsCompleted int    `json:"jobs_completed"` // How many jobs the user has been paid for
+	Earned        int    `json:"earned"`         // Everything the user has earned from work and jobs, spent or not
+	XP            int    `json:"xp"`             // Everything the user has learned from work and jobs. Their level follows from it, see app/levels.go
+	JobNotify     string `json:"job_notify"`     // Where the user hears how their jobs went. One of the NOTIFY_ options, empty means NOTIFY_CHANNEL.
+	Timezone      string `json:"timezone"`       // Time zone the user's days are counted in. Empty means Config.Economy.DefaultTimezone. See app/timezones.go
 
-		// Save the profile to the database
-		err = a.redis.Set(a.context, "profile:"+userID, profileBytes, 0).Err()
-		if err != nil {
-			return nil, err
-		}
+	Demerits    []Demerit `json:"demerits"`     // The user's record. Expired demerits are dropped when a new one is added. See app/demerits.go
+	JailedUntil time.Time `json:"jailed_until"` // When the user gets out of jail. In the past when they're not in it.
 
-		return profile, nil
-	}
+	SchemaVersion int `json:"schema_version"` // Version of the profile layout this was written with. See app/migrations.go
 
-	// If the profile exists, unmarshal it and return it
-	var profile Profile
-	err = json.Unmarshal([]byte(p), &profile)
-	if err != nil {
-		return nil, err
-	}
+	ledger []LedgerEntry // Balance changes waiting to be written along with the profile. See app/ledger.go
+}
 
-	return &profile, nil
+// profileKey returns the key the profile for the given user is stored under.
+func profileKey(userID string) string {
+	return REDIS_PROFILE_PREFIX + userID
 }
 
-// saveProfile saves the profile to the database.
-func (p *Profile) save(a *App) error {
-	// Marshal the profile into a json string
-	profileBytes, err := json.Marshal(p)
-	if err != nil {
-		return err
+// profileKeys returns the Redis keys for the profiles of the given users.
+// It returns ErrDuplicateProfile if a user is listed more than once.
+func profileKeys(userIDs []string) ([]string, error) {
+	seen := map[string]bool{}
+	keys := make([]string, 0, len(userIDs))
+	for _, userID := range userIDs {
+		if seen[userID] {
+			return nil, ErrDuplicateProfile
+		}
+		seen[userID] = true
+		keys = append(keys, profileKey(userID))
 	}
+	return keys, nil
+}
 
-	// Save the profile to the database
-	// TODO: This looks prone to race conditions. Make it not that.
-	return a.redis.Set(a.context, "profile:"+p.ID, profileBytes, 0).Err()
+// newProfile returns an empty profile for the given user.
+func newProfile(userID string) *Profile {
+	return &Profile{
+		ID:            userID,
+		Inventory:     Inventory{},
+		Balance:       0,
+		SchemaVersion: profileSchemaVersion(),
+	}
 }
 
-// work is a method on the profile that handles the work command from chat.
-// It should generate some amount of currency and add it to the user's balance.
-// This method will eventually take the user's profile and return a scaled or leveled amount of currency.
-// For now it just returns a random amount of currency between 1 and 100.
-// This function is probably going to be the biggest source of bugs for a long time.
-// I have a feeling that we will see a lot of race conditions and concurrency issues here. I can't wait.
-func (p *Profile) work(a *App) (int, error) {
-	// Generate a random amount of currency between 1 and 100
-	// TODO: This should eventually be scaled or leveled based on the user's profile/level/job/other attributes
-	// Make sure always earn at least 1 buck
-	earned := rand.Intn(100) + 1 // nolint:gosec // This is not a security issue
+// getProfile gets the profile for the given user ID.
+// If the profile does not exist, it will be created.
+func (a *App) getProfile(userID string) (*Profile, error) {
+	return a.store.GetProfile(a.context, userID)
+}
 
-	// Add the amount earned to the user's balance
-	p.Balance += earned
+// findProfile gets the profile for the given user, or nil if they don't have one. Use it to look at somebody else's
+// profile, so looking doesn't create one for them.
+func (a *App) findProfile(userID string) (*Profile, error) {
+	return a.store.FindProfile(a.context, userID)
+}
 
-	// Marshal the profile
-	profileBytes, err := json.Marshal(p)
-	if err != nil {
-		return 0, err
+// updateProfile atomically loads the profile for the given user, passes it to fn and saves whatever fn left behind.
+// If the profile changes underneath us the whole thing is retried, so fn may be called more than once and must not
+// have side effects outside the profile. If fn returns an error nothing is saved and the error is returned as-is.
+// If the profile does not exist, it will be created.
+// Every change to a profile should go through here. Loading a profile, changing it and saving it later will lose updates.
+func (a *App) updateProfile(userID string, fn func(*Profile) error) (*Profile, error) {
+	profile, err := a.store.UpdateProfile(a.context, userID, fn)
+	if err == ErrProfileContention {
+		a.logger.Warn().
+			Str("user", userID).
+			Msg("gave up updating a busy profile")
 	}
+	return profile, err
+}
 
-	// Save the profile to the database
-	err = a.redis.Set(a.context, REDIS_PROFILE_PREFIX+":"+p.ID, profileBytes, 0).Err()
-	if err != nil {
-		return 0, err
+// updateProfiles is updateProfile for several users at once. Either every profile is saved or none are,
+// which is what moving anything between users needs. fn gets the profiles in the same order as userIDs.
+// The same rules as updateProfile apply to fn.
+func (a *App) updateProfiles(userIDs []string, fn func([]*Profile) error) ([]*Profile, error) {
+	profiles, err := a.store.UpdateProfiles(a.context, userIDs, fn)
+	if err == ErrProfileContention {
+		a.logger.Warn().
+			Strs("users", userIDs).
+			Msg("gave up updating busy profiles")
 	}
+	return profiles, err
+}
+
+// work pays the user for a shift of !work and returns what they earned: a random amount within the configured
+// work payout range, the same at every level. Levels scale jobs, not work. See app/levels.go.
+// It only changes the profile in memory, so call it from inside updateProfile.
+func (p *Profile) work(a *App) int {
+	// Generate a random amount of currency within the configured range
+	earned := randBetween(a.Config.Economy.WorkMinPayout, a.Config.Economy.WorkMaxPayout)
+
+	// Users with too many demerits get a cut of it
+	earned = p.cutPayout(a.Config.Economy, earned)
+
+	// Add the amount earned to the user's balance
+	p.adjustBalance(earned, LEDGER_WORK, "")
 
-	return earned, nil
+	return earned
 }
 
 // getBalance returns the user's balance.
@@ -0,0 +1,18 @@
+package app
+
+import "testing"
+
+func TestFindProfile(t *testing.T) {
+	a, _ := newTestApp(t)
+	if p, err := a.findProfile("1"); err != nil || p != nil {
+		t.Fatalf("findProfile = %+v, %v, want no profile", p, err)
+	}
+	if p, err := a.findProfile("1"); err != nil || p != nil {
+		t.Errorf("looking for a profile created it: %+v, %v", p, err)
+	}
+
+	setBalance(t, a, "1", 10)
+	if p, err := a.findProfile("1"); err != nil || p == nil || p.Balance != 10 {
+		t.Errorf("findProfile = %+v, %v, want the saved profile", p, err)
+	}
+}
@@ -1,6 +1,7 @@
 package app
 
 import (
+	"crypto/tls"
 	"strconv"
 
 	"github.com/go-redis/redis/v8"
@@ -10,14 +11,23 @@ type Redis struct {
 	*redis.Client
 }
 
-func newRedis(host string, port int, password string, db int) (*Redis, error) {
-	client := redis.NewClient(&redis.Options{
+func newRedis(host string, port int, password string, db int, useTLS bool) (*Redis, error) {
+	options := &redis.Options{
 		Addr:     host + ":" + strconv.Itoa(port),
 		Password: password,
 		DB:       db,
-	})
+	}
+	if useTLS {
+		options.TLSConfig = &tls.Config{
+			ServerName: host,
+			MinVersion: tls.VersionTLS12,
+		}
+	}
+
+	client := redis.NewClient(options)
 	_, err := client.Ping(client.Context()).Result()
 	if err != nil {
+		client.Close()
 		return nil, err
 	}
 	return &Redis{client}, nil
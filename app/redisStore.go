@@ -0,0 +1,760 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+const (
+	SCHEDULER_QUEUE_KEY = "schedule"       // Sorted set of task IDs scored by due time in unix milliseconds
+	SCHEDULER_TASKS_KEY = "schedule:tasks" // Hash of task ID to task JSON
+)
+
+// claimDueTasks atomically finds the due tasks and pushes them out by the lease so that nobody else claims them.
+// KEYS[1] = queue, KEYS[2] = tasks, ARGV[1] = now, ARGV[2] = lease expiry, ARGV[3] = batch size
+// Returns the JSON of every claimed task. IDs without a task are dropped from the queue.
+var claimDueTasks = redis.NewScript(`
+local due = redis.call('ZRANGEBYSCORE', KEYS[1], '-inf', ARGV[1], 'LIMIT', 0, ARGV[3])
+local tasks = {}
+for _, id in ipairs(due) do
+	local task = redis.call('HGET', KEYS[2], id)
+	if task then
+		redis.call('ZADD', KEYS[1], ARGV[2], id)
+		table.insert(tasks, task)
+	else
+		redis.call('ZREM', KEYS[1], id)
+	end
+end
+return tasks
+`)
+
+// startCooldown atomically checks for a running cooldown and starts one if there isn't.
+// The key expires along with the last cooldown in it, so idle users don't leave anything behind.
+// KEYS[1] = user's cooldowns, ARGV[1] = action, ARGV[2] = now, ARGV[3] = expiry of the new cooldown
+// Returns the milliseconds left on the running cooldown, or 0 if a new one was started.
+var startCooldown = redis.NewScript(`
+redis.call('ZREMRANGEBYSCORE', KEYS[1], '-inf', ARGV[2])
+local current = redis.call('ZSCORE', KEYS[1], ARGV[1])
+if current then
+	return tonumber(current) - tonumber(ARGV[2])
+end
+redis.call('ZADD', KEYS[1], ARGV[3], ARGV[1])
+local last = redis.call('ZRANGE', KEYS[1], -1, -1, 'WITHSCORES')
+redis.call('PEXPIREAT', KEYS[1], last[2])
+return 0
+`)
+
+// redisStore keeps the game state in Redis.
+// Profiles live under "profile:<user_id>" (see profileKey), job boards and all,
+// and guild settings under "guild:<guild_id>", all as JSON strings. Cooldowns are a sorted set per user under "cooldowns:<user_id>"
+// and the ledger is a stream per user under "ledger:<user_id>". Leaderboards are sorted sets under "leaderboard:<stat>".
+type redisStore struct {
+	client *Redis
+}
+
+// newRedisStore creates a store on top of an existing Redis connection.
+func newRedisStore(client *Redis) *redisStore {
+	return &redisStore{client: client}
+}
+
+// GetProfile gets the profile for the given user ID.
+// If the profile does not exist, it will be created.
+func (s *redisStore) GetProfile(ctx context.Context, userID string) (*Profile, error) {
+	// Check for the profile in the database
+	profile, err := s.FindProfile(ctx, userID)
+	if err != nil || profile != nil {
+		return profile, err
+	}
+
+	// If the profile does not exist, create a new profile
+	profile = newProfile(userID)
+
+	// Marshal the profile
+	profileBytes, err := json.Marshal(profile)
+	if err != nil {
+		return nil, err
+	}
+
+	// Save the profile to the database, unless somebody else created it in the meantime
+	created, err := s.client.SetNX(ctx, profileKey(userID), profileBytes, 0).Result()
+	if err != nil {
+		return nil, err
+	}
+	if !created {
+		return s.GetProfile(ctx, userID)
+	}
+
+	return profile, nil
+}
+
+// FindProfile gets the profile for the given user ID, or nil if there isn't one.
+func (s *redisStore) FindProfile(ctx context.Context, userID string) (*Profile, error) {
+	p, err := s.client.Get(ctx, profileKey(userID)).Result()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// If the profile exists, unmarshal it, bring it up to date and return it
+	var profile Profile
+	err = json.Unmarshal([]byte(p), &profile)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := migrateProfile(&profile); err != nil {
+		return nil, err
+	}
+
+	return &profile, nil
+}
+
+// UpdateProfile uses WATCH/MULTI so the write only lands if nobody touched the profile since we read it.
+// If somebody did, the whole read-modify-write is retried. See UpdateProfiles.
+func (s *redisStore) UpdateProfile(ctx context.Context, userID string, fn func(*Profile) error) (*Profile, error) {
+	profiles, err := s.UpdateProfiles(ctx, []string{userID}, func(profiles []*Profile) error {
+		return fn(profiles[0])
+	})
+	if err != nil {
+		return nil, err
+	}
+	return profiles[0], nil
+}
+
+// UpdateProfiles watches every profile key, so the write only lands if nobody touched any of them since we read them.
+func (s *redisStore) UpdateProfiles(ctx context.Context, userIDs []string, fn func([]*Profile) error) ([]*Profile, error) {
+	keys, err := profileKeys(userIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	var profiles []*Profile
+	txf := func(tx *redis.Tx) error {
+		// Load the profiles, or start fresh ones for those that don't exist yet
+		profiles = make([]*Profile, len(userIDs))
+		for i, userID := range userIDs {
+			profiles[i] = newProfile(userID)
+			p, err := tx.Get(ctx, keys[i]).Bytes()
+			if err != nil && err != redis.Nil {
+				return err
+			}
+			if err == nil {
+				if err := json.Unmarshal(p, profiles[i]); err != nil {
+					return err
+				}
+				if _, err := migrateProfile(profiles[i]); err != nil {
+					return err
+				}
+			}
+		}
+
+		// Apply the change
+		if err := fn(profiles); err != nil {
+			return err
+		}
+
+		// Marshal the profiles
+		profileBytes := make([][]byte, len(profiles))
+		for i, profile := range profiles {
+			b, err := json.Marshal(profile)
+			if err != nil {
+				return err
+			}
+			profileBytes[i] = b
+		}
+
+		// Save the profiles. This only goes through if nobody touched any of the keys since we started watching them.
+		_, err := tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			for i, key := range keys {
+				pipe.Set(ctx, key, profileBytes[i], 0)
+				appendLedger(ctx, pipe, profiles[i])
+				updateLeaderboards(ctx, pipe, profiles[i])
+			}
+			return nil
+		})
+		return err
+	}
+
+	for i := 0; i < maxProfileUpdateAttempts; i++ {
+		err := s.client.Watch(ctx, txf, keys...)
+		if err == redis.TxFailedErr {
+			// Somebody else got there first. Try again with their changes.
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		return profiles, nil
+	}
+
+	return nil, ErrProfileContention
+}
+
+// ProfileIDs scans for every canonical profile key.
+func (s *redisStore) ProfileIDs(ctx context.Context) ([]string, error) {
+	userIDs := []string{}
+	iter := s.client.Scan(ctx, 0, REDIS_PROFILE_PREFIX+"*", 100).Iterator()
+	for iter.Next(ctx) {
+		if key := iter.Val(); !strings.HasPrefix(key, LEGACY_PROFILE_PREFIX) {
+			userIDs = append(userIDs, strings.TrimPrefix(key, REDIS_PROFILE_PREFIX))
+		}
+	}
+	if err := iter.Err(); err != nil {
+		return nil, err
+	}
+	return userIDs, nil
+}
+
+// appendLedger queues the ledger entries a profile has been holding on to, so they're written in the same transaction as the profile.
+func appendLedger(ctx context.Context, pipe redis.Pipeliner, p *Profile) {
+	for _, entry := range p.ledger {
+		pipe.XAdd(ctx, &redis.XAddArgs{
+			Stream: ledgerKey(p.ID),
+			Values: map[string]interface{}{
+				"delta":   entry.Delta,
+				"balance": entry.Balance,
+				"reason":  entry.Reason,
+				"ref":     entry.Ref,
+				"at":      entry.At.UnixMilli(),
+			},
+		})
+	}
+}
+
+// LedgerEntries reads the user's ledger stream backwards.
+func (s *redisStore) LedgerEntries(ctx context.Context, userID string, limit int) ([]LedgerEntry, error) {
+	var messages []redis.XMessage
+	var err error
+	if limit > 0 {
+		messages, err = s.client.XRevRangeN(ctx, ledgerKey(userID), "+", "-", int64(limit)).Result()
+	} else {
+		messages, err = s.client.XRevRange(ctx, ledgerKey(userID), "+", "-").Result()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]LedgerEntry, 0, len(messages))
+	for _, message := range messages {
+		entry, err := ledgerEntryFromStream(userID, message)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// ledgerEntryFromStream turns a stream message written by appendLedger back into a ledger entry.
+func ledgerEntryFromStream(userID string, message redis.XMessage) (LedgerEntry, error) {
+	field := func(name string) string {
+		value, _ := message.Values[name].(string)
+		return value
+	}
+
+	delta, err := strconv.Atoi(field("delta"))
+	if err != nil {
+		return LedgerEntry{}, fmt.Errorf("ledger entry %s of %s has a bad delta: %w", message.ID, userID, err)
+	}
+	balance, err := strconv.Atoi(field("balance"))
+	if err != nil {
+		return LedgerEntry{}, fmt.Errorf("ledger entry %s of %s has a bad balance: %w", message.ID, userID, err)
+	}
+	at, err := strconv.ParseInt(field("at"), 10, 64)
+	if err != nil {
+		return LedgerEntry{}, fmt.Errorf("ledger entry %s of %s has a bad timestamp: %w", message.ID, userID, err)
+	}
+
+	return LedgerEntry{
+		ID:      message.ID,
+		UserID:  userID,
+		Delta:   delta,
+		Balance: balance,
+		Reason:  field("reason"),
+		Ref:     field("ref"),
+		At:      time.UnixMilli(at),
+	}, nil
+}
+
+// updateLeaderboards queues putting the profile's current stats on every leaderboard.
+func updateLeaderboards(ctx context.Context, pipe redis.Pipeliner, p *Profile) {
+	for _, stat := range leaderboardStats {
+		pipe.ZAdd(ctx, leaderboardKey(stat.Name), &redis.Z{Score: float64(stat.score(p)), Member: p.ID})
+	}
+}
+
+// Leaderboard reads a page of a leaderboard. Server leaderboards are built on the fly by intersecting
+// the global leaderboard with the set of members seen in the server.
+func (s *redisStore) Leaderboard(ctx context.Context, query LeaderboardQuery) (*LeaderboardPage, error) {
+	key := leaderboardKey(query.Stat)
+
+	var entries *redis.ZSliceCmd
+	var total *redis.IntCmd
+	var rank *redis.IntCmd
+	var score *redis.FloatCmd
+	_, err := s.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		if query.GuildID != "" {
+			global := key
+			key = global + ":guild:" + query.GuildID
+			pipe.ZInterStore(ctx, key, &redis.ZStore{
+				Keys:    []string{global, guildMembersKey(query.GuildID)},
+				Weights: []float64{1, 0},
+			})
+			pipe.Expire(ctx, key, time.Minute)
+		}
+		entries = pipe.ZRevRangeWithScores(ctx, key, int64(query.Offset), int64(query.Offset+query.Limit-1))
+		total = pipe.ZCard(ctx, key)
+		rank = pipe.ZRevRank(ctx, key, query.UserID)
+		score = pipe.ZScore(ctx, key, query.UserID)
+		return nil
+	})
+	// Users who aren't on the board make ZREVRANK and ZSCORE come back empty
+	if err != nil && err != redis.Nil {
+		return nil, err
+	}
+
+	page := &LeaderboardPage{Total: int(total.Val())}
+	for i, z := range entries.Val() {
+		page.Entries = append(page.Entries, LeaderboardEntry{
+			Rank:   query.Offset + i + 1,
+			UserID: fmt.Sprint(z.Member),
+			Score:  int(z.Score),
+		})
+	}
+	if rank.Err() == nil {
+		page.Own = &LeaderboardEntry{Rank: int(rank.Val()) + 1, UserID: query.UserID, Score: int(score.Val())}
+	}
+	return page, nil
+}
+
+// RememberUser stores the user's name and adds them to the guild's members.
+func (s *redisStore) RememberUser(ctx context.Context, guildID, userID, name string) error {
+	_, err := s.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HSet(ctx, DISPLAY_NAMES_KEY, userID, name)
+		if guildID != "" {
+			pipe.SAdd(ctx, guildMembersKey(guildID), userID)
+		}
+		return nil
+	})
+	return err
+}
+
+// DisplayNames looks up the names of the users.
+func (s *redisStore) DisplayNames(ctx context.Context, userIDs []string) (map[string]string, error) {
+	names := map[string]string{}
+	if len(userIDs) == 0 {
+		return names, nil
+	}
+
+	values, err := s.client.HMGet(ctx, DISPLAY_NAMES_KEY, userIDs...).Result()
+	if err != nil {
+		return nil, err
+	}
+	for i, value := range values {
+		if name, ok := value.(string); ok {
+			names[userIDs[i]] = name
+		}
+	}
+	return names, nil
+}
+
+// RememberDMChannel stores the channel of the user's DMs with the bot on the gateway.
+func (s *redisStore) RememberDMChannel(ctx context.Context, gatewayID, userID, channelID string) error {
+	return s.client.HSet(ctx, DM_CHANNELS_KEY, dmChannelField(gatewayID, userID), channelID).Err()
+}
+
+// DMChannel looks up the channel of the user's DMs with the bot on the gateway.
+// Channels remembered before they were kept per gateway were all on the one gateway the bot had, and are used until the
+// user DMs the bot again.
+func (s *redisStore) DMChannel(ctx context.Context, gatewayID, userID string) (string, error) {
+	channels, err := s.client.HMGet(ctx, DM_CHANNELS_KEY, dmChannelField(gatewayID, userID), userID).Result()
+	if err != nil {
+		return "", err
+	}
+	for _, channelID := range channels {
+		if channelID, ok := channelID.(string); ok {
+			return channelID, nil
+		}
+	}
+	return "", nil
+}
+
+// GetGuildSettings gets the settings for the given guild, or the defaults if it has none.
+func (s *redisStore) GetGuildSettings(ctx context.Context, guildID string) (*GuildSettings, error) {
+	settingsJSON, err := s.client.Get(ctx, guildSettingsKey(guildID)).Result()
+	if err == redis.Nil {
+		return defaultGuildSettings(guildID), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	settings := defaultGuildSettings(guildID)
+	if err := json.Unmarshal([]byte(settingsJSON), settings); err != nil {
+		return nil, err
+	}
+	return settings, nil
+}
+
+// UpdateGuildSettings changes the settings for a guild with fn. Like UpdateProfiles it watches the key and tries again
+// if somebody else changed the settings in the meantime, so two admins changing different settings don't undo each other.
+func (s *redisStore) UpdateGuildSettings(ctx context.Context, guildID string, fn func(*GuildSettings) error) (*GuildSettings, error) {
+	key := guildSettingsKey(guildID)
+
+	var settings *GuildSettings
+	txf := func(tx *redis.Tx) error {
+		// Load the settings, or start from the defaults
+		settings = defaultGuildSettings(guildID)
+		settingsBytes, err := tx.Get(ctx, key).Bytes()
+		if err != nil && err != redis.Nil {
+			return err
+		}
+		if err == nil {
+			if err := json.Unmarshal(settingsBytes, settings); err != nil {
+				return err
+			}
+		}
+
+		// Apply the change
+		if err := fn(settings); err != nil {
+			return err
+		}
+		settingsBytes, err = json.Marshal(settings)
+		if err != nil {
+			return err
+		}
+
+		// Save the settings. This only goes through if nobody touched the key since we started watching it.
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.Set(ctx, key, settingsBytes, 0)
+			return nil
+		})
+		return err
+	}
+
+	for i := 0; i < maxGuildSettingsUpdateAttempts; i++ {
+		err := s.client.Watch(ctx, txf, key)
+		if err == redis.TxFailedErr {
+			// Somebody else got there first. Try again with their changes.
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		return settings, nil
+	}
+	return nil, ErrGuildSettingsContention
+}
+
+// StartCooldown starts a cooldown unless one is running. See the startCooldown script.
+func (s *redisStore) StartCooldown(ctx context.Context, userID, action string, now time.Time, cooldown time.Duration) (time.Duration, error) {
+	remaining, err := startCooldown.Run(ctx, s.client, []string{cooldownsKey(userID)},
+		action, now.UnixMilli(), now.Add(cooldown).UnixMilli()).Int64()
+	if err != nil {
+		return 0, err
+	}
+	return time.Duration(remaining) * time.Millisecond, nil
+}
+
+// ClearCooldown removes the cooldown from the user's set.
+func (s *redisStore) ClearCooldown(ctx context.Context, userID, action string) error {
+	return s.client.ZRem(ctx, cooldownsKey(userID), action).Err()
+}
+
+// Cooldowns returns the cooldowns in the user's set that haven't expired yet.
+func (s *redisStore) Cooldowns(ctx context.Context, userID string, now time.Time) ([]Cooldown, error) {
+	running, err := s.client.ZRangeByScoreWithScores(ctx, cooldownsKey(userID), &redis.ZRangeBy{
+		Min: "(" + strconv.FormatInt(now.UnixMilli(), 10),
+		Max: "+inf",
+	}).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	cooldowns := make([]Cooldown, 0, len(running))
+	for _, z := range running {
+		cooldowns = append(cooldowns, Cooldown{
+			Action:    fmt.Sprint(z.Member),
+			ExpiresAt: time.UnixMilli(int64(z.Score)),
+		})
+	}
+	return cooldowns, nil
+}
+
+// StrikeCooldown counts a strike in a key that expires with the cooldown, so the count starts over with the next one.
+func (s *redisStore) StrikeCooldown(ctx context.Context, userID, action string, expiresAt time.Time) (int64, error) {
+	key := cooldownStrikesKey(userID, action)
+
+	var incr *redis.IntCmd
+	_, err := s.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		incr = pipe.Incr(ctx, key)
+		pipe.PExpireAt(ctx, key, expiresAt)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return incr.Val(), nil
+}
+
+// SetConfirmation stores the confirmation with an expiry.
+func (s *redisStore) SetConfirmation(ctx context.Context, userID, action string, payload []byte, ttl time.Duration) error {
+	return s.client.Set(ctx, confirmationKey(userID, action), payload, ttl).Err()
+}
+
+// TakeConfirmation reads and deletes the confirmation in one transaction so it can only be taken once.
+func (s *redisStore) TakeConfirmation(ctx context.Context, userID, action string) ([]byte, error) {
+	key := confirmationKey(userID, action)
+
+	var get *redis.StringCmd
+	_, err := s.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		get = pipe.Get(ctx, key)
+		pipe.Del(ctx, key)
+		return nil
+	})
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return get.Bytes()
+}
+
+// ScheduleTask writes the task and queues it in a single transaction.
+func (s *redisStore) ScheduleTask(ctx context.Context, task *Task) error {
+	taskBytes, err := json.Marshal(task)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HSet(ctx, SCHEDULER_TASKS_KEY, task.ID, taskBytes)
+		pipe.ZAdd(ctx, SCHEDULER_QUEUE_KEY, &redis.Z{Score: float64(task.DueAt), Member: task.ID})
+		return nil
+	})
+	return err
+}
+
+// HasTask looks the task up in the task hash.
+func (s *redisStore) HasTask(ctx context.Context, id string) (bool, error) {
+	return s.client.HExists(ctx, SCHEDULER_TASKS_KEY, id).Result()
+}
+
+// CancelTask removes the task from both the queue and the task hash.
+func (s *redisStore) CancelTask(ctx context.Context, id string) error {
+	_, err := s.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.ZRem(ctx, SCHEDULER_QUEUE_KEY, id)
+		pipe.HDel(ctx, SCHEDULER_TASKS_KEY, id)
+		return nil
+	})
+	return err
+}
+
+// ClaimDueTasks claims due tasks with a Lua script so two ticks never claim the same task.
+// Tasks that can't be unmarshalled are skipped so they can't hold up the rest of the queue.
+func (s *redisStore) ClaimDueTasks(ctx context.Context, now time.Time, lease time.Duration, limit int) ([]*Task, error) {
+	raw, err := claimDueTasks.Run(ctx, s.client,
+		[]string{SCHEDULER_QUEUE_KEY, SCHEDULER_TASKS_KEY},
+		now.UnixMilli(),
+		now.Add(lease).UnixMilli(),
+		limit,
+	).StringSlice()
+	if err != nil {
+		return nil, err
+	}
+
+	tasks := make([]*Task, 0, len(raw))
+	for _, r := range raw {
+		var task Task
+		if err := json.Unmarshal([]byte(r), &task); err != nil {
+			continue
+		}
+		tasks = append(tasks, &task)
+	}
+	return tasks, nil
+}
+
+// FinishTask removes the task unless the stored copy no longer matches the one that was claimed.
+func (s *redisStore) FinishTask(ctx context.Context, task *Task) error {
+	return s.client.Watch(ctx, func(tx *redis.Tx) error {
+		current, err := tx.HGet(ctx, SCHEDULER_TASKS_KEY, task.ID).Bytes()
+		if err == redis.Nil {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		var stored Task
+		if err := json.Unmarshal(current, &stored); err != nil {
+			return err
+		}
+		if !stored.equal(task) {
+			return nil
+		}
+
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.ZRem(ctx, SCHEDULER_QUEUE_KEY, task.ID)
+			pipe.HDel(ctx, SCHEDULER_TASKS_KEY, task.ID)
+			return nil
+		})
+		return err
+	}, SCHEDULER_TASKS_KEY)
+}
+
+// PendingTasks counts the queued tasks.
+func (s *redisStore) PendingTasks(ctx context.Context) (int64, error) {
+	return s.client.ZCard(ctx, SCHEDULER_QUEUE_KEY).Result()
+}
+
+// MigrateProfiles scans both profile key forms. Legacy "profile::<id>" keys are merged into "profile:<id>" and deleted,
+// then every canonical profile on an old schema version is upgraded and written back.
+func (s *redisStore) MigrateProfiles(ctx context.Context, dryRun bool) (*MigrationReport, error) {
+	report := &MigrationReport{DryRun: dryRun}
+
+	// Sort the keys into both forms first. Writing while scanning could make SCAN return keys twice.
+	legacy, canonical := []string{}, []string{}
+	iter := s.client.Scan(ctx, 0, REDIS_PROFILE_PREFIX+"*", 100).Iterator()
+	for iter.Next(ctx) {
+		key := iter.Val()
+		report.Scanned++
+		if strings.HasPrefix(key, LEGACY_PROFILE_PREFIX) {
+			legacy = append(legacy, key)
+		} else {
+			canonical = append(canonical, key)
+		}
+	}
+	if err := iter.Err(); err != nil {
+		return nil, err
+	}
+
+	// Merge the legacy keys first so the canonical profiles they land in get upgraded along the way
+	merged := map[string]bool{}
+	for _, key := range legacy {
+		merge, err := s.mergeLegacyProfile(ctx, strings.TrimPrefix(key, LEGACY_PROFILE_PREFIX), dryRun)
+		if err != nil {
+			return report, err
+		}
+		if merge != nil {
+			report.Merged = append(report.Merged, *merge)
+			merged[merge.UserID] = true
+		}
+	}
+
+	// Upgrade whatever is still on an old schema version. Merged profiles were upgraded by the merge.
+	for _, key := range canonical {
+		userID := strings.TrimPrefix(key, REDIS_PROFILE_PREFIX)
+		if merged[userID] {
+			continue
+		}
+
+		p, err := s.client.Get(ctx, key).Bytes()
+		if err == redis.Nil {
+			continue
+		}
+		if err != nil {
+			return report, err
+		}
+		var profile Profile
+		if err := json.Unmarshal(p, &profile); err != nil {
+			return report, fmt.Errorf("failed to unmarshal %s: %w", key, err)
+		}
+		if profile.SchemaVersion >= profileSchemaVersion() {
+			continue
+		}
+
+		if !dryRun {
+			// Loading the profile in an update migrates it, and the update writes it back
+			if _, err := s.UpdateProfile(ctx, userID, func(*Profile) error { return nil }); err != nil {
+				return report, err
+			}
+		}
+		report.Upgraded = append(report.Upgraded, userID)
+	}
+
+	return report, nil
+}
+
+// mergeLegacyProfile folds "profile::<id>" into "profile:<id>" and deletes the legacy key.
+// It returns nil if the legacy key disappeared before we got to it.
+func (s *redisStore) mergeLegacyProfile(ctx context.Context, userID string, dryRun bool) (*LegacyMerge, error) {
+	legacyKey := LEGACY_PROFILE_PREFIX + userID
+	key := profileKey(userID)
+
+	var merge *LegacyMerge
+	txf := func(tx *redis.Tx) error {
+		merge = nil
+
+		// Load the legacy profile
+		l, err := tx.Get(ctx, legacyKey).Bytes()
+		if err == redis.Nil {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		var legacy Profile
+		if err := json.Unmarshal(l, &legacy); err != nil {
+			return fmt.Errorf("failed to unmarshal %s: %w", legacyKey, err)
+		}
+
+		// Load the canonical profile, or start a fresh one if there never was one
+		profile := newProfile(userID)
+		p, err := tx.Get(ctx, key).Bytes()
+		if err != nil && err != redis.Nil {
+			return err
+		}
+		if err == nil {
+			if err := json.Unmarshal(p, profile); err != nil {
+				return fmt.Errorf("failed to unmarshal %s: %w", key, err)
+			}
+		}
+		if _, err := migrateProfile(profile); err != nil {
+			return err
+		}
+
+		merge = &LegacyMerge{
+			UserID:           userID,
+			LegacyBalance:    legacy.Balance,
+			CanonicalBalance: profile.Balance,
+			NewBalance:       mergeLegacyBalance(profile.Balance, legacy.Balance),
+		}
+		if delta := merge.NewBalance - profile.Balance; delta != 0 {
+			profile.adjustBalance(delta, LEDGER_LEGACY_MERGE, "")
+		}
+
+		if dryRun {
+			return nil
+		}
+
+		profileBytes, err := json.Marshal(profile)
+		if err != nil {
+			return err
+		}
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.Set(ctx, key, profileBytes, 0)
+			pipe.Del(ctx, legacyKey)
+			appendLedger(ctx, pipe, profile)
+			updateLeaderboards(ctx, pipe, profile)
+			return nil
+		})
+		return err
+	}
+
+	for i := 0; i < maxProfileUpdateAttempts; i++ {
+		err := s.client.Watch(ctx, txf, legacyKey, key)
+		if err == redis.TxFailedErr {
+			continue
+		}
+		return merge, err
+	}
+	return nil, ErrProfileContention
+}
@@ -0,0 +1,195 @@
+package app
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+/*
+The Scheduler
+
+The scheduler runs delayed actions ("tasks") that have to survive a restart. Tasks live in the store.
+In Redis that's a sorted set of task IDs scored by when they're due, and a hash of task ID to the task itself.
+The memory store keeps them in a map, so the scheduler works just the same without Redis.
+
+Every tick the scheduler claims whatever is due by pushing its score out by a lease, runs the handler
+registered for the task's kind, and deletes the task once the handler succeeds. If the handler fails
+or the process dies halfway through, the lease runs out and the task is picked up again. That means
+tasks are delivered at least once, so handlers must be safe to run twice.
+
+Pending tasks are already in the store on startup, and anything that came due while we were down is settled
+on the first tick. The one thing that isn't in the store is jobs taken before there was a scheduler, which were
+finished by a goroutine sleeping until they were due and died with the process that started them. Those are given
+a task when the app starts (see recoverJobs in app/jobs.go).
+*/
+
+const (
+	schedulerInterval  = time.Second // How often we look for due tasks
+	schedulerLease     = time.Minute // How long a claimed task is hidden from other ticks before it's retried
+	schedulerBatchSize = 100         // Most tasks claimed in a single tick
+)
+
+// ErrUnknownTaskKind is returned when a task is scheduled with a kind that has no handler.
+var ErrUnknownTaskKind = errors.New("no handler registered for task kind")
+
+// Task is a delayed action waiting in the scheduler.
+type Task struct {
+	ID      string          `json:"id"`      // Unique ID of the task. Scheduling a task with an existing ID replaces it.
+	Kind    string          `json:"kind"`    // Which handler runs the task
+	Payload json.RawMessage `json:"payload"` // Handler specific data
+	DueAt   int64           `json:"due_at"`  // When the task should run, in unix milliseconds
+}
+
+// TaskHandler runs a task once it comes due.
+type TaskHandler func(a *App, t *Task) error
+
+// Scheduler runs tasks at (or soon after) the time they were scheduled for.
+type Scheduler struct {
+	app      *App
+	mu       sync.RWMutex
+	handlers map[string]TaskHandler
+}
+
+// newScheduler creates a scheduler for the given app.
+func newScheduler(a *App) *Scheduler {
+	return &Scheduler{
+		app:      a,
+		handlers: map[string]TaskHandler{},
+	}
+}
+
+// Handle registers the handler for a kind of task.
+func (s *Scheduler) Handle(kind string, handler TaskHandler) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.handlers[kind] = handler
+}
+
+// Schedule stores a task to run at the given time. The payload is marshalled to JSON.
+func (s *Scheduler) Schedule(id, kind string, at time.Time, payload interface{}) error {
+	if s.handler(kind) == nil {
+		return fmt.Errorf("%w: %s", ErrUnknownTaskKind, kind)
+	}
+
+	payloadBytes, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	task := &Task{
+		ID:      id,
+		Kind:    kind,
+		Payload: payloadBytes,
+		DueAt:   at.UnixMilli(),
+	}
+	return s.app.store.ScheduleTask(s.app.context, task)
+}
+
+// Cancel removes a task from the scheduler. Cancelling a task that doesn't exist is not an error.
+func (s *Scheduler) Cancel(id string) error {
+	return s.app.store.CancelTask(s.app.context, id)
+}
+
+// run ticks until the app starts shutting down.
+func (s *Scheduler) run() {
+	a := s.app
+
+	pending, err := a.store.PendingTasks(a.context)
+	if err != nil {
+		a.logger.Error().
+			Err(err).
+			Msg("failed to count pending tasks")
+	}
+	a.logger.Info().
+		Int64("pending", pending).
+		Msg("starting scheduler")
+
+	ticker := time.NewTicker(schedulerInterval)
+	defer ticker.Stop()
+
+	for {
+		s.tick()
+
+		select {
+		case <-a.stopping:
+			a.logger.Info().
+				Msg("scheduler stopped")
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// tick claims and runs every task that is due.
+func (s *Scheduler) tick() {
+	a := s.app
+	now := time.Now()
+
+	tasks, err := a.store.ClaimDueTasks(a.context, now, schedulerLease, schedulerBatchSize)
+	if err != nil {
+		a.logger.Error().
+			Err(err).
+			Msg("failed to claim due tasks")
+		return
+	}
+
+	for _, task := range tasks {
+		s.runTask(task)
+	}
+}
+
+// runTask runs a claimed task and removes it from the scheduler if it succeeded.
+func (s *Scheduler) runTask(task *Task) {
+	a := s.app
+
+	handler := s.handler(task.Kind)
+	if handler == nil {
+		a.logger.Error().
+			Str("task", task.ID).
+			Str("kind", task.Kind).
+			Msg("no handler for task, will retry after lease")
+		return
+	}
+
+	a.logger.Debug().
+		Str("task", task.ID).
+		Str("kind", task.Kind).
+		Str("late_by", time.Since(time.UnixMilli(task.DueAt)).String()).
+		Msg("running task")
+
+	if err := handler(a, task); err != nil {
+		a.logger.Error().
+			Err(err).
+			Str("task", task.ID).
+			Str("kind", task.Kind).
+			Msg("task failed, will retry after lease")
+		return
+	}
+
+	// Remove the task unless it was rescheduled while the handler was running
+	if err := a.store.FinishTask(a.context, task); err != nil {
+		a.logger.Error().
+			Err(err).
+			Str("task", task.ID).
+			Msg("failed to remove finished task, it will run again after lease")
+	}
+}
+
+// handler returns the handler for a kind of task, or nil if there isn't one.
+func (s *Scheduler) handler(kind string) TaskHandler {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.handlers[kind]
+}
+
+// equal reports whether two tasks are the same, including when they're due.
+func (t *Task) equal(other *Task) bool {
+	return t.ID == other.ID &&
+		t.Kind == other.Kind &&
+		t.DueAt == other.DueAt &&
+		bytes.Equal(t.Payload, other.Payload)
+}
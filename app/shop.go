@@ -0,0 +1,201 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+/*
+The Shop
+
+The shop is where money leaves the economy. It sells everything in the item catalog (see app/catalog.go)
+at its list price and buys items back for a cut of that, set by Config.Economy.SellBackPercent.
+
+Buying and selling change the balance and the inventory in a single updateProfile, so nobody ends up
+paying for an item they didn't get or getting an item they didn't pay for.
+*/
+
+var (
+	// ErrStackFull is returned when buying would put a user over an item's stack limit.
+	ErrStackFull = errors.New("stack full")
+
+	// ErrNotEnoughItems is returned when a user tries to sell more of an item than they have.
+	ErrNotEnoughItems = errors.New("not enough items")
+)
+
+// The !shop, !buy and !sell commands
+func init() {
+	commands.Register(
+		&Command{
+			Name:    "shop",
+			Aliases: []string{"store"},
+			Group:   "Shop",
+			Summary: "See what's for sale",
+			Help:    "Lists everything the shop sells, with prices. Buy with `{prefix}buy` and sell things back with `{prefix}sell`.",
+			Handler: handleShopCommand,
+			Subcommands: []*Command{
+				helpSubcommand(),
+			},
+		},
+		&Command{
+			Name:    "buy",
+			Group:   "Shop",
+			Summary: "Buy something from the shop",
+			Help:    "Buy an item from the shop. Items are named by their ID or their name. Names with spaces need quotes.",
+			Args: []ArgSpec{
+				{Name: "item", Description: "What to buy"},
+				{Name: "quantity", Description: "How many to buy. Defaults to 1.", Type: ArgInt, Optional: true},
+			},
+			Examples: []string{"buy stim-pack", "buy \"rat meat pie\" 3"},
+			LockedAt: STANDING_LOCKED,
+			Handler:  handleBuyCommand,
+			Subcommands: []*Command{
+				helpSubcommand(),
+			},
+		},
+		&Command{
+			Name:    "sell",
+			Group:   "Shop",
+			Summary: "Sell something back to the shop",
+			Help:    "Sell an item back to the shop for part of what it costs.",
+			Args: []ArgSpec{
+				{Name: "item", Description: "What to sell"},
+				{Name: "quantity", Description: "How many to sell. Defaults to 1.", Type: ArgInt, Optional: true},
+			},
+			Examples: []string{"sell stim-pack", "sell \"rat meat pie\" 3"},
+			LockedAt: STANDING_LOCKED,
+			Handler:  handleSellCommand,
+			Subcommands: []*Command{
+				helpSubcommand(),
+			},
+		},
+	)
+}
+
+// sellPrice returns what the shop pays for one of an item.
+func (a *App) sellPrice(item *Item) int {
+	return item.Price * a.Config.Economy.SellBackPercent / 100
+}
+
+// handleShopCommand handles the !shop command. It lists the catalog from cheapest to priciest.
+func handleShopCommand(a *App, m *Message, args *Args) error {
+	items := append([]*Item{}, catalog.Items...)
+	sort.SliceStable(items, func(i, j int) bool {
+		return items[i].Price < items[j].Price
+	})
+
+	lines := []string{"** The Shop **"}
+	for _, item := range items {
+		lines = append(lines, fmt.Sprintf("- %s (`%s`) - %d dollars, %s. %s", item.Name, item.ID, item.Price, item.Rarity, item.Description))
+	}
+	lines = append(lines, "", fmt.Sprintf("The shop buys things back for %d%% of the price.", a.Config.Economy.SellBackPercent))
+	return a.handleOutgoingMessage(m.RespondToChannelOrThread(a.Config.AppID, strings.Join(lines, "\n"), true, false))
+}
+
+// handleBuyCommand handles the !buy command.
+func handleBuyCommand(a *App, m *Message, args *Args) error {
+	item, quantity, ok, err := shopArgs(a, m, args)
+	if !ok || err != nil {
+		return err
+	}
+	cost := item.Price * quantity
+
+	var balance, held int
+	profile, err := a.updateProfile(m.Author.ID, func(p *Profile) error {
+		balance, held = p.Balance, p.Inventory.quantity(item.ID)
+		if held+quantity > item.StackLimit {
+			return ErrStackFull
+		}
+		if p.Balance < cost {
+			return ErrInsufficientFunds
+		}
+		p.adjustBalance(-cost, LEDGER_SHOP_BUY, fmt.Sprintf("%s x%d", item.ID, quantity))
+		p.Inventory.addItem(item, quantity, map[string]string{
+			"source":     "shop",
+			"price_paid": strconv.Itoa(item.Price),
+		})
+		return nil
+	})
+
+	msg := ""
+	switch err {
+	case nil:
+		a.logger.Info().
+			Str("user", m.Author.Username).
+			Str("item", item.ID).
+			Int("quantity", quantity).
+			Int("cost", cost).
+			Msg("item bought")
+		msg = fmt.Sprintf("You bought %d %s for %d dollars. You now have %d of them and %d dollars.",
+			quantity, item.Name, cost, profile.Inventory.quantity(item.ID), profile.Balance)
+	case ErrStackFull:
+		msg = fmt.Sprintf("You can only carry %d %s and you already have %d.", item.StackLimit, item.Name, held)
+	case ErrInsufficientFunds:
+		msg = fmt.Sprintf("That costs %d dollars and you only have %d.", cost, balance)
+	default:
+		return err
+	}
+	return a.handleOutgoingMessage(m.RespondToChannelOrThread(a.Config.AppID, msg, true, false))
+}
+
+// handleSellCommand handles the !sell command.
+func handleSellCommand(a *App, m *Message, args *Args) error {
+	item, quantity, ok, err := shopArgs(a, m, args)
+	if !ok || err != nil {
+		return err
+	}
+	earned := a.sellPrice(item) * quantity
+
+	var held int
+	profile, err := a.updateProfile(m.Author.ID, func(p *Profile) error {
+		held = p.Inventory.quantity(item.ID)
+		if !p.Inventory.removeItem(item.ID, quantity) {
+			return ErrNotEnoughItems
+		}
+		p.adjustBalance(earned, LEDGER_SHOP_SELL, fmt.Sprintf("%s x%d", item.ID, quantity))
+		return nil
+	})
+
+	msg := ""
+	switch err {
+	case nil:
+		a.logger.Info().
+			Str("user", m.Author.Username).
+			Str("item", item.ID).
+			Int("quantity", quantity).
+			Int("earned", earned).
+			Msg("item sold")
+		msg = fmt.Sprintf("You sold %d %s for %d dollars. You now have %d dollars.", quantity, item.Name, earned, profile.Balance)
+	case ErrNotEnoughItems:
+		msg = fmt.Sprintf("You can't sell %d %s, you only have %d.", quantity, item.Name, held)
+	default:
+		return err
+	}
+	return a.handleOutgoingMessage(m.RespondToChannelOrThread(a.Config.AppID, msg, true, false))
+}
+
+// shopArgs looks up the item and quantity of a !buy or !sell. If either is no good it tells the user and returns false.
+func shopArgs(a *App, m *Message, args *Args) (*Item, int, bool, error) {
+	item := catalog.find(args.String("item"))
+	if item == nil {
+		msg := fmt.Sprintf("The shop doesn't have anything called `%s`. Type `%sshop` to see what it does have.", args.String("item"), args.Prefix)
+		return nil, 0, false, a.handleOutgoingMessage(m.RespondToChannelOrThread(a.Config.AppID, msg, true, false))
+	}
+
+	quantity := 1
+	if args.Has("quantity") {
+		quantity = args.Int("quantity")
+	}
+	if quantity < 1 {
+		return nil, 0, false, a.handleOutgoingMessage(m.RespondToChannelOrThread(a.Config.AppID, "You have to pick at least one.", true, false))
+	}
+	// Nobody can hold more than the stack limit, so anything above it can't be right. This also keeps the cost from overflowing.
+	if quantity > item.StackLimit {
+		msg := fmt.Sprintf("You can only carry %d %s at a time.", item.StackLimit, item.Name)
+		return nil, 0, false, a.handleOutgoingMessage(m.RespondToChannelOrThread(a.Config.AppID, msg, true, false))
+	}
+	return item, quantity, true, nil
+}
@@ -0,0 +1,83 @@
+package app
+
+import (
+	"context"
+	"time"
+)
+
+/*
+The Store
+
+Everything the game remembers goes through the Store interface. The Redis implementation in
+app/redisStore.go is what runs in production. The in-memory implementation in app/memoryStore.go
+keeps everything in the process, which is handy for tests and for hacking on the game locally.
+
+New subsystems that need to persist something should add methods here and implement them in both stores.
+*/
+
+// Store is the persistence layer of the game.
+type Store interface {
+	// GetProfile returns the profile for the given user, creating it if it doesn't exist.
+	// Profiles on an old schema version are migrated before they are returned.
+	GetProfile(ctx context.Context, userID string) (*Profile, error)
+	// FindProfile returns the profile for the given user, or nil if they don't have one. Unlike GetProfile it never creates one,
+	// so it's what looking somebody else up should use. Profiles on an old schema version are migrated before they are returned.
+	FindProfile(ctx context.Context, userID string) (*Profile, error)
+	// UpdateProfile atomically loads, changes and saves the profile for the given user. See App.updateProfile.
+	UpdateProfile(ctx context.Context, userID string, fn func(*Profile) error) (*Profile, error)
+	// UpdateProfiles atomically loads, changes and saves the profiles for several users. See App.updateProfiles.
+	UpdateProfiles(ctx context.Context, userIDs []string, fn func([]*Profile) error) ([]*Profile, error)
+	// ProfileIDs returns the ID of every stored profile.
+	ProfileIDs(ctx context.Context) ([]string, error)
+	// MigrateProfiles brings every stored profile up to date. See app/migrations.go
+	MigrateProfiles(ctx context.Context, dryRun bool) (*MigrationReport, error)
+
+	// LedgerEntries returns the most recent ledger entries of a user, newest first. A limit of 0 returns all of them.
+	// Entries are written by UpdateProfile and UpdateProfiles along with the profile. See app/ledger.go
+	LedgerEntries(ctx context.Context, userID string, limit int) ([]LedgerEntry, error)
+
+	// Leaderboard returns a page of a leaderboard. Leaderboards are updated by UpdateProfile and UpdateProfiles. See app/leaderboard.go
+	Leaderboard(ctx context.Context, query LeaderboardQuery) (*LeaderboardPage, error)
+	// RememberUser records the name a user goes by and, unless guildID is empty, that they're a member of the guild.
+	RememberUser(ctx context.Context, guildID, userID, name string) error
+	// DisplayNames returns the remembered names of the given users. Users without a name are left out.
+	DisplayNames(ctx context.Context, userIDs []string) (map[string]string, error)
+	// RememberDMChannel records the channel of the user's DMs with the bot on a gateway, so the bot can message them first later.
+	RememberDMChannel(ctx context.Context, gatewayID, userID, channelID string) error
+	// DMChannel returns the remembered DM channel of the user on a gateway, or an empty string if they've never DMed the bot there.
+	DMChannel(ctx context.Context, gatewayID, userID string) (string, error)
+
+	// GetGuildSettings returns the settings for the given guild. A guild without settings gets the defaults.
+	GetGuildSettings(ctx context.Context, guildID string) (*GuildSettings, error)
+	// UpdateGuildSettings atomically loads, changes and saves the settings for a guild. See App.updateGuildSettings.
+	UpdateGuildSettings(ctx context.Context, guildID string, fn func(*GuildSettings) error) (*GuildSettings, error)
+
+	// StartCooldown starts a cooldown on an action for a user unless one is already running at now.
+	// It returns what's left of the running cooldown, or 0 if a new one was started.
+	StartCooldown(ctx context.Context, userID, action string, now time.Time, cooldown time.Duration) (time.Duration, error)
+	// ClearCooldown ends a cooldown early. Clearing a cooldown that isn't running is not an error.
+	ClearCooldown(ctx context.Context, userID, action string) error
+	// Cooldowns returns every cooldown the user is waiting on at now.
+	Cooldowns(ctx context.Context, userID string, now time.Time) ([]Cooldown, error)
+	// StrikeCooldown counts the user running into the cooldown on an action that runs until expiresAt.
+	// It returns how many times they have so far. The count starts over with the next cooldown.
+	StrikeCooldown(ctx context.Context, userID, action string, expiresAt time.Time) (int64, error)
+
+	// SetConfirmation stores something for the user to confirm, replacing whatever was waiting for the same action.
+	SetConfirmation(ctx context.Context, userID, action string, payload []byte, ttl time.Duration) error
+	// TakeConfirmation removes and returns what was waiting to be confirmed, or nil if nothing was.
+	TakeConfirmation(ctx context.Context, userID, action string) ([]byte, error)
+
+	// ScheduleTask stores a task, replacing any task with the same ID.
+	ScheduleTask(ctx context.Context, task *Task) error
+	// HasTask reports whether a task with the given ID is waiting to run.
+	HasTask(ctx context.Context, id string) (bool, error)
+	// CancelTask removes a task. Removing a task that doesn't exist is not an error.
+	CancelTask(ctx context.Context, id string) error
+	// ClaimDueTasks returns up to limit tasks due at now and hides them from other claims until the lease runs out.
+	ClaimDueTasks(ctx context.Context, now time.Time, lease time.Duration, limit int) ([]*Task, error)
+	// FinishTask removes a claimed task, unless it was rescheduled since it was claimed.
+	FinishTask(ctx context.Context, task *Task) error
+	// PendingTasks returns how many tasks are waiting to run.
+	PendingTasks(ctx context.Context) (int64, error)
+}
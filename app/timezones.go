@@ -0,0 +1,115 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	_ "time/tzdata" // The bot runs in containers without a zoneinfo database
+)
+
+/*
+Time Zones
+
+Some things happen once a day, like getting a new job board. "A day" is the user's day: it starts at midnight where
+they are, which they tell the bot with !timezone. Until they do, it's Config.Economy.DefaultTimezone.
+
+Time zones go by their names in the tz database, like Europe/Berlin. The database is built into the bot, so it doesn't
+matter what the machine it runs on has installed.
+
+Days are stored as YYYY-MM-DD, which sorts the same as the days themselves. Comparing them as strings is enough.
+*/
+
+// dayLayout is how days are written down
+const dayLayout = "2006-01-02"
+
+// ErrUnknownTimezone is returned for a time zone that isn't in the tz database.
+var ErrUnknownTimezone = errors.New("unknown time zone")
+
+// The !timezone command
+func init() {
+	commands.Register(&Command{
+		Name:    "timezone",
+		Aliases: []string{"tz"},
+		Group:   "General",
+		Summary: "See or set your time zone",
+		Help: "Your day starts at midnight in your time zone, and that's when you get a new job board.\n" +
+			"Time zones go by their names in the tz database, like `Europe/Berlin` or `America/New_York`.",
+		Args: []ArgSpec{
+			{Name: "zone", Description: "Your time zone. Leave it out to see the one you have.", Optional: true},
+		},
+		Examples: []string{"timezone", "timezone America/New_York"},
+		Handler:  handleTimezoneCommand,
+		Subcommands: []*Command{
+			helpSubcommand(),
+		},
+	})
+}
+
+// loadTimezone looks up a time zone by name. Unlike time.LoadLocation it doesn't take "Local",
+// which would be wherever the bot happens to run, or an empty name.
+func loadTimezone(name string) (*time.Location, error) {
+	if name == "" || name == "Local" {
+		return nil, ErrUnknownTimezone
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return nil, ErrUnknownTimezone
+	}
+	return loc, nil
+}
+
+// location returns the time zone the user's days are counted in.
+func (p *Profile) location(e EconomyConfig) *time.Location {
+	if loc, err := loadTimezone(p.Timezone); err == nil {
+		return loc
+	}
+	if loc, err := loadTimezone(e.DefaultTimezone); err == nil {
+		return loc
+	}
+	return time.UTC
+}
+
+// dayOf returns the day it is in loc at t.
+func dayOf(t time.Time, loc *time.Location) string {
+	return t.In(loc).Format(dayLayout)
+}
+
+// nextDay returns when the next day starts in loc after t.
+func nextDay(t time.Time, loc *time.Location) time.Time {
+	year, month, day := t.In(loc).Date()
+	return time.Date(year, month, day+1, 0, 0, 0, 0, loc)
+}
+
+// handleTimezoneCommand handles the !timezone command. Without an argument it says which time zone the user is in.
+func handleTimezoneCommand(a *App, m *Message, args *Args) error {
+	e := a.Config.Economy
+	if !args.Has("zone") {
+		profile, err := a.getProfile(m.Author.ID)
+		if err != nil {
+			return err
+		}
+		loc := profile.location(e)
+		msg := fmt.Sprintf("Your days run on %s time. It's %s there, and your day ends in %s.",
+			loc, time.Now().In(loc).Format("15:04"), formatDuration(time.Until(nextDay(time.Now(), loc))))
+		if profile.Timezone == "" {
+			msg += fmt.Sprintf(" That's the default. Type `%stimezone <zone>` to set your own.", args.Prefix)
+		}
+		return a.handleOutgoingMessage(m.RespondToChannelOrThread(a.Config.AppID, msg, true, false))
+	}
+
+	loc, err := loadTimezone(args.String("zone"))
+	if err != nil {
+		msg := fmt.Sprintf("I've never heard of '%s'. Try a name from the tz database, like `Europe/Berlin` or `America/New_York`.", args.String("zone"))
+		return a.handleOutgoingMessage(m.RespondToChannelOrThread(a.Config.AppID, msg, true, false))
+	}
+	if _, err := a.updateProfile(m.Author.ID, func(p *Profile) error {
+		p.Timezone = loc.String()
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	msg := fmt.Sprintf("Got it, your days run on %s time now. It's %s there.", loc, time.Now().In(loc).Format("15:04"))
+	return a.handleOutgoingMessage(m.RespondToChannelOrThread(a.Config.AppID, msg, true, false))
+}
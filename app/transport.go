@@ -0,0 +1,188 @@
+package app
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/bytebot-chat/gateway-discord/model"
+	uuid "github.com/satori/go.uuid"
+)
+
+/*
+Transports
+
+The app talks to the gateway over a transport: it subscribes to Config.InboundTopic for the messages people send and
+publishes what it has to say to Config.OutboundTopic. In production that's Redis pubsub, which is what the gateway speaks.
+
+The console transport is for hacking on the game without any services at all. It reads messages from stdin and prints
+what the bot says to stdout, as if everything was said by one admin in one server. Config.Transport picks the transport,
+and by default it follows the store: Redis for the Redis store, the console for the memory store. So `-store memory`
+runs without Redis, scheduled tasks and all, since the scheduler keeps its tasks in the store too.
+*/
+
+// Transports
+const (
+	TRANSPORT_REDIS   = "redis"   // Redis pubsub, to and from the gateway
+	TRANSPORT_CONSOLE = "console" // stdin and stdout
+)
+
+// Who the console transport says messages are from
+const (
+	CONSOLE_GATEWAY = "console"
+	CONSOLE_GUILD   = "console-guild"
+	CONSOLE_CHANNEL = "console-channel"
+	CONSOLE_USER    = "console-user"
+)
+
+// Transport carries messages between the app and the gateway.
+type Transport interface {
+	// Subscribe starts delivering the payloads of messages published to topic. It returns once the subscription is live.
+	Subscribe(ctx context.Context, topic string) (Subscription, error)
+	// Publish sends a payload to everybody subscribed to topic.
+	Publish(ctx context.Context, topic string, payload []byte) error
+}
+
+// Subscription is a live subscription to a topic.
+type Subscription interface {
+	// Channel delivers the payloads. It's closed if the subscription goes away.
+	Channel() <-chan []byte
+	// Close ends the subscription.
+	Close() error
+}
+
+// redisTransport is Redis pubsub.
+type redisTransport struct {
+	client *Redis
+}
+
+// Subscribe subscribes to a Redis channel and waits for Redis to confirm it.
+func (t *redisTransport) Subscribe(ctx context.Context, topic string) (Subscription, error) {
+	pubsub := t.client.Subscribe(ctx, topic)
+	if _, err := pubsub.Receive(ctx); err != nil {
+		pubsub.Close()
+		return nil, err
+	}
+
+	sub := &redisSubscription{
+		pubsub:   pubsub,
+		payloads: make(chan []byte),
+		closed:   make(chan struct{}),
+	}
+	go func() {
+		defer close(sub.payloads)
+		for msg := range pubsub.Channel() {
+			select {
+			case sub.payloads <- []byte(msg.Payload):
+			case <-sub.closed:
+				return
+			}
+		}
+	}()
+	return sub, nil
+}
+
+// Publish publishes to a Redis channel.
+func (t *redisTransport) Publish(ctx context.Context, topic string, payload []byte) error {
+	return t.client.Publish(ctx, topic, payload).Err()
+}
+
+// redisSubscription is a subscription to a Redis channel.
+type redisSubscription struct {
+	pubsub   interface{ Close() error }
+	payloads chan []byte
+	closed   chan struct{}
+	once     sync.Once
+}
+
+func (s *redisSubscription) Channel() <-chan []byte { return s.payloads }
+
+func (s *redisSubscription) Close() error {
+	s.once.Do(func() { close(s.closed) })
+	return s.pubsub.Close()
+}
+
+// consoleTransport reads messages from in and writes what the bot says to out.
+type consoleTransport struct {
+	in  io.Reader
+	out io.Writer
+	mu  sync.Mutex // Keeps replies from interleaving
+}
+
+// newConsoleTransport creates a console transport.
+func newConsoleTransport(in io.Reader, out io.Writer) *consoleTransport {
+	return &consoleTransport{in: in, out: out}
+}
+
+// Subscribe turns every line of input into a message. Every topic gets the same input, so subscribe to one.
+// The channel stays open when the input runs out, so the app keeps running scheduled tasks until it's stopped.
+func (t *consoleTransport) Subscribe(ctx context.Context, topic string) (Subscription, error) {
+	sub := &consoleSubscription{payloads: make(chan []byte), closed: make(chan struct{})}
+	go func() {
+		scanner := bufio.NewScanner(t.in)
+		for scanner.Scan() {
+			if scanner.Text() == "" {
+				continue
+			}
+			payload, err := consoleMessage(scanner.Text()).MarshalJSON()
+			if err != nil {
+				continue
+			}
+			select {
+			case sub.payloads <- payload:
+			case <-sub.closed:
+				return
+			}
+		}
+	}()
+	return sub, nil
+}
+
+// Publish prints what the bot says, along with where it said it.
+func (t *consoleTransport) Publish(ctx context.Context, topic string, payload []byte) error {
+	var m model.MessageSend
+	if err := json.Unmarshal(payload, &m); err != nil {
+		return err
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	_, err := fmt.Fprintf(t.out, "[%s] %s\n", m.ChannelID, m.Content)
+	return err
+}
+
+// consoleMessage makes a message from a line of console input.
+func consoleMessage(content string) *model.Message {
+	return &model.Message{
+		Message: &discordgo.Message{
+			ID:        uuid.NewV4().String(),
+			ChannelID: CONSOLE_CHANNEL,
+			GuildID:   CONSOLE_GUILD,
+			Content:   content,
+			Author:    &discordgo.User{ID: CONSOLE_USER, Username: "console", Discriminator: "0000"},
+			Member:    &discordgo.Member{},
+		},
+		Metadata: model.Metadata{
+			Source: CONSOLE_GATEWAY,
+			ID:     uuid.NewV4(),
+		},
+	}
+}
+
+// consoleSubscription is the console's input.
+type consoleSubscription struct {
+	payloads chan []byte
+	closed   chan struct{}
+	once     sync.Once
+}
+
+func (s *consoleSubscription) Channel() <-chan []byte { return s.payloads }
+
+func (s *consoleSubscription) Close() error {
+	s.once.Do(func() { close(s.closed) })
+	return nil
+}
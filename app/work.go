@@ -1,9 +1,8 @@
 package app
 
 import (
-	"errors"
 	"strconv"
-	"strings"
+	"time"
 )
 
 /*
@@ -14,93 +13,94 @@ to experiment with stateful applications in bytebot using only the core features
 
 Normally, sane people here would use a real database to store state. But we're not sane people.
 
-At this point in time, the work system is pretty simple. Punch the clock, get paid. No cooldown. It's a start.
-
-Right now what I'd like to see is just that users can work, get paid, and check their balance. Eventually we
-can add a cooldown, and maybe even a way to check how long until you can work again.
+At this point in time, the work system is pretty simple. Punch the clock, get paid, wait for your next shift.
+The wait is a cooldown (see app/cooldowns.go) so people can't just spam !work in a loop to print money.
+!cooldowns tells you how long until you can work again. Every shift is worth some XP too, see app/levels.go.
 
 */
 
-const workHelpResponse = `
-** Working **
-Achieve class consciousness by punching the clock and earning your daily wage.
-
-## Commands
-- !work - Punch the clock and earn your daily wage
-- !work help - Get help with the work system (you're looking at it)
-`
-
-const workUnknownCommandResponse = `I don't know what you mean by that. Try !work help.`
-
-// handleWork handles the !work command. It represents the entrypoint for the work system.
-// to parse the commands, each function should strip the 0th element from the splitCmd slice
-// and pass the rest of the slice to the next function until the command is fully parsed.
-func handleWork(a *App, m *Message) error {
-	// Split the incoming message into a slice of strings
-	splitCmd := strings.Split(m.Content, " ")
-
-	// Pop the first element off the slice to get the command
-	cmd, splitCmd := splitCmd[0], splitCmd[1:]
-
-	// Make sure the command is !work
-	if cmd != "!work" {
-		return errors.New("invalid command for handleWork. expected !work, got " + cmd)
-	}
-
-	// If there are no more elements in the slice, we're at the end of the command chain
-	// and we can handle the command.
-	if len(splitCmd) == 0 {
-		return handleWorkCommand(a, m, []string{})
-	}
-
-	// Otherwise, we need to keep parsing the command.
-	// Pop the next element off the slice to get the subcommand
-	subCmd, splitCmd := splitCmd[0], splitCmd[1:]
-
-	// Switch on the subcommand
-	switch subCmd {
-	case "help":
-		return handleWorkHelp(a, m, splitCmd)
-	default:
-		return handleWorkUnknownCommand(a, m, splitCmd)
-	}
+// The !work command tree. It represents the entrypoint for the work system.
+func init() {
+	commands.Register(&Command{
+		Name:    "work",
+		Group:   "Work",
+		Summary: "Punch the clock and earn your daily wage",
+		Help: "Achieve class consciousness by punching the clock and earning your daily wage.\n" +
+			"You can only work one shift at a time, so you'll have to wait a bit before working again.",
+		LockedAt: STANDING_JAILED,
+		Handler:  handleWorkCommand,
+		Subcommands: []*Command{
+			helpSubcommand(),
+		},
+	})
 }
 
 // handleWorkCommand handles the bare !work command.
 // It should generate some amount of currency and add it to the user's balance.
 // The amount of currency should eventually come from a function that takes the user's
 // profile and returns a scaled or leveled amount of currency.
-func handleWorkCommand(a *App, m *Message, args []string) error {
-	// Get the user's profile
-	profile, err := a.getProfile(m.Author.ID)
+func handleWorkCommand(a *App, m *Message, args *Args) error {
+	// Clock in, unless the last shift was too recent
+	remaining, err := a.startCooldown(m.Author.ID, COOLDOWN_WORK, a.Config.Economy.WorkCooldown)
 	if err != nil {
 		return err
 	}
+	if remaining > 0 {
+		a.logger.Info().
+			Str("user", m.Author.Username).
+			Dur("remaining", remaining).
+			Msg("User tried to work during cooldown")
+		message := "You just worked a shift. Your next shift starts in " + formatDuration(remaining) + "."
+
+		// Asking over and over doesn't make it come any sooner
+		demerit, jailed, err := a.strikeCooldown(m.Author.ID, COOLDOWN_WORK, remaining)
+		if err != nil {
+			return err
+		}
+		if jailed {
+			message += " You've been told. That's a demerit, and it lands you in jail."
+		} else if demerit {
+			message += " You've been told. That's a demerit."
+		}
+		return a.handleOutgoingMessage(m.RespondToChannelOrThread(a.Config.AppID, message, true, false))
+	}
 
 	// Call the work method on the profile to update the balance and get the amount of currency earned
-	earned, err := profile.work(a)
-
-	// If there was an error, return it
+	var earned, levels int
+	profile, err := a.updateProfile(m.Author.ID, func(p *Profile) error {
+		earned = p.work(a)
+		levels = p.gainXP(a.Config.Economy, a.Config.Economy.XPPerWork)
+		return nil
+	})
+
+	// If there was an error, give the user their shift back and return it
 	if err != nil {
+		a.clearCooldown(m.Author.ID, COOLDOWN_WORK)
 		return err
 	}
 
 	// Otherwise, send a message to the channel or thread with the amount of currency earned
 	balance := strconv.Itoa(profile.Balance)
 	message := "You earned " + strconv.Itoa(earned) + " bucks. You now have " + balance + " bucks."
-	resp := m.RespondToChannelOrThread("dbtg", message, true, false)
-
-	return a.handleOutgoingMessage(resp)
-}
-
-// handleWorkHelp handles the !work help command.
-func handleWorkHelp(a *App, m *Message, args []string) error {
-	resp := m.RespondToChannelOrThread("dbtg", workHelpResponse, true, false)
-	return a.handleOutgoingMessage(resp)
-}
+	if profile.standing(a.Config.Economy, time.Now()) >= STANDING_PROBATION {
+		message += " That's after a " + strconv.Itoa(a.Config.Economy.DemeritCutPercent) + "% cut for your demerits."
+	}
+	if a.Config.Economy.XPPerWork > 0 {
+		message += " (+" + strconv.Itoa(a.Config.Economy.XPPerWork) + " XP)"
+	}
+	if a.Config.Economy.WorkCooldown > 0 {
+		message += " Your next shift starts in " + formatDuration(a.Config.Economy.WorkCooldown) + "."
+	}
+	resp := m.RespondToChannelOrThread(a.Config.AppID, message, true, false)
+	if err := a.handleOutgoingMessage(resp); err != nil {
+		return err
+	}
 
-// handleWorkUnknownCommand handles an unknown command for the work system.
-func handleWorkUnknownCommand(a *App, m *Message, args []string) error {
-	resp := m.RespondToChannelOrThread("dbtg", workUnknownCommandResponse, true, false)
-	return a.handleOutgoingMessage(resp)
+	if levels > 0 {
+		level := profile.level(a.Config.Economy)
+		if err := a.announceLevelUp(m.Metadata.Source, m.GuildID, m.ChannelID, m.Author.ID, level, levels); err != nil {
+			a.logLevelUpError(err, m.Author.ID, level)
+		}
+	}
+	return nil
 }
@@ -3,16 +3,17 @@ module github.com/bytebot-chat/dont-break-the-chat
 go 1.17
 
 require (
+	github.com/bwmarrin/discordgo v0.26.1
 	github.com/bytebot-chat/gateway-discord v0.2.1
 	github.com/rs/zerolog v1.28.0
+	github.com/satori/go.uuid v1.2.0
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
-	github.com/bwmarrin/discordgo v0.26.1 // indirect
 	github.com/cespare/xxhash/v2 v2.1.2 // indirect
 	github.com/dgryski/go-rendezvous v0.0.0-20200823014737-9f7001d12a5f // indirect
 	github.com/gorilla/websocket v1.5.0 // indirect
-	github.com/satori/go.uuid v1.2.0 // indirect
 	golang.org/x/crypto v0.3.0 // indirect
 	gopkg.in/check.v1 v1.0.0-20201130134442-10cb98267c6c // indirect
 )
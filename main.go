@@ -1,33 +1,92 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
 	"fmt"
 	"os"
+	"strings"
 
 	dbtc "github.com/bytebot-chat/dont-break-the-chat/app"
-	"github.com/rs/zerolog"
 )
 
+const usage = `Usage:
+  dont-break-the-chat [flags]                    Run the bot
+  dont-break-the-chat migrate [-dry-run] [flags] Merge legacy profile keys and upgrade profiles to the current schema
+
+Run with -h to see the flags.
+`
+
 func main() {
-	// Parse arguments from command line and environment variables
-	config := dbtc.Config{
-		RedisHost:     "localhost",
-		RedisPort:     6379,
-		InboundTopic:  "discord:inbound",
-		OutboundTopic: "discord:outbound",
-		LogLevel:      zerolog.Level(zerolog.DebugLevel),
+	// Pick the command. Running the bot is the default.
+	command, args := "run", os.Args[1:]
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		command, args = args[0], args[1:]
 	}
 
-	// Create a new app instance
-	app, err := dbtc.NewApp(config)
+	// The migrate command has a flag of its own that the config doesn't know about
+	dryRun := false
+	if command == "migrate" {
+		dryRun, args = popFlag(args, "dry-run")
+	}
+
+	// Parse arguments from command line, environment variables and the config file
+	config, err := dbtc.LoadConfig(args)
+	if errors.Is(err, flag.ErrHelp) {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(0)
+	}
 	if err != nil {
-		fmt.Errorf("failed to create app: %w", err)
+		fmt.Fprintf(os.Stderr, "failed to load config: %v\n", err)
 		os.Exit(1)
 	}
 
-	// Start the app
-	if err := app.Start(); err != nil {
-		fmt.Errorf("failed to start app: %w", err)
+	// Pick where the game state lives. Leaving it nil keeps it in Redis.
+	var store dbtc.Store
+	if config.Store == "memory" {
+		store = dbtc.NewMemoryStore()
+	}
+
+	// Create a new app instance
+	app, err := dbtc.NewApp(config, store)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to create app: %v\n", err)
 		os.Exit(1)
 	}
+
+	switch command {
+	case "run":
+		// Start the app. This blocks until we receive SIGINT/SIGTERM.
+		if err := app.Start(context.Background()); err != nil {
+			fmt.Fprintf(os.Stderr, "app exited with error: %v\n", err)
+			os.Exit(1)
+		}
+	case "migrate":
+		report, err := app.MigrateProfiles(context.Background(), dryRun)
+		if report != nil {
+			fmt.Println(report)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "migration failed: %v\n", err)
+			os.Exit(1)
+		}
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n%s", command, usage)
+		os.Exit(2)
+	}
+}
+
+// popFlag removes a boolean flag from args and reports whether it was there.
+func popFlag(args []string, name string) (bool, []string) {
+	found := false
+	rest := []string{}
+	for _, arg := range args {
+		if arg == "-"+name || arg == "--"+name {
+			found = true
+			continue
+		}
+		rest = append(rest, arg)
+	}
+	return found, rest
 }